@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pelletier/go-toml/v2"
@@ -28,16 +28,39 @@ type EnvConfig struct {
 	// Claude Code 特有配置 (值为 "0" 或 "1"，空字符串表示不设置)
 	AttributionHeader          string `json:"attribution_header"`
 	DisableNonessentialTraffic string `json:"disable_nonessential_traffic"`
+	// 认证方式（按 Provider 解释）：Codex 为 apikey / chatgpt / env_key；
+	// Gemini 为 gemini-api-key / oauth-personal / vertex-ai
+	AuthMode string `json:"auth_mode,omitempty"`
+	// Claude 后端类型：api / relay / bedrock / vertex，空字符串表示自由变量模式
+	Backend string `json:"backend,omitempty"`
+	// Claude 模型分级映射（展开为 ANTHROPIC_MODEL / ANTHROPIC_DEFAULT_*_MODEL 等变量）
+	ModelMapping *ClaudeModelMapping `json:"model_mapping,omitempty"`
+	// OpenClaw 命名 agents / providers（应用时替换配置文件中的对应条目）
+	Openclaw *OpenclawConfig `json:"openclaw,omitempty"`
+	// 引用的权限配置名称（PermissionProfile.Name），空表示不管理权限
+	PermissionProfile string `json:"permission_profile,omitempty"`
+	// 引用的提示词变体名称（PromptVariant.Name），空表示使用用户原有的指令文件
+	PromptVariant string `json:"prompt_variant,omitempty"`
+	// HTTP 代理设置，nil 表示使用全局设置
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+	// 上游接口协议：anthropic / openai-chat / openai-responses（仅 Codex），空字符串表示与 Provider 一致；
+	// 与 CLI 的协议不同时由本地网关转换请求与响应
+	UpstreamProtocol string `json:"upstream_protocol,omitempty"`
+	// 中转站余额查询设置，nil 表示不查询
+	Balance *BalanceConfig `json:"balance,omitempty"`
 }
 
 // Config 主配置
 type Config struct {
-	CurrentEnv         string      `json:"current_env"` // Deprecated: 兼容旧版本
-	CurrentEnvClaude   string      `json:"current_env_claude"`
-	CurrentEnvCodex    string      `json:"current_env_codex"`
-	CurrentEnvGemini   string      `json:"current_env_gemini"`
-	CurrentEnvOpenclaw string      `json:"current_env_openclaw"`
-	Environments       []EnvConfig `json:"environments"`
+	CurrentEnv         string              `json:"current_env"` // Deprecated: 兼容旧版本
+	CurrentEnvClaude   string              `json:"current_env_claude"`
+	CurrentEnvCodex    string              `json:"current_env_codex"`
+	CurrentEnvGemini   string              `json:"current_env_gemini"`
+	CurrentEnvOpenclaw string              `json:"current_env_openclaw"`
+	Environments       []EnvConfig         `json:"environments"`
+	PermissionProfiles []PermissionProfile `json:"permission_profiles,omitempty"`
+	PromptProjects     []string            `json:"prompt_projects,omitempty"` // 已登记的项目根目录（提示词文件扫描）
+	Proxy              *ProxyConfig        `json:"proxy,omitempty"`           // 全局代理设置（健康检查 / 测速 / MCP 测试）
 }
 
 // App struct
@@ -45,6 +68,9 @@ type App struct {
 	ctx        context.Context
 	configPath string
 	config     Config
+	// snapshot 最近一次加载或保存的配置副本，供网关、定时任务等后台 goroutine 读取
+	snapshotMu sync.RWMutex
+	snapshot   Config
 }
 
 // NewApp creates a new App application struct
@@ -69,6 +95,24 @@ func (a *App) GetConfig() Config {
 	return a.config
 }
 
+// configSnapshot 返回配置副本；后台 goroutine 使用它，避免与界面修改 a.config 产生数据竞争
+func (a *App) configSnapshot() Config {
+	a.snapshotMu.RLock()
+	defer a.snapshotMu.RUnlock()
+	return a.snapshot
+}
+
+// publishConfig 用序列化后的配置生成独立副本（切片与 map 不与 a.config 共享）
+func (a *App) publishConfig(data []byte) {
+	var snapshot Config
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return
+	}
+	a.snapshotMu.Lock()
+	a.snapshot = snapshot
+	a.snapshotMu.Unlock()
+}
+
 // GetEnvVar 获取环境变量
 func (a *App) GetEnvVar(key string) string {
 	return a.getPlatformEnvVar(key)
@@ -122,6 +166,25 @@ func (a *App) SwitchToEnv(name string) error {
 
 // AddEnv adds a new environment configuration
 func (a *App) AddEnv(env EnvConfig) error {
+	proxy, err := normalizeProxyConfig(env.Proxy)
+	if err != nil {
+		return err
+	}
+	env.Proxy = proxy
+	protocol, err := normalizeUpstreamProtocol(env)
+	if err != nil {
+		return err
+	}
+	env.UpstreamProtocol = protocol
+	balance, err := normalizeBalanceConfig(env.Balance)
+	if err != nil {
+		return err
+	}
+	env.Balance = balance
+	if err := validateCodexProfileKey(a.config.Environments, env, env.Name); err != nil {
+		return err
+	}
+
 	// Check if environment already exists
 	for i, existing := range a.config.Environments {
 		if existing.Name == env.Name {
@@ -138,6 +201,25 @@ func (a *App) AddEnv(env EnvConfig) error {
 
 // UpdateEnv updates an existing environment configuration by old name
 func (a *App) UpdateEnv(oldName string, newEnv EnvConfig) error {
+	proxy, err := normalizeProxyConfig(newEnv.Proxy)
+	if err != nil {
+		return err
+	}
+	newEnv.Proxy = proxy
+	protocol, err := normalizeUpstreamProtocol(newEnv)
+	if err != nil {
+		return err
+	}
+	newEnv.UpstreamProtocol = protocol
+	balance, err := normalizeBalanceConfig(newEnv.Balance)
+	if err != nil {
+		return err
+	}
+	newEnv.Balance = balance
+	if err := validateCodexProfileKey(a.config.Environments, newEnv, oldName); err != nil {
+		return err
+	}
+
 	for i, existing := range a.config.Environments {
 		if existing.Name == oldName {
 			// Update in place to maintain order
@@ -225,7 +307,7 @@ func (a *App) ReorderEnvs(names []string) error {
 	return a.saveConfig()
 }
 
-// TestLatency 测试 URL 延迟
+// TestLatency 测试 URL 延迟（仅检查连通性；校验密钥与模型请使用 ProbeEnv）
 func (a *App) TestLatency(urlStr string) (int64, error) {
 	if urlStr == "" {
 		return 0, fmt.Errorf("URL 为空")
@@ -233,9 +315,7 @@ func (a *App) TestLatency(urlStr string) (int64, error) {
 
 	// 简单的 HTTP GET 请求测速
 	start := time.Now()
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
+	client := newProxyClient(a.proxyForURL(urlStr), 5*time.Second)
 	resp, err := client.Get(urlStr)
 	if err != nil {
 		return 0, err
@@ -261,8 +341,10 @@ func (a *App) ApplyCurrentEnv() (string, error) {
 	// 1. Apply Claude
 	if a.config.CurrentEnvClaude != "" {
 		if env := a.findEnv(a.config.CurrentEnvClaude); env != nil {
-			if msg, err := a.applyClaudeEnv(env); err == nil {
-				msgs = append(msgs, "Claude: "+msg)
+			if msg, err := a.applyClaudeEnv(gatewayRoutedEnv(env)); err == nil {
+				msgs = append(msgs, "Claude: "+a.withPromptVariant(env, msg))
+			} else {
+				msgs = append(msgs, "Claude: 应用失败: "+err.Error())
 			}
 		}
 	}
@@ -270,8 +352,10 @@ func (a *App) ApplyCurrentEnv() (string, error) {
 	// 2. Apply Codex
 	if a.config.CurrentEnvCodex != "" {
 		if env := a.findEnv(a.config.CurrentEnvCodex); env != nil {
-			if msg, err := a.applyCodexEnv(env); err == nil {
-				msgs = append(msgs, "Codex: "+msg)
+			if msg, err := a.applyCodexEnv(gatewayRoutedEnv(env)); err == nil {
+				msgs = append(msgs, "Codex: "+a.withPromptVariant(env, msg))
+			} else {
+				msgs = append(msgs, "Codex: 应用失败: "+err.Error())
 			}
 		}
 	}
@@ -279,8 +363,10 @@ func (a *App) ApplyCurrentEnv() (string, error) {
 	// 3. Apply Gemini
 	if a.config.CurrentEnvGemini != "" {
 		if env := a.findEnv(a.config.CurrentEnvGemini); env != nil {
-			if msg, err := a.applyGeminiEnv(env); err == nil {
-				msgs = append(msgs, "Gemini: "+msg)
+			if msg, err := a.applyGeminiEnv(gatewayRoutedEnv(env)); err == nil {
+				msgs = append(msgs, "Gemini: "+a.withPromptVariant(env, msg))
+			} else {
+				msgs = append(msgs, "Gemini: 应用失败: "+err.Error())
 			}
 		}
 	}
@@ -288,8 +374,10 @@ func (a *App) ApplyCurrentEnv() (string, error) {
 	// 4. Apply OpenClaw
 	if a.config.CurrentEnvOpenclaw != "" {
 		if env := a.findEnv(a.config.CurrentEnvOpenclaw); env != nil {
-			if msg, err := a.applyOpenclawEnv(env); err == nil {
-				msgs = append(msgs, "OpenClaw: "+msg)
+			if msg, err := a.applyOpenclawEnv(gatewayRoutedEnv(env)); err == nil {
+				msgs = append(msgs, "OpenClaw: "+a.withPromptVariant(env, msg))
+			} else {
+				msgs = append(msgs, "OpenClaw: 应用失败: "+err.Error())
 			}
 		}
 	}
@@ -367,12 +455,18 @@ func (a *App) GetCodexSettings() map[string]string {
 	// 读取 auth.json
 	authFile := filepath.Join(homeDir, ".codex", "auth.json")
 	if data, err := os.ReadFile(authFile); err == nil {
-		var authData map[string]string
+		var authData map[string]any
 		if json.Unmarshal(data, &authData) == nil {
 			for k, v := range authData {
-				result[k] = v
+				if str, ok := v.(string); ok {
+					result[k] = str
+				}
 			}
 		}
+		if account, ok := codexAuthAccount(data); ok {
+			result["auth_mode"] = codexAuthChatGPT
+			result["chatgpt_account"] = account
+		}
 	}
 
 	// 读取 config.toml 的关键字段
@@ -385,6 +479,18 @@ func (a *App) GetCodexSettings() map[string]string {
 				result["model"] = strings.TrimSpace(v)
 			}
 
+			// 顶层 profile 指向的 [profiles.<name>] 优先于顶层键
+			var activeProfile map[string]any
+			if name, ok := payload["profile"].(string); ok && strings.TrimSpace(name) != "" {
+				result["profile"] = strings.TrimSpace(name)
+				if profiles, ok := payload["profiles"].(map[string]any); ok {
+					activeProfile, _ = profiles[strings.TrimSpace(name)].(map[string]any)
+				}
+			}
+			if v, ok := activeProfile["model"].(string); ok && strings.TrimSpace(v) != "" {
+				result["model"] = strings.TrimSpace(v)
+			}
+
 			// base_url 可能位于:
 			// 1) 顶层 base_url
 			// 2) [model_providers.<model_provider>].base_url
@@ -397,6 +503,9 @@ func (a *App) GetCodexSettings() map[string]string {
 			if v, ok := payload["model_provider"].(string); ok {
 				modelProvider = strings.TrimSpace(v)
 			}
+			if v, ok := activeProfile["model_provider"].(string); ok && strings.TrimSpace(v) != "" {
+				modelProvider = strings.TrimSpace(v)
+			}
 			if strings.TrimSpace(result["base_url"]) == "" {
 				if mp, ok := payload["model_providers"].(map[string]any); ok && len(mp) > 0 {
 					if modelProvider != "" {
@@ -461,6 +570,20 @@ func (a *App) GetGeminiSettings() map[string]string {
 		}
 	}
 
+	// 当前认证方式（settings.json 未设置时按 .env 推断）
+	authMode := readGeminiAuthMode(filepath.Join(homeDir, ".gemini"))
+	if authMode == "" {
+		switch {
+		case parseBoolString(result["GOOGLE_GENAI_USE_VERTEXAI"], false):
+			authMode = geminiAuthVertexAI
+		case strings.TrimSpace(result["GEMINI_API_KEY"]) != "":
+			authMode = geminiAuthAPIKey
+		}
+	}
+	if authMode != "" {
+		result["GEMINI_AUTH_MODE"] = authMode
+	}
+
 	return result
 }
 
@@ -584,6 +707,23 @@ func (a *App) GetOpenclawSettings() map[string]string {
 				}
 			}
 		}
+
+		// 命名 agents / providers 列表（详细内容见 GetOpenclawConfig）
+		structured := extractOpenclawConfig(payload)
+		agentNames := make([]string, 0, len(structured.Agents))
+		for _, agent := range structured.Agents {
+			agentNames = append(agentNames, agent.Name)
+		}
+		if len(agentNames) > 0 {
+			result["OPENCLAW_AGENTS"] = strings.Join(agentNames, "\n")
+		}
+		providerNames := make([]string, 0, len(structured.Providers))
+		for _, provider := range structured.Providers {
+			providerNames = append(providerNames, provider.Name)
+		}
+		if len(providerNames) > 0 {
+			result["OPENCLAW_PROVIDERS"] = strings.Join(providerNames, "\n")
+		}
 	}
 
 	// 文件里没有时，回退到当前激活的 OpenClaw 环境变量
@@ -636,13 +776,13 @@ func (a *App) applyClaudeEnv(env *EnvConfig) (string, error) {
 		settings = make(map[string]interface{})
 	}
 
-	// 更新 env 字段
-	envMap := make(map[string]string)
-	for key, value := range env.Variables {
-		if value != "" {
-			envMap[key] = value
-		}
+	if err := validateClaudeEnv(env); err != nil {
+		return "", err
 	}
+
+	// 更新 env 字段（按后端类型剔除冲突变量）
+	envMap := claudeBackendEnv(env)
+	expandClaudeModelMapping(envMap, env.ModelMapping)
 	// 根据配置添加 Claude Code 优化选项
 	if env.AttributionHeader != "" {
 		envMap["CLAUDE_CODE_ATTRIBUTION_HEADER"] = env.AttributionHeader
@@ -650,8 +790,15 @@ func (a *App) applyClaudeEnv(env *EnvConfig) (string, error) {
 	if env.DisableNonessentialTraffic != "" {
 		envMap["CLAUDE_CODE_DISABLE_NONESSENTIAL_TRAFFIC"] = env.DisableNonessentialTraffic
 	}
+	for key, value := range proxyCLIEnv(a.effectiveProxy(env)) {
+		envMap[key] = value
+	}
 	settings["env"] = envMap
 
+	if err := a.applyClaudePermissionProfile(settings, env); err != nil {
+		return "", err
+	}
+
 	// 写入 settings.json
 	settingsContent, err := json.MarshalIndent(settings, "", "  ")
 	if err != nil {
@@ -678,68 +825,75 @@ func (a *App) applyCodexEnv(env *EnvConfig) (string, error) {
 	}
 
 	// 1. 处理 config.toml
-	var configContent string
+	configFile := filepath.Join(codexDir, "config.toml")
+	var configData []byte
 	if tmpl, ok := env.Templates["config.toml"]; ok && tmpl != "" {
 		// 使用自定义模板，替换变量
-		configContent = tmpl
+		configContent := tmpl
 		configContent = strings.ReplaceAll(configContent, "{{model}}", env.Variables["model"])
 		configContent = strings.ReplaceAll(configContent, "{{base_url}}", env.Variables["base_url"])
+		configData, err = buildCodexConfigData(configContent, configFile)
+		if err != nil {
+			return "", fmt.Errorf("序列化 config.toml 失败: %v", err)
+		}
 	} else {
-		// 使用默认模板
-		configContent = fmt.Sprintf(`model_provider = "duckcoding"
-model = "%s"
-model_reasoning_effort = "high"
-network_access = "enabled"
-disable_response_storage = true
-
-[model_providers.duckcoding]
-name = "duckcoding"
-base_url = "%s"
-wire_api = "responses"
-requires_openai_auth = true
-`, env.Variables["model"], env.Variables["base_url"])
+		// 默认：每个 Codex 环境对应 [model_providers.<env>] + [profiles.<env>]，切换时只改顶层 profile/model_provider
+		doc, err := readCodexConfigDocument(configFile)
+		if err != nil {
+			return "", err
+		}
+		if err := a.mergeCodexEnvProfiles(doc, env); err != nil {
+			return "", err
+		}
+		if _, err := doc.Map(); err != nil {
+			return "", fmt.Errorf("生成的 config.toml 不是有效 TOML: %v", err)
+		}
+		configData = doc.Bytes()
 	}
-
-	configFile := filepath.Join(codexDir, "config.toml")
-	configData, err := buildCodexConfigData(configContent, configFile)
+	configData, err = a.applyCodexPermissionProfile(configData, env)
 	if err != nil {
-		return "", fmt.Errorf("序列化 config.toml 失败: %v", err)
+		return "", err
 	}
 	if err := os.WriteFile(configFile, configData, 0644); err != nil {
 		return "", fmt.Errorf("写入 config.toml 失败: %v", err)
 	}
 
-	// 2. 处理 auth.json
-	var authContent string
-	if tmpl, ok := env.Templates["auth.json"]; ok && tmpl != "" {
-		authContent = tmpl
-		authContent = strings.ReplaceAll(authContent, "{{OPENAI_API_KEY}}", env.Variables["OPENAI_API_KEY"])
-	} else {
-		authContent = fmt.Sprintf(`{
-  "OPENAI_API_KEY": "%s"
-}`, env.Variables["OPENAI_API_KEY"])
+	// 2. 处理 auth.json（保留 ChatGPT 登录）
+	authMsg, err := a.applyCodexAuth(env, codexDir)
+	if err != nil {
+		return "", err
 	}
-
-	authFile := filepath.Join(codexDir, "auth.json")
-	if err := os.WriteFile(authFile, []byte(authContent), 0644); err != nil {
-		return "", fmt.Errorf("写入 auth.json 失败: %v", err)
+	if authMsg != "" {
+		return "Codex 配置已应用（" + authMsg + "）", nil
 	}
 
 	return "Codex 配置已应用", nil
 }
 
+// buildCodexConfigData 将模板合并进现有 config.toml：只改写模板涉及的键，保留其余内容与注释；
+// 顶层 profile 指向托管的表时清除，否则 Codex 会继续使用该 profile 而忽略模板
 func buildCodexConfigData(configContent, configFile string) ([]byte, error) {
-	existingMcpServers := readCodexMcpServers(configFile)
 	var payload map[string]any
-	if err := toml.Unmarshal([]byte(configContent), &payload); err == nil && payload != nil {
-		if len(existingMcpServers) > 0 {
-			if _, ok := payload["mcp_servers"]; !ok {
-				payload["mcp_servers"] = existingMcpServers
-			}
+	if err := toml.Unmarshal([]byte(configContent), &payload); err == nil {
+		doc, err := readCodexConfigDocument(configFile)
+		if err != nil {
+			// 现有文件无法解析时直接使用模板
+			return []byte(configContent), nil
+		}
+		codexStateMu.Lock()
+		state, err := loadCodexState()
+		codexStateMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		clearCodexActiveProfile(doc, state)
+		if err := doc.Merge(payload); err != nil {
+			return nil, err
 		}
-		return toml.Marshal(payload)
+		return doc.Bytes(), nil
 	}
 
+	existingMcpServers := readCodexMcpServers(configFile)
 	data := []byte(configContent)
 	if len(existingMcpServers) > 0 && !strings.Contains(configContent, "mcp_servers") {
 		if mcpData, err := toml.Marshal(map[string]any{"mcp_servers": existingMcpServers}); err == nil {
@@ -776,19 +930,24 @@ func (a *App) applyGeminiEnv(env *EnvConfig) (string, error) {
 		return "", fmt.Errorf("创建 .gemini 目录失败: %v", err)
 	}
 
+	authMode := normalizeGeminiAuthMode(env.AuthMode)
+	if strings.TrimSpace(env.Templates[".env"]) == "" {
+		if err := validateGeminiEnv(env); err != nil {
+			return "", err
+		}
+	}
+
 	// 1. 处理 .env 文件
 	var envContent string
 	if tmpl, ok := env.Templates[".env"]; ok && tmpl != "" {
 		envContent = tmpl
-		envContent = strings.ReplaceAll(envContent, "{{GOOGLE_GEMINI_BASE_URL}}", env.Variables["GOOGLE_GEMINI_BASE_URL"])
-		envContent = strings.ReplaceAll(envContent, "{{GEMINI_API_KEY}}", env.Variables["GEMINI_API_KEY"])
-		envContent = strings.ReplaceAll(envContent, "{{GEMINI_MODEL}}", env.Variables["GEMINI_MODEL"])
+		for key, value := range env.Variables {
+			envContent = strings.ReplaceAll(envContent, "{{"+key+"}}", value)
+		}
 	} else {
-		envContent = fmt.Sprintf(`GOOGLE_GEMINI_BASE_URL=%s
-GEMINI_API_KEY=%s
-GEMINI_MODEL=%s
-`, env.Variables["GOOGLE_GEMINI_BASE_URL"], env.Variables["GEMINI_API_KEY"], env.Variables["GEMINI_MODEL"])
+		envContent = buildGeminiEnvContent(env)
 	}
+	envContent = appendProxyEnvLines(envContent, proxyCLIEnv(a.effectiveProxy(env)))
 
 	envFile := filepath.Join(geminiDir, ".env")
 	if err := os.WriteFile(envFile, []byte(envContent), 0644); err != nil {
@@ -801,6 +960,9 @@ GEMINI_MODEL=%s
 		if err := json.Unmarshal([]byte(tmpl), &desiredSettings); err != nil {
 			return "", fmt.Errorf("解析 settings.json 模板失败: %v", err)
 		}
+		if _, ok := getMapPath(desiredSettings, "security.auth.selectedType"); !ok {
+			setMapPath(desiredSettings, "security.auth.selectedType", authMode)
+		}
 	} else {
 		desiredSettings = map[string]any{
 			"ide": map[string]any{
@@ -808,7 +970,7 @@ GEMINI_MODEL=%s
 			},
 			"security": map[string]any{
 				"auth": map[string]any{
-					"selectedType": "gemini-api-key",
+					"selectedType": authMode,
 				},
 			},
 		}
@@ -834,11 +996,17 @@ GEMINI_MODEL=%s
 		return "", fmt.Errorf("写入 settings.json 失败: %v", err)
 	}
 
-	return "Gemini CLI 配置已应用", nil
+	return fmt.Sprintf("Gemini CLI 配置已应用（%s）", authMode), nil
 }
 
 // applyOpenclawEnv 应用 OpenClaw 配置到 ~/.openclaw/openclaw.json
 func (a *App) applyOpenclawEnv(env *EnvConfig) (string, error) {
+	if env.Openclaw != nil {
+		if err := validateOpenclawConfig(*env.Openclaw); err != nil {
+			return "", err
+		}
+	}
+
 	_, _, configFile := resolveOpenclawPaths(env.Variables)
 	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
 		return "", fmt.Errorf("创建 OpenClaw 配置目录失败: %v", err)
@@ -866,6 +1034,9 @@ func (a *App) applyOpenclawEnv(env *EnvConfig) (string, error) {
 		}
 
 		deepMergeMap(existingPayload, desiredPayload)
+		if cfg := envOpenclawConfig(env); cfg != nil {
+			mergeOpenclawConfig(existingPayload, cfg)
+		}
 		mergedContent, err := json.MarshalIndent(existingPayload, "", "  ")
 		if err != nil {
 			return "", fmt.Errorf("序列化 OpenClaw 配置失败: %v", err)
@@ -1180,6 +1351,11 @@ func (a *App) ClearClaudeSettings() error {
 		return fmt.Errorf("获取用户目录失败: %v", err)
 	}
 
+	// 恢复用户原有的 CLAUDE.md
+	if err := a.restorePromptBase("claude"); err != nil {
+		return err
+	}
+
 	settingsFile := filepath.Join(homeDir, ".claude", "settings.json")
 
 	// 读取现有的 settings.json
@@ -1216,11 +1392,34 @@ func (a *App) ClearCodexSettings() error {
 
 	codexDir := filepath.Join(homeDir, ".codex")
 
-	// 删除配置文件
-	os.Remove(filepath.Join(codexDir, "config.toml"))
-	os.Remove(filepath.Join(codexDir, "auth.json"))
+	// 恢复用户原有的 AGENTS.md
+	if err := a.restorePromptBase("codex"); err != nil {
+		return err
+	}
 
-	return nil
+	// config.toml 只移除本软件托管的部分，保留用户的其他表（mcp_servers / tui / history 等）
+	configFile := filepath.Join(codexDir, "config.toml")
+	doc, err := readCodexConfigDocument(configFile)
+	if err != nil {
+		return err
+	}
+	if len(doc.Bytes()) > 0 {
+		if err := removeCodexEnvProfiles(doc); err != nil {
+			return err
+		}
+		if err := restorePermissionSnapshot("codex", doc); err != nil {
+			return err
+		}
+		if _, err := doc.Map(); err != nil {
+			return fmt.Errorf("生成的 config.toml 不是有效 TOML: %v", err)
+		}
+		if err := os.WriteFile(configFile, doc.Bytes(), 0644); err != nil {
+			return fmt.Errorf("写入 config.toml 失败: %v", err)
+		}
+	}
+
+	// auth.json：API Key 形式的移除，ChatGPT 登录保留/恢复
+	return clearCodexAuth(codexDir)
 }
 
 // ClearGeminiSettings 清除 Gemini 配置文件
@@ -1232,6 +1431,11 @@ func (a *App) ClearGeminiSettings() error {
 
 	geminiDir := filepath.Join(homeDir, ".gemini")
 
+	// 恢复用户原有的 GEMINI.md
+	if err := a.restorePromptBase("gemini"); err != nil {
+		return err
+	}
+
 	// 删除配置文件
 	os.Remove(filepath.Join(geminiDir, ".env"))
 
@@ -1240,6 +1444,11 @@ func (a *App) ClearGeminiSettings() error {
 
 // ClearOpenclawSettings 清除 OpenClaw 配置文件
 func (a *App) ClearOpenclawSettings() error {
+	// 工作区路径依赖配置文件，需在删除配置前恢复 AGENTS.md
+	if err := a.restorePromptBase("openclaw"); err != nil {
+		return err
+	}
+
 	activeVars := map[string]string{}
 	if env := a.findEnv(a.config.CurrentEnvOpenclaw); env != nil {
 		activeVars = env.Variables
@@ -1441,6 +1650,9 @@ func (a *App) loadConfig() error {
 		a.config.CurrentEnvClaude = a.config.CurrentEnv
 	}
 
+	if data, err := json.Marshal(a.config); err == nil {
+		a.publishConfig(data)
+	}
 	return nil
 }
 
@@ -1461,130 +1673,77 @@ func (a *App) saveConfig() error {
 		return fmt.Errorf("保存配置文件失败 (%s): %v", a.configPath, err)
 	}
 
+	a.publishConfig(data)
 	return nil
 }
 
 // PromptFile 提示词文件信息
 type PromptFile struct {
-	Provider string `json:"provider"` // claude, codex, gemini
-	Path     string `json:"path"`     // 文件路径
-	Content  string `json:"content"`  // 文件内容
-	Exists   bool   `json:"exists"`   // 文件是否存在
+	ID       string         `json:"id"`                // 文件标识：全局文件为 Provider 名，项目文件为绝对路径
+	Provider string         `json:"provider"`          // claude, codex, gemini, openclaw
+	Scope    string         `json:"scope"`             // global, project
+	Project  string         `json:"project,omitempty"` // 所属项目根目录
+	Name     string         `json:"name"`              // 显示名称（项目文件为相对路径）
+	Path     string         `json:"path"`              // 文件路径
+	Content  string         `json:"content"`           // 文件内容
+	Exists   bool           `json:"exists"`            // 文件是否存在
+	Imports  []PromptImport `json:"imports,omitempty"` // Claude @import 引用树
 }
 
-// GetPromptFiles 获取所有提示词文件
+// GetPromptFiles 获取所有提示词文件（全局文件 + 已登记项目中的指令文件）
 func (a *App) GetPromptFiles() ([]PromptFile, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("获取用户目录失败: %v", err)
-	}
-
-	files := []PromptFile{
-		{Provider: "claude", Path: filepath.Join(homeDir, ".claude", "CLAUDE.md")},
-		{Provider: "codex", Path: filepath.Join(homeDir, ".codex", "AGENTS.md")},
-		{Provider: "gemini", Path: filepath.Join(homeDir, ".gemini", "GEMINI.md")},
+	var files []PromptFile
+	for _, provider := range []string{"claude", "codex", "gemini", "openclaw"} {
+		file, err := a.resolvePromptFile(provider)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, loadPromptFile(file))
 	}
 
-	for i := range files {
-		if data, err := os.ReadFile(files[i].Path); err == nil {
-			files[i].Content = string(data)
-			files[i].Exists = true
-		} else {
-			files[i].Content = ""
-			files[i].Exists = false
+	for _, root := range a.config.PromptProjects {
+		projectFiles, err := a.ScanPromptProject(root)
+		if err != nil {
+			continue
 		}
+		files = append(files, projectFiles...)
 	}
 
 	return files, nil
 }
 
-// GetPromptFile 获取单个提示词文件
-func (a *App) GetPromptFile(provider string) (PromptFile, error) {
-	homeDir, err := os.UserHomeDir()
+// GetPromptFile 获取单个提示词文件（id 为 Provider 名或项目文件路径）
+func (a *App) GetPromptFile(id string) (PromptFile, error) {
+	file, err := a.resolvePromptFile(id)
 	if err != nil {
-		return PromptFile{}, fmt.Errorf("获取用户目录失败: %v", err)
-	}
-
-	var filePath string
-	switch provider {
-	case "claude":
-		filePath = filepath.Join(homeDir, ".claude", "CLAUDE.md")
-	case "codex":
-		filePath = filepath.Join(homeDir, ".codex", "AGENTS.md")
-	case "gemini":
-		filePath = filepath.Join(homeDir, ".gemini", "GEMINI.md")
-	default:
-		return PromptFile{}, fmt.Errorf("未知的 Provider: %s", provider)
+		return PromptFile{}, err
 	}
-
-	file := PromptFile{Provider: provider, Path: filePath}
-	if data, err := os.ReadFile(filePath); err == nil {
-		file.Content = string(data)
-		file.Exists = true
-	}
-
-	return file, nil
+	return loadPromptFile(file), nil
 }
 
-// SavePromptFile 保存提示词文件
-func (a *App) SavePromptFile(provider, content string) error {
-	homeDir, err := os.UserHomeDir()
+// SavePromptFile 保存提示词文件（不存在时创建），并重新渲染提示词库托管区块
+func (a *App) SavePromptFile(id, content string) error {
+	file, err := a.resolvePromptFile(id)
 	if err != nil {
-		return fmt.Errorf("获取用户目录失败: %v", err)
+		return err
 	}
 
-	var filePath string
-	var dirPath string
-	switch provider {
-	case "claude":
-		dirPath = filepath.Join(homeDir, ".claude")
-		filePath = filepath.Join(dirPath, "CLAUDE.md")
-	case "codex":
-		dirPath = filepath.Join(homeDir, ".codex")
-		filePath = filepath.Join(dirPath, "AGENTS.md")
-	case "gemini":
-		dirPath = filepath.Join(homeDir, ".gemini")
-		filePath = filepath.Join(dirPath, "GEMINI.md")
-	default:
-		return fmt.Errorf("未知的 Provider: %s", provider)
-	}
-
-	// 确保目录存在
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return fmt.Errorf("创建目录失败: %v", err)
-	}
-
-	// 写入文件
-	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("写入文件失败: %v", err)
+	// 提示词库托管区块以提示词库为准，区块外的内容按原样保存
+	content, err = a.applyPromptRecipes(file, content)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return a.writePromptFile(file, content, promptSourceSave)
 }
 
 // DeletePromptFile 删除提示词文件
-func (a *App) DeletePromptFile(provider string) error {
-	homeDir, err := os.UserHomeDir()
+func (a *App) DeletePromptFile(id string) error {
+	file, err := a.resolvePromptFile(id)
 	if err != nil {
-		return fmt.Errorf("获取用户目录失败: %v", err)
+		return err
 	}
 
-	var filePath string
-	switch provider {
-	case "claude":
-		filePath = filepath.Join(homeDir, ".claude", "CLAUDE.md")
-	case "codex":
-		filePath = filepath.Join(homeDir, ".codex", "AGENTS.md")
-	case "gemini":
-		filePath = filepath.Join(homeDir, ".gemini", "GEMINI.md")
-	default:
-		return fmt.Errorf("未知的 Provider: %s", provider)
-	}
-
-	// 删除文件（如果不存在则忽略）
-	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("删除文件失败: %v", err)
-	}
-
-	return nil
+	// 删除文件（如果不存在则忽略），删除前的内容保留在历史版本中
+	return a.removePromptFile(file, promptSourceDelete)
 }
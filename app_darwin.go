@@ -4,7 +4,9 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
 )
 
 // getPlatformEnvVar 获取环境变量 (macOS实现)
@@ -12,6 +14,9 @@ func (a *App) getPlatformEnvVar(key string) string {
 	return os.Getenv(key)
 }
 
+// platformPersistsEnvVars setPlatformEnvVar 是否持久化到用户环境（只设置本进程，其他终端与 CLI 看不到）
+const platformPersistsEnvVars = false
+
 // setPlatformEnvVar 设置环境变量 (macOS实现)
 // macOS 不需要设置系统环境变量，配置通过文件管理
 func (a *App) setPlatformEnvVar(key, value string) error {
@@ -22,3 +27,9 @@ func (a *App) setPlatformEnvVar(key, value string) error {
 func (a *App) deletePlatformEnvVar(key string) error {
 	return os.Unsetenv(key)
 }
+
+// sendPlatformNotification 发送桌面通知 (macOS实现，通过 osascript)
+func (a *App) sendPlatformNotification(title, message string) error {
+	script := fmt.Sprintf("display notification %q with title %q", message, title)
+	return exec.Command("osascript", "-e", script).Run()
+}
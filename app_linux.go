@@ -5,6 +5,7 @@ package main
 
 import (
 	"os"
+	"os/exec"
 )
 
 // getPlatformEnvVar 获取环境变量 (Linux实现)
@@ -12,6 +13,9 @@ func (a *App) getPlatformEnvVar(key string) string {
 	return os.Getenv(key)
 }
 
+// platformPersistsEnvVars setPlatformEnvVar 是否持久化到用户环境（只设置本进程，其他终端与 CLI 看不到）
+const platformPersistsEnvVars = false
+
 // setPlatformEnvVar 设置环境变量 (Linux实现)
 // Linux 不需要设置系统环境变量，配置通过文件管理
 func (a *App) setPlatformEnvVar(key, value string) error {
@@ -22,3 +26,8 @@ func (a *App) setPlatformEnvVar(key, value string) error {
 func (a *App) deletePlatformEnvVar(key string) error {
 	return os.Unsetenv(key)
 }
+
+// sendPlatformNotification 发送桌面通知 (Linux实现，依赖 notify-send)
+func (a *App) sendPlatformNotification(title, message string) error {
+	return exec.Command("notify-send", "-a", "Claude Env Switcher", title, message).Run()
+}
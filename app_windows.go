@@ -92,6 +92,9 @@ func (a *App) getPlatformEnvVar(key string) string {
 	return a.getWindowsEnvVar(key)
 }
 
+// platformPersistsEnvVars setPlatformEnvVar 是否持久化到用户环境（通过 setx 写入用户环境，之后新开的终端可见）
+const platformPersistsEnvVars = true
+
 // setPlatformEnvVar 设置环境变量 (Windows实现)
 func (a *App) setPlatformEnvVar(key, value string) error {
 	return a.setWindowsEnvVar(key, value)
@@ -101,3 +104,24 @@ func (a *App) setPlatformEnvVar(key, value string) error {
 func (a *App) deletePlatformEnvVar(key string) error {
 	return a.deleteWindowsEnvVar(key)
 }
+
+// sendPlatformNotification 发送桌面通知 (Windows实现，通过 PowerShell 调用 Toast)
+// 标题与内容经环境变量传入，避免脚本转义问题
+func (a *App) sendPlatformNotification(title, message string) error {
+	script := `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$texts = $template.GetElementsByTagName('text')
+$texts.Item(0).AppendChild($template.CreateTextNode($env:CES_NOTIFY_TITLE)) > $null
+$texts.Item(1).AppendChild($template.CreateTextNode($env:CES_NOTIFY_MESSAGE)) > $null
+$appID = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appID).Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.Env = append(os.Environ(), "CES_NOTIFY_TITLE="+title, "CES_NOTIFY_MESSAGE="+message)
+
+	// 隐藏CMD窗口
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		HideWindow:    true,
+		CreationFlags: 0x08000000, // CREATE_NO_WINDOW
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	balanceStoreFile    = "balances.json"
+	balanceKeepLast     = 100
+	balanceTimeout      = 10 * time.Second
+	balanceQuotaPerUnit = 500000 // one-api / new-api 默认的额度换算（额度 / 美元）
+	// balanceLowMaxAge 余额读数超过该时长视为过期，不再据此判定余额不足（需大于 uptime 的最大余额查询间隔）
+	balanceLowMaxAge = 2 * time.Hour
+
+	balanceTypeNewAPI        = "new-api"        // /api/user/self（系统访问令牌）
+	balanceTypeOpenAIBilling = "openai-billing" // /dashboard/billing/subscription + /dashboard/billing/usage
+)
+
+// balanceMu 保护余额记录文件；手动查询与 uptime 定时检查可能同时写入
+var balanceMu sync.Mutex
+
+// balanceLatest 各环境最近一次余额查询的内存缓存，recordBalanceChecks 时更新；
+// 网关每个请求都要判断余额是否不足，不能反复解析 balances.json
+var (
+	balanceLatestMu     sync.RWMutex
+	balanceLatest       map[string]BalanceCheck
+	balanceLatestLoaded bool
+)
+
+// BalanceConfig 环境的余额查询方式
+type BalanceConfig struct {
+	Type string `json:"type"` // new-api | openai-billing
+	// URL 站点地址，空字符串表示取环境 Base URL 的协议与主机
+	URL string `json:"url,omitempty"`
+	// AccessToken new-api 的系统访问令牌；openai-billing 为空时使用环境的 API Key
+	AccessToken string `json:"access_token,omitempty"`
+	// UserID new-api 的用户 ID（New-Api-User 请求头）
+	UserID string `json:"user_id,omitempty"`
+	// QuotaPerUnit 额度与美元的换算，默认 500000
+	QuotaPerUnit float64 `json:"quota_per_unit,omitempty"`
+	// LowBalance 余额（美元）低于该值时视为不足，轮换组会跳过该环境；0 表示不判断
+	LowBalance float64 `json:"low_balance,omitempty"`
+}
+
+// BalanceCheck 一次余额查询（金额单位：美元）
+type BalanceCheck struct {
+	At      int64   `json:"at"`
+	Success bool    `json:"success"`
+	Balance float64 `json:"balance"`
+	Used    float64 `json:"used"`
+	Limit   float64 `json:"limit"` // 总额度（余额 + 已用）
+	Error   string  `json:"error,omitempty"`
+}
+
+// BalanceStatus 环境的余额状态与历史
+type BalanceStatus struct {
+	EnvName string         `json:"env_name"`
+	Type    string         `json:"type"`
+	Latest  *BalanceCheck  `json:"latest,omitempty"`
+	Low     bool           `json:"low"`
+	History []BalanceCheck `json:"history"`
+}
+
+type balanceStore struct {
+	History map[string][]BalanceCheck `json:"history"`
+}
+
+// GetBalances 获取配置了余额查询的环境的最近结果与历史
+func (a *App) GetBalances() (map[string]BalanceStatus, error) {
+	balanceMu.Lock()
+	store, err := loadBalanceStore()
+	balanceMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]BalanceStatus{}
+	for _, env := range a.config.Environments {
+		if env.Balance == nil {
+			continue
+		}
+		history := store.History[env.Name]
+		if history == nil {
+			history = []BalanceCheck{}
+		}
+		status := BalanceStatus{EnvName: env.Name, Type: env.Balance.Type, History: history}
+		if len(history) > 0 {
+			latest := history[len(history)-1]
+			status.Latest = &latest
+			status.Low = balanceLow(env.Balance, latest)
+		}
+		result[env.Name] = status
+	}
+	return result, nil
+}
+
+// CheckBalance 立即查询环境的余额并记录
+func (a *App) CheckBalance(envName string) (BalanceCheck, error) {
+	env := a.findEnv(envName)
+	if env == nil {
+		return BalanceCheck{}, fmt.Errorf("环境 '%s' 不存在", envName)
+	}
+	if env.Balance == nil {
+		return BalanceCheck{}, fmt.Errorf("环境 '%s' 未配置余额查询", envName)
+	}
+	check := queryBalance(newProxyClient(a.effectiveProxy(env), balanceTimeout), *env)
+	if err := recordBalanceChecks(map[string]BalanceCheck{env.Name: check}); err != nil {
+		return check, err
+	}
+	return check, nil
+}
+
+// CheckAllBalances 查询所有配置了余额查询的环境
+func (a *App) CheckAllBalances() (map[string]BalanceCheck, error) {
+	checks := refreshBalances(a.GetConfig(), func(env *EnvConfig) *http.Client {
+		return newProxyClient(a.effectiveProxy(env), balanceTimeout)
+	})
+	return checks, recordBalanceChecks(checks)
+}
+
+// refreshBalances 逐个查询配置了余额查询的环境（供手动查询与 uptime 定时检查共用）
+func refreshBalances(config Config, clientFor func(env *EnvConfig) *http.Client) map[string]BalanceCheck {
+	checks := map[string]BalanceCheck{}
+	for i := range config.Environments {
+		env := &config.Environments[i]
+		if env.Balance == nil {
+			continue
+		}
+		checks[env.Name] = queryBalance(clientFor(env), *env)
+	}
+	return checks
+}
+
+// lowBalanceEnvs 最近一次查询余额不足的环境；读数过期的环境不计入，避免按旧余额持续跳过
+func lowBalanceEnvs(config Config) map[string]bool {
+	latest := latestBalanceChecks()
+	low := map[string]bool{}
+	cutoff := time.Now().Add(-balanceLowMaxAge).Unix()
+	for _, env := range config.Environments {
+		check, ok := latest[env.Name]
+		if env.Balance == nil || !ok || check.At < cutoff {
+			continue
+		}
+		if balanceLow(env.Balance, check) {
+			low[env.Name] = true
+		}
+	}
+	return low
+}
+
+// latestBalanceChecks 返回各环境最近一次余额查询；首次调用时从记录文件加载
+func latestBalanceChecks() map[string]BalanceCheck {
+	balanceLatestMu.RLock()
+	if balanceLatestLoaded {
+		defer balanceLatestMu.RUnlock()
+		return balanceLatest
+	}
+	balanceLatestMu.RUnlock()
+
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+	balanceLatestMu.Lock()
+	defer balanceLatestMu.Unlock()
+	if !balanceLatestLoaded {
+		// 读取失败时按没有记录处理，下次写入会重建缓存
+		store, _ := loadBalanceStore()
+		balanceLatest = latestFromBalanceHistory(store.History)
+		balanceLatestLoaded = true
+	}
+	return balanceLatest
+}
+
+func latestFromBalanceHistory(history map[string][]BalanceCheck) map[string]BalanceCheck {
+	latest := make(map[string]BalanceCheck, len(history))
+	for name, checks := range history {
+		if len(checks) > 0 {
+			latest[name] = checks[len(checks)-1]
+		}
+	}
+	return latest
+}
+
+func balanceLow(cfg *BalanceConfig, check BalanceCheck) bool {
+	return cfg != nil && cfg.LowBalance > 0 && check.Success && check.Balance < cfg.LowBalance
+}
+
+// normalizeBalanceConfig 校验并整理余额查询设置；未选择类型时返回 nil
+func normalizeBalanceConfig(cfg *BalanceConfig) (*BalanceConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	out := *cfg
+	out.Type = strings.ToLower(strings.TrimSpace(out.Type))
+	out.URL = strings.TrimRight(strings.TrimSpace(out.URL), "/")
+	out.AccessToken = strings.TrimSpace(out.AccessToken)
+	out.UserID = strings.TrimSpace(out.UserID)
+	switch out.Type {
+	case "":
+		return nil, nil
+	case balanceTypeNewAPI:
+		if out.AccessToken == "" {
+			return nil, fmt.Errorf("new-api 余额查询需要系统访问令牌")
+		}
+	case balanceTypeOpenAIBilling:
+	default:
+		return nil, fmt.Errorf("不支持的余额查询类型: %s", cfg.Type)
+	}
+	if out.URL != "" {
+		if parsed, err := url.Parse(out.URL); err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("余额查询地址无效: %s", out.URL)
+		}
+	}
+	if out.QuotaPerUnit < 0 || out.LowBalance < 0 {
+		return nil, fmt.Errorf("额度换算与余额阈值不能为负数")
+	}
+	return &out, nil
+}
+
+// queryBalance 按环境的余额查询类型请求站点
+func queryBalance(client *http.Client, env EnvConfig) (check BalanceCheck) {
+	check.At = time.Now().Unix()
+	cfg := env.Balance
+	root, err := balanceSiteURL(env)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	switch cfg.Type {
+	case balanceTypeNewAPI:
+		var payload struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+			Data    struct {
+				Quota     float64 `json:"quota"`
+				UsedQuota float64 `json:"used_quota"`
+			} `json:"data"`
+		}
+		headers := map[string]string{"Authorization": "Bearer " + cfg.AccessToken}
+		if cfg.UserID != "" {
+			headers["New-Api-User"] = cfg.UserID
+		}
+		if err := balanceGetJSON(client, root+"/api/user/self", headers, &payload); err != nil {
+			check.Error = err.Error()
+			return check
+		}
+		if !payload.Success {
+			check.Error = firstNonEmpty(payload.Message, "查询失败")
+			return check
+		}
+		perUnit := cfg.QuotaPerUnit
+		if perUnit <= 0 {
+			perUnit = balanceQuotaPerUnit
+		}
+		check.Balance = payload.Data.Quota / perUnit
+		check.Used = payload.Data.UsedQuota / perUnit
+		check.Limit = check.Balance + check.Used
+
+	case balanceTypeOpenAIBilling:
+		key := firstNonEmpty(cfg.AccessToken, envAPIKey(env))
+		if key == "" {
+			check.Error = "缺少 API Key"
+			return check
+		}
+		headers := map[string]string{"Authorization": "Bearer " + key}
+		var subscription struct {
+			HardLimitUSD float64 `json:"hard_limit_usd"`
+		}
+		if err := balanceGetJSON(client, root+"/v1/dashboard/billing/subscription", headers, &subscription); err != nil {
+			check.Error = err.Error()
+			return check
+		}
+		// usage 接口最多查询 100 天；one-api 类中转站忽略日期返回累计用量
+		now := time.Now()
+		query := url.Values{}
+		query.Set("start_date", now.AddDate(0, 0, -99).Format("2006-01-02"))
+		query.Set("end_date", now.AddDate(0, 0, 1).Format("2006-01-02"))
+		var usage struct {
+			TotalUsage float64 `json:"total_usage"` // 美分
+		}
+		if err := balanceGetJSON(client, root+"/v1/dashboard/billing/usage?"+query.Encode(), headers, &usage); err != nil {
+			check.Error = err.Error()
+			return check
+		}
+		check.Limit = subscription.HardLimitUSD
+		check.Used = usage.TotalUsage / 100
+		check.Balance = check.Limit - check.Used
+
+	default:
+		check.Error = fmt.Sprintf("不支持的余额查询类型: %s", cfg.Type)
+		return check
+	}
+	check.Success = true
+	return check
+}
+
+func balanceGetJSON(client *http.Client, rawURL string, headers map[string]string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if message := probeErrorMessage(body); message != "" {
+			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, message)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("解析响应失败: %v", err)
+	}
+	return nil
+}
+
+// balanceSiteURL 余额查询的站点地址：优先使用设置的 URL，否则取 Base URL 的协议与主机
+func balanceSiteURL(env EnvConfig) (string, error) {
+	if env.Balance.URL != "" {
+		return env.Balance.URL, nil
+	}
+	raw := deriveEnvURL(env)
+	if raw == "" && strings.EqualFold(env.Provider, "codex") {
+		raw = openaiDefaultBaseURL
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("无法从环境推导站点地址，请填写余额查询地址")
+	}
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
+
+// envAPIKey 环境使用的 API Key
+func envAPIKey(env EnvConfig) string {
+	vars := env.Variables
+	get := func(key string) string { return strings.TrimSpace(vars[key]) }
+	switch strings.ToLower(firstNonEmpty(env.Provider, "claude")) {
+	case "codex":
+		return get("OPENAI_API_KEY")
+	case "gemini":
+		return get("GEMINI_API_KEY")
+	case "openclaw":
+		return firstNonEmpty(get("OPENCLAW_GATEWAY_TOKEN"), get("OPENAI_API_KEY"))
+	default:
+		return firstNonEmpty(get("ANTHROPIC_AUTH_TOKEN"), get("ANTHROPIC_API_KEY"))
+	}
+}
+
+// recordBalanceChecks 追加查询结果，每个环境保留最近 balanceKeepLast 条
+func recordBalanceChecks(checks map[string]BalanceCheck) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	balanceMu.Lock()
+	defer balanceMu.Unlock()
+	store, err := loadBalanceStore()
+	if err != nil {
+		return err
+	}
+	for name, check := range checks {
+		history := append(store.History[name], check)
+		if len(history) > balanceKeepLast {
+			history = history[len(history)-balanceKeepLast:]
+		}
+		store.History[name] = history
+	}
+	if err := saveBalanceStore(store); err != nil {
+		return err
+	}
+
+	// 缓存整体替换，读取方拿到的旧 map 不会被修改
+	balanceLatestMu.Lock()
+	balanceLatest = latestFromBalanceHistory(store.History)
+	balanceLatestLoaded = true
+	balanceLatestMu.Unlock()
+	return nil
+}
+
+func loadBalanceStore() (balanceStore, error) {
+	store := balanceStore{}
+	if _, err := loadJSONStore(balanceStoreFile, &store); err != nil {
+		return store, err
+	}
+	if store.History == nil {
+		store.History = map[string][]BalanceCheck{}
+	}
+	return store, nil
+}
+
+func saveBalanceStore(store balanceStore) error {
+	return saveJSONStore(balanceStoreFile, store, 0o644)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLowBalanceEnvsIgnoresStaleReadings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	resetCache := func() {
+		balanceLatestMu.Lock()
+		balanceLatest, balanceLatestLoaded = nil, false
+		balanceLatestMu.Unlock()
+	}
+	resetCache()
+	t.Cleanup(resetCache)
+
+	config := Config{Environments: []EnvConfig{
+		{Name: "fresh", Balance: &BalanceConfig{Type: balanceTypeNewAPI, LowBalance: 5}},
+		{Name: "stale", Balance: &BalanceConfig{Type: balanceTypeNewAPI, LowBalance: 5}},
+		{Name: "failed", Balance: &BalanceConfig{Type: balanceTypeNewAPI, LowBalance: 5}},
+	}}
+	now := time.Now()
+	err := recordBalanceChecks(map[string]BalanceCheck{
+		"fresh":  {At: now.Unix(), Success: true, Balance: 1},
+		"stale":  {At: now.Add(-balanceLowMaxAge - time.Minute).Unix(), Success: true, Balance: 1},
+		"failed": {At: now.Add(-time.Minute).Unix(), Success: true, Balance: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最近一次查询失败时不沿用之前的低余额读数
+	if err := recordBalanceChecks(map[string]BalanceCheck{"failed": {At: now.Unix(), Error: "timeout"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	low := lowBalanceEnvs(config)
+	if !low["fresh"] || low["stale"] || low["failed"] || len(low) != 1 {
+		t.Errorf("lowBalanceEnvs = %v, want only fresh", low)
+	}
+
+	// 缓存从记录文件重建后结果一致
+	resetCache()
+	if got := lowBalanceEnvs(config); len(got) != 1 || !got["fresh"] {
+		t.Errorf("after reload lowBalanceEnvs = %v, want only fresh", got)
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	budgetStoreFile     = "budgets.json"
+	budgetCheckInterval = 5 * time.Minute
+
+	budgetPeriodDaily   = "daily"
+	budgetPeriodWeekly  = "weekly"
+	budgetPeriodMonthly = "monthly"
+
+	budgetScopeEnv      = "env"
+	budgetScopeProvider = "provider"
+
+	budgetStateOK       = "ok"
+	budgetStateSoft     = "soft"     // 达到软阈值（提醒）
+	budgetStateExceeded = "exceeded" // 达到硬阈值（预算用尽）
+
+	// 前端监听的事件
+	budgetEventAlert    = "budget:alert"
+	budgetEventExceeded = "budget:exceeded"
+)
+
+// BudgetService 花费预算：按周期汇总用量成本，越过阈值时提醒并可切换到备用环境
+type BudgetService struct {
+	mu   sync.Mutex
+	app  *App
+	logs *LogService
+	ctx  context.Context
+	stop chan struct{}
+}
+
+func NewBudgetService(app *App, logs *LogService) *BudgetService {
+	return &BudgetService{app: app, logs: logs}
+}
+
+// Budget 单个预算；Scope 为 env 时 Target 是环境名，为 provider 时 Target 是 Provider
+type Budget struct {
+	Name    string  `json:"name"`
+	Scope   string  `json:"scope"`  // env | provider
+	Target  string  `json:"target"` // 环境名或 claude / codex / gemini / openclaw
+	Period  string  `json:"period"` // daily | weekly | monthly
+	Limit   float64 `json:"limit"`  // 硬阈值（美元）
+	Enabled bool    `json:"enabled"`
+	// SoftPercent 软阈值占 Limit 的百分比，默认 80
+	SoftPercent float64 `json:"soft_percent"`
+	// SwitchOnExceed 越过硬阈值时把 Provider 切换到 FallbackEnv 并应用
+	SwitchOnExceed bool   `json:"switch_on_exceed"`
+	FallbackEnv    string `json:"fallback_env,omitempty"`
+}
+
+// BudgetStatus 预算在当前周期的执行情况
+type BudgetStatus struct {
+	Budget      Budget  `json:"budget"`
+	PeriodStart string  `json:"period_start"` // RFC3339
+	Spent       float64 `json:"spent"`
+	Percent     float64 `json:"percent"`
+	State       string  `json:"state"` // ok | soft | exceeded
+	Switched    bool    `json:"switched"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// budgetPeriodState 本周期内已经发出的提醒，避免重复通知
+type budgetPeriodState struct {
+	PeriodStart  string `json:"period_start"`
+	SoftNotified bool   `json:"soft_notified,omitempty"`
+	HardNotified bool   `json:"hard_notified,omitempty"`
+	Switched     bool   `json:"switched,omitempty"`
+}
+
+type budgetStore struct {
+	Budgets []Budget                     `json:"budgets"`
+	State   map[string]budgetPeriodState `json:"state"`
+}
+
+// OnStartup 启动后台检查
+func (bs *BudgetService) OnStartup(ctx context.Context) {
+	bs.mu.Lock()
+	bs.ctx = ctx
+	bs.stop = make(chan struct{})
+	stop := bs.stop
+	bs.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(budgetCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, _ = bs.CheckBudgets()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// OnShutdown 停止后台检查
+func (bs *BudgetService) OnShutdown(ctx context.Context) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.stop != nil {
+		close(bs.stop)
+		bs.stop = nil
+	}
+}
+
+// GetBudgets 获取所有预算
+func (bs *BudgetService) GetBudgets() ([]Budget, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	store, err := loadBudgetStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Budgets, nil
+}
+
+// SaveBudget 新增或更新预算（按名称匹配）
+func (bs *BudgetService) SaveBudget(budget Budget) error {
+	budget = normalizeBudget(budget)
+	if err := bs.validateBudget(budget); err != nil {
+		return err
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	store, err := loadBudgetStore()
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i, existing := range store.Budgets {
+		if strings.EqualFold(existing.Name, budget.Name) {
+			store.Budgets[i] = budget
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		store.Budgets = append(store.Budgets, budget)
+	}
+	// 规则变化后重新计算提醒
+	delete(store.State, budget.Name)
+	return saveBudgetStore(store)
+}
+
+// DeleteBudget 删除预算
+func (bs *BudgetService) DeleteBudget(name string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	store, err := loadBudgetStore()
+	if err != nil {
+		return err
+	}
+	next := make([]Budget, 0, len(store.Budgets))
+	for _, budget := range store.Budgets {
+		if !strings.EqualFold(budget.Name, name) {
+			next = append(next, budget)
+		}
+	}
+	if len(next) == len(store.Budgets) {
+		return fmt.Errorf("预算 '%s' 不存在", name)
+	}
+	store.Budgets = next
+	delete(store.State, name)
+	return saveBudgetStore(store)
+}
+
+// budgetExceededAction 本次检查中首次越过硬阈值的预算，释放 bs.mu 后再提醒与切换
+type budgetExceededAction struct {
+	index    int // statuses 中的下标
+	budget   Budget
+	fallback bool // 开启了自动切换且本周期尚未切换
+}
+
+// budgetSoftAlert 本次检查中首次越过软阈值的预算，释放 bs.mu 后再提醒
+type budgetSoftAlert struct {
+	index   int // statuses 中的下标
+	message string
+}
+
+// budgetSummary 某个周期起点的用量汇总
+type budgetSummary struct {
+	byEnv map[string]EnvUsageSummary
+	err   error
+}
+
+// CheckBudgets 计算所有预算在当前周期的花费；越过阈值时发出事件与桌面通知，
+// 越过硬阈值且开启了自动切换时切换到备用环境
+func (bs *BudgetService) CheckBudgets() ([]BudgetStatus, error) {
+	statuses, alerts, exceeded, err := bs.evaluateBudgets()
+	if err != nil {
+		return statuses, err
+	}
+	for _, alert := range alerts {
+		bs.notify(budgetEventAlert, statuses[alert.index], "预算提醒", alert.message)
+	}
+
+	// 切换环境会调用 SwitchToEnv / ApplyCurrentEnv，不能持有 bs.mu
+	switched := map[string]string{}
+	for _, action := range exceeded {
+		status := &statuses[action.index]
+		budget := action.budget
+		message := fmt.Sprintf("%s 已花费 $%.2f，超出预算 $%.2f", budget.Name, status.Spent, budget.Limit)
+		if action.fallback {
+			if ok, err := bs.switchToFallback(budget); err != nil {
+				status.Error = err.Error()
+				message += "；切换备用环境失败: " + err.Error()
+			} else if ok {
+				status.Switched = true
+				switched[budget.Name] = status.PeriodStart
+				message += "，已切换到 " + budget.FallbackEnv
+			}
+		}
+		bs.notify(budgetEventExceeded, *status, "预算已用尽", message)
+	}
+	if len(switched) == 0 {
+		return statuses, nil
+	}
+	return statuses, bs.markSwitched(switched)
+}
+
+// summarizeBudgetPeriods 在 bs.mu 之外汇总各预算周期起点以来的用量，刷新日志索引可能较慢
+func (bs *BudgetService) summarizeBudgetPeriods(now time.Time) (map[time.Time]budgetSummary, error) {
+	bs.mu.Lock()
+	store, err := loadBudgetStore()
+	bs.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// 同一起点的预算共用一次汇总
+	summaries := map[time.Time]budgetSummary{}
+	for _, budget := range store.Budgets {
+		start := budgetPeriodStart(normalizeBudget(budget).Period, now)
+		if _, ok := summaries[start]; ok {
+			continue
+		}
+		byEnv, err := bs.logs.envUsageSince(start)
+		summaries[start] = budgetSummary{byEnv: byEnv, err: err}
+	}
+	return summaries, nil
+}
+
+// evaluateBudgets 计算花费并在 bs.mu 下记录提醒状态，返回需要在锁外发出的软阈值提醒与硬阈值预算
+func (bs *BudgetService) evaluateBudgets() ([]BudgetStatus, []budgetSoftAlert, []budgetExceededAction, error) {
+	now := time.Now()
+	summaries, err := bs.summarizeBudgetPeriods(now)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	// 汇总期间预算可能被修改，以重新读取的预算为准
+	store, err := loadBudgetStore()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	statuses := make([]BudgetStatus, 0, len(store.Budgets))
+	var alerts []budgetSoftAlert
+	var exceeded []budgetExceededAction
+	for _, budget := range store.Budgets {
+		budget = normalizeBudget(budget)
+		start := budgetPeriodStart(budget.Period, now)
+		status := BudgetStatus{Budget: budget, PeriodStart: start.Format(time.RFC3339), State: budgetStateOK}
+
+		summary, ok := summaries[start]
+		if !ok {
+			status.Error = "预算刚刚修改，将在下次检查时计算"
+			statuses = append(statuses, status)
+			continue
+		}
+		if summary.err != nil {
+			status.Error = summary.err.Error()
+			statuses = append(statuses, status)
+			continue
+		}
+
+		status.Spent = budgetSpent(budget, summary.byEnv)
+		if budget.Limit > 0 {
+			status.Percent = status.Spent / budget.Limit * 100
+		}
+		switch {
+		case status.Percent >= 100:
+			status.State = budgetStateExceeded
+		case status.Percent >= budget.SoftPercent:
+			status.State = budgetStateSoft
+		}
+
+		state := store.State[budget.Name]
+		if state.PeriodStart != status.PeriodStart {
+			state = budgetPeriodState{PeriodStart: status.PeriodStart}
+		}
+		if budget.Enabled {
+			soft, hard := handleThresholds(&status, &state)
+			if soft {
+				alerts = append(alerts, budgetSoftAlert{
+					index:   len(statuses),
+					message: fmt.Sprintf("%s 已花费 $%.2f，达到预算 $%.2f 的 %.0f%%", budget.Name, status.Spent, budget.Limit, status.Percent),
+				})
+			}
+			if hard {
+				exceeded = append(exceeded, budgetExceededAction{
+					index:    len(statuses),
+					budget:   budget,
+					fallback: budget.SwitchOnExceed && !state.Switched,
+				})
+			}
+		}
+		status.Switched = state.Switched
+		if store.State == nil {
+			store.State = map[string]budgetPeriodState{}
+		}
+		store.State[budget.Name] = state
+		statuses = append(statuses, status)
+	}
+
+	// 提醒状态先落盘，切换失败或并发检查时也不会重复提醒
+	if err := saveBudgetStore(store); err != nil {
+		return statuses, nil, nil, err
+	}
+	return statuses, alerts, exceeded, nil
+}
+
+// markSwitched 记录已自动切换的预算（预算名 -> 周期起点），周期已变化的不记录
+func (bs *BudgetService) markSwitched(switched map[string]string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	store, err := loadBudgetStore()
+	if err != nil {
+		return err
+	}
+	for name, periodStart := range switched {
+		state, ok := store.State[name]
+		if !ok || state.PeriodStart != periodStart {
+			continue
+		}
+		state.Switched = true
+		store.State[name] = state
+	}
+	return saveBudgetStore(store)
+}
+
+// handleThresholds 每个周期内软、硬阈值各提醒一次；自动切换也只执行一次，用户切回后不再打扰。
+// 返回是否需要发出软阈值提醒、是否首次越过硬阈值，由调用方在释放 bs.mu 后提醒并切换
+func handleThresholds(status *BudgetStatus, state *budgetPeriodState) (soft, hard bool) {
+	if status.State == budgetStateOK {
+		return false, false
+	}
+	if !state.SoftNotified {
+		state.SoftNotified = true
+		soft = status.State == budgetStateSoft
+	}
+	if status.State != budgetStateExceeded || state.HardNotified {
+		return soft, false
+	}
+	state.HardNotified = true
+	return soft, true
+}
+
+// switchToFallback 沿用现有逻辑（SwitchToEnv + ApplyCurrentEnv）切换到备用环境；
+// 当前环境已不在预算范围内时不切换
+func (bs *BudgetService) switchToFallback(budget Budget) (bool, error) {
+	fallback := bs.app.findEnv(budget.FallbackEnv)
+	if fallback == nil {
+		return false, fmt.Errorf("备用环境 '%s' 不存在", budget.FallbackEnv)
+	}
+	provider := budgetProvider(budget, bs.app.configSnapshot())
+	active := currentEnvNameByProvider(bs.app.configSnapshot(), provider)
+	if active == fallback.Name {
+		return false, nil
+	}
+	if budget.Scope == budgetScopeEnv && active != budget.Target {
+		return false, nil
+	}
+	if err := bs.app.SwitchToEnv(fallback.Name); err != nil {
+		return false, err
+	}
+	if _, err := bs.app.ApplyCurrentEnv(); err != nil {
+		return true, fmt.Errorf("已切换但应用失败: %v", err)
+	}
+	return true, nil
+}
+
+// notify 发出前端事件与桌面通知；调用方不能持有 bs.mu
+func (bs *BudgetService) notify(event string, status BudgetStatus, title, message string) {
+	bs.mu.Lock()
+	ctx := bs.ctx
+	bs.mu.Unlock()
+	if ctx != nil {
+		runtime.EventsEmit(ctx, event, status)
+	}
+	_ = bs.app.sendPlatformNotification(title, message)
+}
+
+func (bs *BudgetService) validateBudget(budget Budget) error {
+	if budget.Name == "" {
+		return fmt.Errorf("预算名称不能为空")
+	}
+	if budget.Limit <= 0 {
+		return fmt.Errorf("预算金额必须大于 0")
+	}
+	if budget.SoftPercent <= 0 || budget.SoftPercent > 100 {
+		return fmt.Errorf("软阈值应在 1-100 之间")
+	}
+	switch budget.Period {
+	case budgetPeriodDaily, budgetPeriodWeekly, budgetPeriodMonthly:
+	default:
+		return fmt.Errorf("不支持的预算周期: %s", budget.Period)
+	}
+
+	config := bs.app.GetConfig()
+	switch budget.Scope {
+	case budgetScopeEnv:
+		if findEnvInConfig(config, budget.Target) == nil {
+			return fmt.Errorf("环境 '%s' 不存在", budget.Target)
+		}
+	case budgetScopeProvider:
+		switch budget.Target {
+		case "claude", "codex", "gemini", "openclaw":
+		default:
+			return fmt.Errorf("未知的 Provider: %s", budget.Target)
+		}
+	default:
+		return fmt.Errorf("不支持的预算范围: %s", budget.Scope)
+	}
+
+	if budget.SwitchOnExceed {
+		fallback := findEnvInConfig(config, budget.FallbackEnv)
+		if fallback == nil {
+			return fmt.Errorf("备用环境 '%s' 不存在", budget.FallbackEnv)
+		}
+		if !strings.EqualFold(firstNonEmpty(fallback.Provider, "claude"), budgetProvider(budget, config)) {
+			return fmt.Errorf("备用环境 '%s' 与预算的 Provider 不一致", budget.FallbackEnv)
+		}
+		if budget.Scope == budgetScopeEnv && fallback.Name == budget.Target {
+			return fmt.Errorf("备用环境不能是预算所属的环境")
+		}
+	}
+	return nil
+}
+
+func normalizeBudget(budget Budget) Budget {
+	budget.Name = strings.TrimSpace(budget.Name)
+	budget.Scope = strings.ToLower(strings.TrimSpace(budget.Scope))
+	if budget.Scope == "" {
+		budget.Scope = budgetScopeEnv
+	}
+	budget.Target = strings.TrimSpace(budget.Target)
+	if budget.Scope == budgetScopeProvider {
+		budget.Target = strings.ToLower(budget.Target)
+	}
+	budget.Period = strings.ToLower(strings.TrimSpace(budget.Period))
+	if budget.Period == "" {
+		budget.Period = budgetPeriodMonthly
+	}
+	if budget.SoftPercent == 0 {
+		budget.SoftPercent = 80
+	}
+	budget.FallbackEnv = strings.TrimSpace(budget.FallbackEnv)
+	return budget
+}
+
+// budgetProvider 预算所属的 Provider
+func budgetProvider(budget Budget, config Config) string {
+	if budget.Scope == budgetScopeProvider {
+		return budget.Target
+	}
+	if env := findEnvInConfig(config, budget.Target); env != nil && env.Provider != "" {
+		return strings.ToLower(env.Provider)
+	}
+	return "claude"
+}
+
+// budgetPeriodStart 周期起点（本地时间）：当天 0 点、本周一 0 点或本月 1 日 0 点
+func budgetPeriodStart(period string, now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case budgetPeriodDaily:
+		return day
+	case budgetPeriodWeekly:
+		offset := (int(day.Weekday()) + 6) % 7 // 周一为 0
+		return day.AddDate(0, 0, -offset)
+	default:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	}
+}
+
+// budgetSpent 预算范围内的花费；网关计量与日志归因描述的是同一批请求，取较大者
+func budgetSpent(budget Budget, summary map[string]EnvUsageSummary) float64 {
+	spentOf := func(item EnvUsageSummary) float64 {
+		if item.Metered != nil && item.Metered.TotalCost > item.TotalCost {
+			return item.Metered.TotalCost
+		}
+		return item.TotalCost
+	}
+	if budget.Scope == budgetScopeEnv {
+		return spentOf(summary[budget.Target])
+	}
+	total := 0.0
+	for _, item := range summary {
+		if strings.EqualFold(item.Provider, budget.Target) {
+			total += spentOf(item)
+		}
+	}
+	return total
+}
+
+func loadBudgetStore() (budgetStore, error) {
+	store := budgetStore{}
+	if _, err := loadJSONStore(budgetStoreFile, &store); err != nil {
+		return store, err
+	}
+	if store.Budgets == nil {
+		store.Budgets = []Budget{}
+	}
+	if store.State == nil {
+		store.State = map[string]budgetPeriodState{}
+	}
+	return store, nil
+}
+
+func saveBudgetStore(store budgetStore) error {
+	return saveJSONStore(budgetStoreFile, store, 0o644)
+}
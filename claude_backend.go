@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Claude 环境的后端类型（EnvConfig.Backend），空字符串表示旧版自由变量模式
+const (
+	claudeBackendAPI     = "api"     // 直连 Anthropic API
+	claudeBackendRelay   = "relay"   // 第三方中转（ANTHROPIC_BASE_URL）
+	claudeBackendBedrock = "bedrock" // Amazon Bedrock
+	claudeBackendVertex  = "vertex"  // Google Vertex AI
+)
+
+// 各后端专属的变量（用于剔除冲突项）
+var (
+	claudeAnthropicVars = []string{"ANTHROPIC_API_KEY", "ANTHROPIC_AUTH_TOKEN", "ANTHROPIC_BASE_URL", "API_BASE_URL"}
+	claudeBedrockVars   = []string{"CLAUDE_CODE_USE_BEDROCK", "CLAUDE_CODE_SKIP_BEDROCK_AUTH", "ANTHROPIC_BEDROCK_BASE_URL", "AWS_REGION", "AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_BEARER_TOKEN_BEDROCK"}
+	claudeVertexVars    = []string{"CLAUDE_CODE_USE_VERTEX", "CLAUDE_CODE_SKIP_VERTEX_AUTH", "ANTHROPIC_VERTEX_BASE_URL", "CLOUD_ML_REGION", "ANTHROPIC_VERTEX_PROJECT_ID", "GOOGLE_APPLICATION_CREDENTIALS"}
+)
+
+// normalizeClaudeBackend 规范化后端类型；无法识别时返回空字符串（旧版自由变量模式）
+func normalizeClaudeBackend(backend string) string {
+	switch strings.ToLower(strings.TrimSpace(backend)) {
+	case claudeBackendAPI, "direct", "anthropic":
+		return claudeBackendAPI
+	case claudeBackendRelay, "proxy":
+		return claudeBackendRelay
+	case claudeBackendBedrock, "aws":
+		return claudeBackendBedrock
+	case claudeBackendVertex, "vertex-ai", "gcp":
+		return claudeBackendVertex
+	default:
+		return ""
+	}
+}
+
+// validateClaudeEnv 按后端类型检查必填变量
+func validateClaudeEnv(env *EnvConfig) error {
+	vars := env.Variables
+	has := func(key string) bool { return strings.TrimSpace(vars[key]) != "" }
+	requireAll := func(label string, keys ...string) error {
+		var missing []string
+		for _, key := range keys {
+			if !has(key) {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%s 后端缺少 %s", label, strings.Join(missing, ", "))
+		}
+		return nil
+	}
+
+	switch normalizeClaudeBackend(env.Backend) {
+	case claudeBackendAPI:
+		if !has("ANTHROPIC_API_KEY") && !has("ANTHROPIC_AUTH_TOKEN") {
+			return fmt.Errorf("Anthropic API 后端需要 ANTHROPIC_API_KEY 或 ANTHROPIC_AUTH_TOKEN")
+		}
+	case claudeBackendRelay:
+		if err := requireAll("中转", "ANTHROPIC_BASE_URL"); err != nil {
+			return err
+		}
+		if !has("ANTHROPIC_AUTH_TOKEN") && !has("ANTHROPIC_API_KEY") {
+			return fmt.Errorf("中转后端需要 ANTHROPIC_AUTH_TOKEN 或 ANTHROPIC_API_KEY")
+		}
+	case claudeBackendBedrock:
+		if err := requireAll("Bedrock", "AWS_REGION", "ANTHROPIC_MODEL"); err != nil {
+			return err
+		}
+		hasKeys := has("AWS_ACCESS_KEY_ID") && has("AWS_SECRET_ACCESS_KEY")
+		if !hasKeys && !has("AWS_PROFILE") && !has("AWS_BEARER_TOKEN_BEDROCK") && !has("CLAUDE_CODE_SKIP_BEDROCK_AUTH") {
+			return fmt.Errorf("Bedrock 后端需要 AWS_PROFILE、AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY 或 AWS_BEARER_TOKEN_BEDROCK")
+		}
+	case claudeBackendVertex:
+		if err := requireAll("Vertex", "CLOUD_ML_REGION", "ANTHROPIC_VERTEX_PROJECT_ID"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claudeBackendEnv 过滤掉与后端冲突的变量，并补上后端开关；旧版模式原样返回
+func claudeBackendEnv(env *EnvConfig) map[string]string {
+	result := make(map[string]string, len(env.Variables))
+	for key, value := range env.Variables {
+		if value != "" {
+			result[key] = value
+		}
+	}
+
+	backend := normalizeClaudeBackend(env.Backend)
+	var conflicts []string
+	switch backend {
+	case claudeBackendAPI:
+		conflicts = append(append([]string{"ANTHROPIC_BASE_URL", "API_BASE_URL"}, claudeBedrockVars...), claudeVertexVars...)
+	case claudeBackendRelay:
+		conflicts = append(append([]string{}, claudeBedrockVars...), claudeVertexVars...)
+	case claudeBackendBedrock:
+		conflicts = append(append([]string{}, claudeAnthropicVars...), claudeVertexVars...)
+	case claudeBackendVertex:
+		conflicts = append(append([]string{}, claudeAnthropicVars...), claudeBedrockVars...)
+	default:
+		return result
+	}
+
+	for _, key := range conflicts {
+		delete(result, key)
+	}
+	switch backend {
+	case claudeBackendBedrock:
+		result["CLAUDE_CODE_USE_BEDROCK"] = "1"
+	case claudeBackendVertex:
+		result["CLAUDE_CODE_USE_VERTEX"] = "1"
+	}
+	return result
+}
+
+// claudeBackendURL 返回用于健康检查的 URL
+func claudeBackendURL(env EnvConfig) string {
+	vars := env.Variables
+	switch normalizeClaudeBackend(env.Backend) {
+	case claudeBackendAPI:
+		return "https://api.anthropic.com"
+	case claudeBackendRelay:
+		return strings.TrimSpace(vars["ANTHROPIC_BASE_URL"])
+	case claudeBackendBedrock:
+		if v := strings.TrimSpace(vars["ANTHROPIC_BEDROCK_BASE_URL"]); v != "" {
+			return v
+		}
+		if region := strings.TrimSpace(vars["AWS_REGION"]); region != "" {
+			return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+		}
+		return ""
+	case claudeBackendVertex:
+		if v := strings.TrimSpace(vars["ANTHROPIC_VERTEX_BASE_URL"]); v != "" {
+			return v
+		}
+		region := strings.TrimSpace(vars["CLOUD_ML_REGION"])
+		if region == "" || region == "global" {
+			return "https://aiplatform.googleapis.com"
+		}
+		return fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+	default:
+		if v := strings.TrimSpace(vars["ANTHROPIC_BASE_URL"]); v != "" {
+			return v
+		}
+		return strings.TrimSpace(vars["API_BASE_URL"])
+	}
+}
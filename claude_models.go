@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// ClaudeModelMapping Claude 环境的模型分级映射，应用时展开为对应的环境变量
+type ClaudeModelMapping struct {
+	Model     string `json:"model,omitempty"`      // ANTHROPIC_MODEL
+	SmallFast string `json:"small_fast,omitempty"` // ANTHROPIC_SMALL_FAST_MODEL
+	Sonnet    string `json:"sonnet,omitempty"`     // ANTHROPIC_DEFAULT_SONNET_MODEL
+	Opus      string `json:"opus,omitempty"`       // ANTHROPIC_DEFAULT_OPUS_MODEL
+	Haiku     string `json:"haiku,omitempty"`      // ANTHROPIC_DEFAULT_HAIKU_MODEL
+	// 计价别名：中转模型名 -> Anthropic 模型名；sonnet/opus/haiku 未在此指定时按对应档位的默认模型计价
+	PriceAs map[string]string `json:"price_as,omitempty"`
+}
+
+// 各档位用于计价的默认 Anthropic 模型
+var claudeTierPricingModels = map[string]string{
+	"sonnet": "claude-sonnet-4-5-20250929",
+	"opus":   "claude-opus-4-5-20251101",
+	"haiku":  "claude-haiku-4-5-20251001",
+}
+
+// expandClaudeModelMapping 将模型映射写入 envMap（覆盖 Variables 中的同名变量）
+func expandClaudeModelMapping(envMap map[string]string, mapping *ClaudeModelMapping) {
+	if mapping == nil {
+		return
+	}
+	set := func(key, value string) {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			envMap[key] = trimmed
+		}
+	}
+	set("ANTHROPIC_MODEL", mapping.Model)
+	set("ANTHROPIC_SMALL_FAST_MODEL", mapping.SmallFast)
+	set("ANTHROPIC_DEFAULT_SONNET_MODEL", mapping.Sonnet)
+	set("ANTHROPIC_DEFAULT_OPUS_MODEL", mapping.Opus)
+	set("ANTHROPIC_DEFAULT_HAIKU_MODEL", mapping.Haiku)
+}
+
+// pricingAliases 返回映射中 中转模型名 -> Anthropic 模型名 的计价别名
+func (m *ClaudeModelMapping) pricingAliases() map[string]string {
+	aliases := map[string]string{}
+	if m == nil {
+		return aliases
+	}
+	tiers := map[string]string{
+		"sonnet": m.Sonnet,
+		"opus":   m.Opus,
+		"haiku":  m.Haiku,
+	}
+	for tier, model := range tiers {
+		if name := strings.TrimSpace(model); name != "" {
+			aliases[strings.ToLower(name)] = claudeTierPricingModels[tier]
+		}
+	}
+	// ANTHROPIC_MODEL / SMALL_FAST 与某个档位同名时已按该档位计价，其余名称通过 PriceAs 指定
+	for from, to := range m.PriceAs {
+		from = strings.ToLower(strings.TrimSpace(from))
+		to = strings.TrimSpace(to)
+		if from != "" && to != "" {
+			aliases[from] = to
+		}
+	}
+	return aliases
+}
+
+// loadModelPricingAliases 从主配置文件读取所有 Claude 环境的计价别名
+func loadModelPricingAliases(configPath string) map[string]string {
+	aliases := map[string]string{}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return aliases
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return aliases
+	}
+	for _, env := range config.Environments {
+		for from, to := range env.ModelMapping.pricingAliases() {
+			aliases[from] = to
+		}
+	}
+	return aliases
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	codexAuthStashDir = "codex_auth"
+
+	codexAuthAPIKey  = "apikey"  // 默认：OPENAI_API_KEY 写入 auth.json
+	codexAuthChatGPT = "chatgpt" // ChatGPT 登录：恢复已保存的 OAuth auth.json
+	codexAuthEnvKey  = "env_key" // 密钥放在环境变量中，通过 model_providers.<env>.env_key 引用
+)
+
+// normalizeCodexAuthMode 规范化 Codex 环境的认证方式，未设置时为 apikey
+func normalizeCodexAuthMode(mode string) string {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case codexAuthChatGPT, "chatgpt-login", "oauth":
+		return codexAuthChatGPT
+	case codexAuthEnvKey, "env":
+		return codexAuthEnvKey
+	default:
+		return codexAuthAPIKey
+	}
+}
+
+// codexEnvKeyName 返回 env_key 模式下保存密钥的环境变量名（可用 env_key 变量覆盖）
+func codexEnvKeyName(env *EnvConfig) string {
+	if name := strings.TrimSpace(env.Variables["env_key"]); name != "" {
+		return name
+	}
+	key := strings.ToUpper(strings.ReplaceAll(codexProfileKey(env.Name), "-", "_"))
+	if key == "" {
+		return "OPENAI_API_KEY"
+	}
+	return "CODEX_" + key + "_API_KEY"
+}
+
+// codexAuthAccount 解析 auth.json 中的 ChatGPT 登录信息；非 ChatGPT 登录时 ok 为 false
+func codexAuthAccount(data []byte) (string, bool) {
+	var payload map[string]any
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return "", false
+	}
+	tokens, ok := payload["tokens"].(map[string]any)
+	if !ok || tokens == nil {
+		return "", false
+	}
+	if account, ok := tokens["account_id"].(string); ok && strings.TrimSpace(account) != "" {
+		return strings.TrimSpace(account), true
+	}
+	return "default", true
+}
+
+func codexAuthStashPath(account string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, mcpStoreDir, codexAuthStashDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	name := codexProfileKeyPattern.ReplaceAllString(strings.ToLower(account), "-")
+	if strings.Trim(name, "-") == "" {
+		name = "default"
+	}
+	return filepath.Join(dir, "login-"+name+".json"), nil
+}
+
+// stashCodexLogin 若当前 auth.json 是 ChatGPT 登录，则保存一份副本；
+// loginEnv 为当前使用该登录的环境名称（可为空），用于切回时恢复同一账号
+func stashCodexLogin(authFile, loginEnv string) error {
+	data, err := os.ReadFile(authFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("读取 auth.json 失败: %v", err)
+	}
+	account, ok := codexAuthAccount(data)
+	if !ok {
+		return nil
+	}
+
+	stashFile, err := codexAuthStashPath(account)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(stashFile, data, 0o600); err != nil {
+		return fmt.Errorf("保存 ChatGPT 登录失败: %v", err)
+	}
+
+	codexStateMu.Lock()
+	defer codexStateMu.Unlock()
+	state, err := loadCodexState()
+	if err != nil {
+		return err
+	}
+	state.LastLogin = account
+	if loginEnv != "" {
+		if state.EnvLogins == nil {
+			state.EnvLogins = map[string]string{}
+		}
+		state.EnvLogins[loginEnv] = account
+	}
+	return saveCodexState(state)
+}
+
+// restoreCodexLogin 恢复 envName 对应（或最近一次）的 ChatGPT 登录到 auth.json
+func restoreCodexLogin(authFile, envName string) (bool, error) {
+	codexStateMu.Lock()
+	state, err := loadCodexState()
+	codexStateMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	account := state.EnvLogins[envName]
+	if account == "" {
+		account = state.LastLogin
+	}
+	if account == "" {
+		return false, nil
+	}
+
+	stashFile, err := codexAuthStashPath(account)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(stashFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("读取已保存的 ChatGPT 登录失败: %v", err)
+	}
+	if err := os.WriteFile(authFile, data, 0o600); err != nil {
+		return false, fmt.Errorf("写入 auth.json 失败: %v", err)
+	}
+	return true, nil
+}
+
+// setCodexActiveLoginEnv 记录当前使用 ChatGPT 登录的环境（非 ChatGPT 环境传空字符串）
+func setCodexActiveLoginEnv(envName string) error {
+	codexStateMu.Lock()
+	defer codexStateMu.Unlock()
+	state, err := loadCodexState()
+	if err != nil {
+		return err
+	}
+	state.ActiveLoginEnv = envName
+	return saveCodexState(state)
+}
+
+// applyCodexAuth 根据环境的认证方式处理 ~/.codex/auth.json
+func (a *App) applyCodexAuth(env *EnvConfig, codexDir string) (string, error) {
+	authFile := filepath.Join(codexDir, "auth.json")
+
+	codexStateMu.Lock()
+	state, err := loadCodexState()
+	codexStateMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	// 覆盖之前先保存现有的 ChatGPT 登录
+	if err := stashCodexLogin(authFile, state.ActiveLoginEnv); err != nil {
+		return "", err
+	}
+
+	switch normalizeCodexAuthMode(env.AuthMode) {
+	case codexAuthChatGPT:
+		if err := setCodexActiveLoginEnv(env.Name); err != nil {
+			return "", err
+		}
+		if data, err := os.ReadFile(authFile); err == nil {
+			if _, ok := codexAuthAccount(data); ok && state.EnvLogins[env.Name] == "" {
+				return "已使用现有 ChatGPT 登录", nil
+			}
+		}
+		restored, err := restoreCodexLogin(authFile, env.Name)
+		if err != nil {
+			return "", err
+		}
+		if !restored {
+			return "未找到已保存的 ChatGPT 登录，请运行 codex login", nil
+		}
+		return "已恢复 ChatGPT 登录", nil
+
+	case codexAuthEnvKey:
+		if err := setCodexActiveLoginEnv(""); err != nil {
+			return "", err
+		}
+		// auth.json 不再需要 API Key，恢复 ChatGPT 登录（如有），否则保持原样
+		if _, err := restoreCodexLogin(authFile, ""); err != nil {
+			return "", err
+		}
+		return a.exportCodexEnvKey(env)
+
+	default:
+		if err := setCodexActiveLoginEnv(""); err != nil {
+			return "", err
+		}
+		var authContent string
+		if tmpl, ok := env.Templates["auth.json"]; ok && tmpl != "" {
+			authContent = tmpl
+			authContent = strings.ReplaceAll(authContent, "{{OPENAI_API_KEY}}", env.Variables["OPENAI_API_KEY"])
+		} else {
+			encoded, err := json.MarshalIndent(map[string]string{"OPENAI_API_KEY": env.Variables["OPENAI_API_KEY"]}, "", "  ")
+			if err != nil {
+				return "", fmt.Errorf("序列化 auth.json 失败: %v", err)
+			}
+			authContent = string(encoded)
+		}
+		if err := os.WriteFile(authFile, []byte(authContent), 0644); err != nil {
+			return "", fmt.Errorf("写入 auth.json 失败: %v", err)
+		}
+		return "", nil
+	}
+}
+
+// clearCodexAuth 清除 API Key 形式的 auth.json；若曾保存过 ChatGPT 登录则恢复之
+func clearCodexAuth(codexDir string) error {
+	authFile := filepath.Join(codexDir, "auth.json")
+	if err := stashCodexLogin(authFile, ""); err != nil {
+		return err
+	}
+	if err := setCodexActiveLoginEnv(""); err != nil {
+		return err
+	}
+	restored, err := restoreCodexLogin(authFile, "")
+	if err != nil {
+		return err
+	}
+	if !restored {
+		if err := os.Remove(authFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除 auth.json 失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// exportCodexEnvKey 提供 env_key 模式的密钥：能持久化用户环境变量的平台（Windows）直接写入；
+// 其他平台只能设置本进程，codex CLI 看不到，因此返回变量名由用户自行 export
+func (a *App) exportCodexEnvKey(env *EnvConfig) (string, error) {
+	keyName := codexEnvKeyName(env)
+	apiKey := strings.TrimSpace(env.Variables["OPENAI_API_KEY"])
+	if apiKey != "" && platformPersistsEnvVars {
+		if err := a.SetEnvVar(keyName, apiKey); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("密钥已写入用户环境变量 %s，新开的终端生效", keyName), nil
+	}
+	if current := os.Getenv(keyName); current != "" && (apiKey == "" || current == apiKey) {
+		// 启动本软件的环境中已有该变量
+		return fmt.Sprintf("密钥通过环境变量 %s 提供", keyName), nil
+	}
+	return fmt.Sprintf("请在运行 codex 的 shell 中设置环境变量 %s（如 export %s=<API Key>），Codex 从该变量读取密钥", keyName, keyName), nil
+}
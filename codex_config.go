@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const codexStateFile = "codex_state.json"
+
+var (
+	codexStateMu           sync.Mutex
+	codexProfileKeyPattern = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// codexState 记录本软件在 ~/.codex/config.toml 中托管的 profile / model_provider 名称，
+// 用于在环境被删除或改名后清理残留的表，而不误删用户手写的表
+type codexState struct {
+	ManagedProfiles []string `json:"managed_profiles"`
+	// ChatGPT 登录暂存：环境名 -> 账号；LastLogin 为最近一次保存的账号
+	EnvLogins      map[string]string `json:"env_logins,omitempty"`
+	LastLogin      string            `json:"last_login,omitempty"`
+	ActiveLoginEnv string            `json:"active_login_env,omitempty"`
+}
+
+// codexProfileKey 将环境名称转换为可直接用于 `codex --profile <key>` 的名称
+func codexProfileKey(envName string) string {
+	key := strings.ToLower(strings.TrimSpace(envName))
+	key = codexProfileKeyPattern.ReplaceAllString(key, "-")
+	return strings.Trim(key, "-")
+}
+
+// readCodexConfigDocument 读取现有 config.toml；文件不存在时返回空文档
+func readCodexConfigDocument(configFile string) (*tomlDocument, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return parseTOMLDocument(nil)
+		}
+		return nil, fmt.Errorf("读取 config.toml 失败: %v", err)
+	}
+	doc, err := parseTOMLDocument(data)
+	if err != nil {
+		return nil, fmt.Errorf("现有 config.toml 不是有效 TOML，已停止写入以免覆盖: %v", err)
+	}
+	return doc, nil
+}
+
+// mergeCodexEnvProfiles 为每个 Codex 环境写入 [model_providers.<key>] 与 [profiles.<key>]，
+// 并把顶层 profile / model_provider 指向当前环境；只改写托管的表，文档其余部分（含注释）保持不变
+func (a *App) mergeCodexEnvProfiles(doc *tomlDocument, active *EnvConfig) error {
+	codexStateMu.Lock()
+	defer codexStateMu.Unlock()
+
+	state, err := loadCodexState()
+	if err != nil {
+		return err
+	}
+	payload, err := doc.Map()
+	if err != nil {
+		return err
+	}
+
+	providers := ensureTable(payload, "model_providers")
+	profiles := ensureTable(payload, "profiles")
+
+	// 不在 ManagedProfiles 中的同名表是用户手写的，不覆盖；当前环境遇到冲突时停止写入
+	userOwned := func(key string) bool {
+		if containsString(state.ManagedProfiles, key) {
+			return false
+		}
+		_, hasProfile := profiles[key]
+		_, hasProvider := providers[key]
+		return hasProfile || hasProvider
+	}
+
+	managed := make([]string, 0)
+	for i := range a.config.Environments {
+		env := a.config.Environments[i]
+		if strings.ToLower(strings.TrimSpace(env.Provider)) != "codex" {
+			continue
+		}
+		key := codexProfileKey(env.Name)
+		if key == "" {
+			continue
+		}
+		isActive := env.Name == active.Name
+		if isActive {
+			// 当前环境使用传入的副本（网关开启 RouteCLI 时 base_url 指向网关）
+			env = *active
+		}
+		if containsString(managed, key) {
+			// 旧配置中可能残留同名冲突（保存时已禁止）
+			if isActive {
+				return fmt.Errorf("环境 '%s' 与其他 Codex 环境对应同一个 profile '%s'，请重命名", env.Name, key)
+			}
+			continue
+		}
+		if userOwned(key) {
+			if isActive {
+				return fmt.Errorf("config.toml 中已有非本软件创建的 [profiles.%s] 或 [model_providers.%s]，为避免覆盖已停止写入，请重命名环境或移除该表", key, key)
+			}
+			continue
+		}
+		if err := a.writeCodexEnvTables(providers, profiles, key, &env); err != nil {
+			return err
+		}
+		managed = append(managed, key)
+	}
+
+	// 把托管的表写回文档；已不存在的环境对应的表一并删除
+	for _, key := range append(append([]string{}, managed...), state.ManagedProfiles...) {
+		for _, parent := range []struct {
+			name  string
+			table map[string]any
+		}{{"model_providers", providers}, {"profiles", profiles}} {
+			values, ok := parent.table[key].(map[string]any)
+			if !ok || !containsString(managed, key) {
+				doc.Delete([]string{parent.name, key})
+				continue
+			}
+			if err := doc.SetTable([]string{parent.name, key}, values); err != nil {
+				return err
+			}
+		}
+	}
+
+	activeKey := codexProfileKey(active.Name)
+	if activeKey == "" {
+		return fmt.Errorf("环境名称 '%s' 无法转换为 Codex profile 名称", active.Name)
+	}
+	activeProvider := any(activeKey)
+	if activeProfile, ok := profiles[activeKey].(map[string]any); ok {
+		activeProvider = activeProfile["model_provider"]
+	}
+	if err := doc.Set([]string{"profile"}, activeKey); err != nil {
+		return err
+	}
+	if err := doc.Set([]string{"model_provider"}, activeProvider); err != nil {
+		return err
+	}
+
+	state.ManagedProfiles = managed
+	return saveCodexState(state)
+}
+
+// validateCodexProfileKey 检查 Codex 环境名转换出的 profile 名称非空，且不与其他 Codex 环境相同
+// （如 "My Env" 与 "my-env"）；skipName 为正在更新的环境的原名称
+func validateCodexProfileKey(envs []EnvConfig, env EnvConfig, skipName string) error {
+	if strings.ToLower(strings.TrimSpace(env.Provider)) != "codex" {
+		return nil
+	}
+	key := codexProfileKey(env.Name)
+	if key == "" {
+		return fmt.Errorf("环境名称 '%s' 无法转换为 Codex profile 名称", env.Name)
+	}
+	for _, other := range envs {
+		if other.Name == skipName || strings.ToLower(strings.TrimSpace(other.Provider)) != "codex" {
+			continue
+		}
+		if codexProfileKey(other.Name) == key {
+			return fmt.Errorf("环境名称 '%s' 与 '%s' 对应同一个 Codex profile '%s'，请换一个名称", env.Name, other.Name, key)
+		}
+	}
+	return nil
+}
+
+func (a *App) writeCodexEnvTables(providers, profiles map[string]any, key string, env *EnvConfig) error {
+	vars := env.Variables
+	authMode := normalizeCodexAuthMode(env.AuthMode)
+
+	profile := ensureTable(profiles, key)
+	if authMode == codexAuthChatGPT {
+		// ChatGPT 登录使用 Codex 内置的 openai provider
+		delete(providers, key)
+		profile["model_provider"] = "openai"
+	} else {
+		provider := ensureTable(providers, key)
+		provider["name"] = env.Name
+		setOrDeleteString(provider, "base_url", vars["base_url"])
+		if wireAPI := strings.TrimSpace(vars["wire_api"]); wireAPI != "" {
+			provider["wire_api"] = wireAPI
+		} else if _, ok := provider["wire_api"]; !ok {
+			provider["wire_api"] = "responses"
+		}
+		if authMode == codexAuthEnvKey {
+			provider["env_key"] = codexEnvKeyName(env)
+			delete(provider, "requires_openai_auth")
+		} else {
+			provider["requires_openai_auth"] = true
+			delete(provider, "env_key")
+		}
+		profile["model_provider"] = key
+	}
+	setOrDeleteString(profile, "model", vars["model"])
+	profile["model_reasoning_effort"] = firstNonEmpty(vars["model_reasoning_effort"], "high")
+
+	permission, err := a.resolveEnvPermissionProfile(env)
+	if err != nil {
+		return err
+	}
+	approvalPolicy, sandboxMode := "", ""
+	if permission != nil {
+		approvalPolicy, sandboxMode = permission.CodexApprovalPolicy, permission.CodexSandboxMode
+	}
+	setOrDeleteString(profile, "approval_policy", approvalPolicy)
+	setOrDeleteString(profile, "sandbox_mode", sandboxMode)
+	return nil
+}
+
+func ensureTable(parent map[string]any, key string) map[string]any {
+	if table, ok := parent[key].(map[string]any); ok && table != nil {
+		return table
+	}
+	table := map[string]any{}
+	parent[key] = table
+	return table
+}
+
+func setOrDeleteString(table map[string]any, key, value string) {
+	if trimmed := strings.TrimSpace(value); trimmed != "" {
+		table[key] = trimmed
+		return
+	}
+	delete(table, key)
+}
+
+func loadCodexState() (codexState, error) {
+	var state codexState
+	if _, err := loadJSONStore(codexStateFile, &state); err != nil {
+		return codexState{}, err
+	}
+	return state, nil
+}
+
+func saveCodexState(state codexState) error {
+	return saveJSONStore(codexStateFile, state, 0o644)
+}
+
+// removeCodexEnvProfiles 移除本软件托管的 profile / model_provider 表，顶层指向它们时一并清除
+func removeCodexEnvProfiles(doc *tomlDocument) error {
+	codexStateMu.Lock()
+	defer codexStateMu.Unlock()
+
+	state, err := loadCodexState()
+	if err != nil {
+		return err
+	}
+	for _, key := range state.ManagedProfiles {
+		doc.Delete([]string{"model_providers", key})
+		doc.Delete([]string{"profiles", key})
+	}
+	clearCodexActiveProfile(doc, state)
+
+	state.ManagedProfiles = nil
+	return saveCodexState(state)
+}
+
+// clearCodexActiveProfile 顶层 profile 指向托管的表时连同本软件写入的 model_provider 一起删除，
+// 使模板或用户自己的顶层配置生效
+func clearCodexActiveProfile(doc *tomlDocument, state codexState) {
+	managedValue := func(key string) bool {
+		v, _ := doc.Get([]string{key})
+		name, ok := v.(string)
+		return ok && containsString(state.ManagedProfiles, name)
+	}
+	if managedValue("profile") {
+		doc.Delete([]string{"profile"})
+		doc.Delete([]string{"model_provider"})
+	} else if managedValue("model_provider") {
+		doc.Delete([]string{"model_provider"})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCodexConfigKeepsUserFormatting(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	configFile := filepath.Join(home, "config.toml")
+	original := `# 我的 Codex 配置
+model = "o3" # 默认模型
+
+[tui]
+# 关闭动画
+animations = false
+
+[mcp_servers.fs]
+command = "npx"
+`
+	if err := os.WriteFile(configFile, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := EnvConfig{Name: "Work", Provider: "codex", Variables: map[string]string{
+		"base_url": "https://example.com/v1",
+		"model":    "gpt-5",
+	}}
+	a := &App{config: Config{Environments: []EnvConfig{env}}}
+	doc, err := readCodexConfigDocument(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.mergeCodexEnvProfiles(doc, &env); err != nil {
+		t.Fatal(err)
+	}
+	switched := string(doc.Bytes())
+	for _, want := range []string{"# 我的 Codex 配置\n", `model = "o3" # 默认模型`, "# 关闭动画\n", "profile = 'work'", "[profiles.work]", "[model_providers.work]"} {
+		if !strings.Contains(switched, want) {
+			t.Errorf("switched config lacks %q:\n%s", want, switched)
+		}
+	}
+	if err := os.WriteFile(configFile, doc.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模板环境清除指向托管 profile 的顶层键，保留托管的表与用户内容
+	data, err := buildCodexConfigData("model = \"gpt-4.1\"\n", configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templated := string(data)
+	for _, unwanted := range []string{"profile = 'work'", "model_provider = 'work'\n\n"} {
+		if strings.Contains(templated, unwanted) {
+			t.Errorf("templated config still has %q:\n%s", unwanted, templated)
+		}
+	}
+	for _, want := range []string{`model = 'gpt-4.1' # 默认模型`, "# 关闭动画\n", "[profiles.work]"} {
+		if !strings.Contains(templated, want) {
+			t.Errorf("templated config lacks %q:\n%s", want, templated)
+		}
+	}
+
+	// 清除时只移除托管部分，恢复为用户原来的内容
+	doc, err = readCodexConfigDocument(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := removeCodexEnvProfiles(doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(doc.Bytes()); got != original {
+		t.Errorf("cleared config:\n%s\nwant:\n%s", got, original)
+	}
+}
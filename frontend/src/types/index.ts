@@ -68,6 +68,7 @@ export interface UptimeSettings {
   interval_seconds: number
   timeout_seconds: number
   keep_last: number
+  balance_interval_seconds?: number
 }
 
 export interface RotationGroup {
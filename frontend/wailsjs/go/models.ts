@@ -272,6 +272,7 @@ export namespace main {
 	    interval_seconds: number;
 	    timeout_seconds: number;
 	    keep_last: number;
+	    balance_interval_seconds: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new UptimeSettings(source);
@@ -283,6 +284,7 @@ export namespace main {
 	        this.interval_seconds = source["interval_seconds"];
 	        this.timeout_seconds = source["timeout_seconds"];
 	        this.keep_last = source["keep_last"];
+	        this.balance_interval_seconds = source["balance_interval_seconds"];
 	    }
 	}
 	export class UptimeSnapshot {
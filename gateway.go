@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gatewayStoreFile       = "gateway.json"
+	gatewayDefaultPort     = 18765
+	gatewayMaxBody         = 32 * 1024 * 1024
+	gatewayDefaultCooldown = 60 * time.Second
+	gatewayMaxCooldown     = 10 * time.Minute
+)
+
+// gatewayProviders 网关支持的 Provider（请求路径的第一段）
+var gatewayProviders = []string{"claude", "codex", "gemini", "openclaw"}
+
+// 网关实际监听的端口，0 表示未运行；应用环境时只在网关运行时把 CLI 指向网关
+var (
+	gatewayListenMu   sync.Mutex
+	gatewayListenPort int
+)
+
+// GatewayService 本地 API 网关：CLI 指向 http://127.0.0.1:<port>/<provider>，
+// 请求按当前激活环境转发并注入密钥，切换环境无需重启 CLI
+type GatewayService struct {
+	mu          sync.Mutex
+	app         *App
+	uptime      *UptimeService // 读取轮换组
+	server      *http.Server
+	addr        string
+	token       string // 客户端访问网关需携带的令牌
+	lastError   string
+	transports  map[string]*http.Transport // 代理设置指纹 -> Transport，复用上游连接
+	cooldowns   map[string]time.Time       // 环境名 -> 冷却截止时间
+	rrCounters  map[string]uint64          // 轮换组 -> 轮询计数
+	weightState map[string]map[string]int  // 轮换组 -> 平滑加权轮询的累计值
+}
+
+func NewGatewayService(app *App, uptime *UptimeService) *GatewayService {
+	return &GatewayService{
+		app:         app,
+		uptime:      uptime,
+		transports:  map[string]*http.Transport{},
+		cooldowns:   map[string]time.Time{},
+		rrCounters:  map[string]uint64{},
+		weightState: map[string]map[string]int{},
+	}
+}
+
+// GatewaySettings 网关设置
+type GatewaySettings struct {
+	Enabled bool `json:"enabled"` // 启用后随应用启动
+	Port    int  `json:"port"`
+	// RouteCLI 应用环境时将 CLI 的 Base URL 写为网关地址
+	RouteCLI bool `json:"route_cli"`
+	// Token 访问网关的令牌：RouteCLI 时作为 API Key 写入 CLI 配置，真实密钥只由网关注入
+	Token string `json:"token,omitempty"`
+}
+
+// GatewayStatus 网关运行状态
+type GatewayStatus struct {
+	Settings  GatewaySettings   `json:"settings"`
+	Running   bool              `json:"running"`
+	Address   string            `json:"address,omitempty"`
+	Endpoints map[string]string `json:"endpoints"` // provider -> CLI 使用的 Base URL
+	Cooldowns map[string]int64  `json:"cooldowns"` // 冷却中的环境 -> 截止时间（Unix 秒）
+	Error     string            `json:"error,omitempty"`
+}
+
+// gatewayUpstream 请求转发的目标
+type gatewayUpstream struct {
+	EnvName  string
+	BaseURL  *url.URL
+	Headers  map[string]string // 注入的认证头
+	Proxy    *ProxyConfig
+	Cooldown time.Duration // 失败后的冷却时间
+	// KeyFingerprint 注入密钥的指纹，用于按密钥统计用量
+	KeyFingerprint string
+	// Protocol 环境声明的上游协议，空字符串表示与 Provider 一致
+	Protocol string
+	// PricingAliases 环境模型映射的计价别名，计量时用于确定模型的长上下文阈值
+	PricingAliases map[string]string
+}
+
+// OnStartup 应用启动时按设置启动网关
+func (gs *GatewayService) OnStartup(ctx context.Context) {
+	settings, err := loadGatewaySettings()
+	if err != nil || !settings.Enabled {
+		return
+	}
+	if err := gs.StartGateway(); err != nil {
+		gs.mu.Lock()
+		gs.lastError = err.Error()
+		gs.mu.Unlock()
+	}
+}
+
+// OnShutdown 应用退出时关闭网关
+func (gs *GatewayService) OnShutdown(ctx context.Context) {
+	_ = gs.StopGateway()
+	flushMeteredUsage()
+}
+
+// GetGatewayStatus 获取网关设置与运行状态
+func (gs *GatewayService) GetGatewayStatus() (GatewayStatus, error) {
+	settings, err := loadGatewaySettings()
+	if err != nil {
+		return GatewayStatus{}, err
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	status := GatewayStatus{
+		Settings:  settings,
+		Running:   gs.server != nil,
+		Address:   gs.addr,
+		Endpoints: gatewayEndpoints(settings.Port),
+		Cooldowns: map[string]int64{},
+		Error:     gs.lastError,
+	}
+	now := time.Now()
+	for name, until := range gs.cooldowns {
+		if now.Before(until) {
+			status.Cooldowns[name] = until.Unix()
+		}
+	}
+	return status, nil
+}
+
+// SaveGatewaySettings 保存网关设置；运行中且端口变化时重启，禁用时停止
+func (gs *GatewayService) SaveGatewaySettings(settings GatewaySettings) error {
+	settings = normalizeGatewaySettings(settings)
+	previous, err := loadGatewaySettings()
+	if err != nil {
+		return err
+	}
+	// 令牌只能通过 ResetGatewayToken 更换
+	settings.Token = previous.Token
+	if err := saveGatewaySettings(settings); err != nil {
+		return fmt.Errorf("保存网关设置失败: %v", err)
+	}
+
+	gs.mu.Lock()
+	running := gs.server != nil
+	gs.mu.Unlock()
+
+	switch {
+	case !settings.Enabled:
+		return gs.StopGateway()
+	case !running:
+		return gs.StartGateway()
+	case previous.Port != settings.Port:
+		if err := gs.StopGateway(); err != nil {
+			return err
+		}
+		return gs.StartGateway()
+	}
+	return nil
+}
+
+// ResetGatewayToken 更换访问令牌；使用旧令牌的 CLI 需要重新应用环境
+func (gs *GatewayService) ResetGatewayToken() (string, error) {
+	settings, err := loadGatewaySettings()
+	if err != nil {
+		return "", err
+	}
+	token, err := newGatewayToken()
+	if err != nil {
+		return "", err
+	}
+	settings.Token = token
+	if err := saveGatewaySettings(settings); err != nil {
+		return "", fmt.Errorf("保存网关设置失败: %v", err)
+	}
+	gs.mu.Lock()
+	gs.token = token
+	gs.mu.Unlock()
+	return token, nil
+}
+
+// StartGateway 启动网关（仅监听 127.0.0.1）
+func (gs *GatewayService) StartGateway() error {
+	settings, err := ensureGatewayToken()
+	if err != nil {
+		return err
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.server != nil {
+		return nil
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", settings.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		gs.lastError = fmt.Sprintf("监听 %s 失败: %v", addr, err)
+		return errors.New(gs.lastError)
+	}
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(gs.serveGateway),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	gs.server = server
+	gs.addr = listener.Addr().String()
+	gs.token = settings.Token
+	gs.lastError = ""
+	setGatewayListenPort(listener.Addr().(*net.TCPAddr).Port)
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			gs.mu.Lock()
+			gs.lastError = err.Error()
+			if gs.server == server {
+				gs.server = nil
+				gs.addr = ""
+				setGatewayListenPort(0)
+			}
+			gs.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// StopGateway 停止网关，等待进行中的请求最多 5 秒
+func (gs *GatewayService) StopGateway() error {
+	gs.mu.Lock()
+	server := gs.server
+	gs.server = nil
+	gs.addr = ""
+	setGatewayListenPort(0)
+	gs.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+	}
+	return nil
+}
+
+// serveGateway 转发 /<provider>/<path> 到当前激活环境；激活环境属于网关轮换组时，
+// 按组的策略选择上游，连接失败、5xx、429 时在返回给 CLI 之前改用下一个上游
+func (gs *GatewayService) serveGateway(w http.ResponseWriter, r *http.Request) {
+	if !gatewayLocalRequest(r) {
+		writeGatewayError(w, http.StatusForbidden, "仅接受来自本机的请求")
+		return
+	}
+	gs.mu.Lock()
+	token := gs.token
+	gs.mu.Unlock()
+	if !gatewayTokenValid(r, token) {
+		writeGatewayError(w, http.StatusUnauthorized, "缺少或错误的网关令牌")
+		return
+	}
+	provider, rest := splitGatewayPath(r.URL.Path)
+	if provider == "" {
+		writeGatewayError(w, http.StatusNotFound, "路径应以 /claude、/codex、/gemini 或 /openclaw 开头")
+		return
+	}
+
+	config := gs.app.configSnapshot()
+	envName := currentEnvNameByProvider(config, provider)
+	if envName == "" {
+		writeGatewayError(w, http.StatusServiceUnavailable, fmt.Sprintf("%s 没有激活的环境", provider))
+		return
+	}
+	upstreams, err := gs.gatewayCandidates(config, provider, envName)
+	if err != nil {
+		writeGatewayError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	// 缓存请求体，重试时重新发送
+	body, err := io.ReadAll(io.LimitReader(r.Body, gatewayMaxBody+1))
+	r.Body.Close()
+	if err != nil {
+		writeGatewayError(w, http.StatusBadRequest, fmt.Sprintf("读取请求失败: %v", err))
+		return
+	}
+	if len(body) > gatewayMaxBody {
+		writeGatewayError(w, http.StatusRequestEntityTooLarge, "请求体过大")
+		return
+	}
+
+	model := gatewayRequestModel(body, rest)
+	for i, upstream := range upstreams {
+		req := r.Clone(r.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+
+		attempt := &gatewayAttempt{provider: provider, rest: rest, model: model, canRetry: i < len(upstreams)-1}
+		if isCountTokensPath(rest) && upstream.Protocol != "" && upstream.Protocol != protocolAnthropic {
+			// OpenAI 上游没有 token 计数接口，在本地估算
+			tokens, err := estimateInputTokens(body)
+			if err != nil {
+				writeGatewayError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]int{"input_tokens": tokens})
+			return
+		}
+		translation, err := newGatewayTranslation(clientProtocolForPath(rest), upstream.Protocol)
+		if err == nil && translation != nil {
+			var converted []byte
+			if converted, err = translation.request(body); err == nil {
+				req.Body = io.NopCloser(bytes.NewReader(converted))
+				req.ContentLength = int64(len(converted))
+				attempt.rest = translation.upstreamPath(upstream.BaseURL)
+				attempt.translation = translation
+			}
+		}
+		if err != nil {
+			if attempt.canRetry {
+				continue
+			}
+			writeGatewayError(w, http.StatusBadRequest, fmt.Sprintf("环境 '%s': %v", upstream.EnvName, err))
+			return
+		}
+		if attempt.translation == nil && clientProtocolForPath(rest) == protocolOpenAIChat {
+			if converted, injected := withChatStreamUsage(body); injected {
+				req.Body = io.NopCloser(bytes.NewReader(converted))
+				req.ContentLength = int64(len(converted))
+				attempt.hideUsageChunk = true
+			}
+		}
+		gs.reverseProxy(upstream, attempt).ServeHTTP(w, req)
+		if !attempt.failed || r.Context().Err() != nil {
+			return
+		}
+	}
+}
+
+// gatewayLocalRequest Host 与 Origin 必须是本机地址，防止网页通过 DNS 重绑定访问网关
+func gatewayLocalRequest(r *http.Request) bool {
+	isLocal := func(host string) bool {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(strings.ToLower(host), "[]")
+		return host == "127.0.0.1" || host == "localhost" || host == "::1"
+	}
+	if !isLocal(r.Host) {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || !isLocal(parsed.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// gatewayTokenValid CLI 以各自的方式携带 API Key：Authorization: Bearer、x-api-key、x-goog-api-key 或 ?key=
+func gatewayTokenValid(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	candidates := []string{
+		strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")),
+		r.Header.Get("X-Api-Key"),
+		r.Header.Get("X-Goog-Api-Key"),
+		r.URL.Query().Get("key"),
+	}
+	for _, candidate := range candidates {
+		if candidate != "" && subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// gatewayAttempt 一次向上游的转发
+type gatewayAttempt struct {
+	provider string
+	rest     string // 去掉 /<provider> 前缀后的路径
+	model    string // 请求中的模型名
+	canRetry bool   // 为 true 时失败不写回响应，由调用方改用下一个上游
+	failed   bool
+	// hideUsageChunk 网关为计量加上了 include_usage，返回时去掉客户端未要求的 usage chunk
+	hideUsageChunk bool
+	// translation 上游协议与 CLI 不同时的转换，nil 表示原样转发
+	translation *gatewayTranslation
+}
+
+// gatewayStatusError 上游返回可重试的状态码
+type gatewayStatusError struct {
+	status int
+}
+
+func (e *gatewayStatusError) Error() string {
+	return fmt.Sprintf("上游返回 HTTP %d", e.status)
+}
+
+// reverseProxy 构造到上游的反向代理；FlushInterval 为 -1 时逐块写回，SSE 不被缓冲。
+// 成功的响应在透传时记录 usage，需要转换协议时按 CLI 的协议改写响应
+func (gs *GatewayService) reverseProxy(upstream gatewayUpstream, attempt *gatewayAttempt) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Path = attempt.rest
+			pr.Out.URL.RawPath = ""
+			pr.SetURL(upstream.BaseURL)
+			// 去掉 CLI 带来的凭据，换成环境的密钥
+			for _, header := range []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key"} {
+				pr.Out.Header.Del(header)
+			}
+			if query := pr.Out.URL.Query(); query.Has("key") {
+				query.Del("key")
+				pr.Out.URL.RawQuery = query.Encode()
+			}
+			for key, value := range upstream.Headers {
+				pr.Out.Header.Set(key, value)
+			}
+			// 由 Transport 处理压缩，统计用量与协议转换时读到的是明文
+			pr.Out.Header.Del("Accept-Encoding")
+			if attempt.translation != nil {
+				pr.Out.Header.Set("Content-Type", "application/json")
+			}
+		},
+		Transport:     gs.transport(upstream.Proxy),
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			if !gatewayRetryableStatus(resp.StatusCode) {
+				gs.clearCooldown(upstream.EnvName)
+				if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+					meter := newUsageMeter(resp.Body, resp.Header.Get("Content-Type"), upstream, attempt.provider, attempt.model)
+					resp.Body = meter
+					if attempt.hideUsageChunk && meter.stream {
+						resp.Body = dropChatUsageChunk(meter)
+						resp.Header.Del("Content-Length")
+						resp.ContentLength = -1
+					}
+				}
+			} else {
+				gs.startCooldown(upstream, resp.Header.Get("Retry-After"))
+				if attempt.canRetry {
+					return &gatewayStatusError{status: resp.StatusCode}
+				}
+			}
+			if attempt.translation != nil {
+				return attempt.translation.translateResponse(resp)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var statusErr *gatewayStatusError
+			if !errors.As(err, &statusErr) && r.Context().Err() == nil {
+				gs.startCooldown(upstream, "")
+			}
+			if attempt.canRetry {
+				attempt.failed = true
+				return
+			}
+			writeGatewayError(w, http.StatusBadGateway, fmt.Sprintf("转发到 %s（%s）失败: %v", upstream.BaseURL.Host, upstream.EnvName, err))
+		},
+	}
+}
+
+// gatewayCandidates 返回本次请求依次尝试的上游；冷却中或余额不足的上游排在最后
+func (gs *GatewayService) gatewayCandidates(config Config, provider, active string) ([]gatewayUpstream, error) {
+	var group *RotationGroup
+	if gs.uptime != nil {
+		for _, g := range gs.uptime.rotationGroups() {
+			if g.Enabled && g.Gateway && g.Provider == provider && indexOfString(g.EnvNames, active) >= 0 {
+				g := g
+				group = &g
+				break
+			}
+		}
+	}
+
+	names := []string{active}
+	cooldown := gatewayDefaultCooldown
+	lowBalance := map[string]bool{}
+	if group != nil {
+		names = gs.orderGroupEnvs(*group, active)
+		cooldown = time.Duration(group.CooldownSeconds) * time.Second
+		lowBalance = lowBalanceEnvs(config)
+	}
+
+	var ready, cooling []gatewayUpstream
+	var firstErr error
+	now := time.Now()
+	gs.mu.Lock()
+	cooldowns := make(map[string]time.Time, len(gs.cooldowns))
+	for name, until := range gs.cooldowns {
+		cooldowns[name] = until
+	}
+	gs.mu.Unlock()
+
+	for _, name := range names {
+		env := findEnvInConfig(config, name)
+		if env == nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("环境 '%s' 不存在", name)
+			}
+			continue
+		}
+		upstream, err := gatewayUpstreamForEnv(*env, provider)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if upstream.Proxy == nil {
+			upstream.Proxy = config.Proxy
+		}
+		upstream.Cooldown = cooldown
+		if until, ok := cooldowns[name]; (ok && now.Before(until)) || lowBalance[name] {
+			cooling = append(cooling, upstream)
+		} else {
+			ready = append(ready, upstream)
+		}
+	}
+	upstreams := append(ready, cooling...)
+	if len(upstreams) == 0 {
+		return nil, firstErr
+	}
+	return upstreams, nil
+}
+
+// orderGroupEnvs 按轮换组策略排列环境：首个为本次的首选，其余按组内顺序作为备用
+func (gs *GatewayService) orderGroupEnvs(group RotationGroup, active string) []string {
+	names := group.EnvNames
+	first := indexOfString(names, active)
+
+	gs.mu.Lock()
+	switch group.Strategy {
+	case rotationStrategyRoundRobin:
+		first = int(gs.rrCounters[group.Name] % uint64(len(names)))
+		gs.rrCounters[group.Name]++
+	case rotationStrategyWeighted:
+		// 平滑加权轮询：每次各环境累加权重，选出累计值最大者后减去总权重
+		current := gs.weightState[group.Name]
+		if current == nil {
+			current = map[string]int{}
+			gs.weightState[group.Name] = current
+		}
+		total, best := 0, -1
+		for i, name := range names {
+			weight, ok := group.Weights[name]
+			if !ok {
+				weight = 1
+			}
+			if weight <= 0 {
+				continue
+			}
+			total += weight
+			current[name] += weight
+			if best < 0 || current[name] > current[names[best]] {
+				best = i
+			}
+		}
+		if best >= 0 {
+			current[names[best]] -= total
+			first = best
+		}
+	}
+	gs.mu.Unlock()
+
+	if first < 0 {
+		first = 0
+	}
+	ordered := make([]string, 0, len(names))
+	ordered = append(ordered, names[first:]...)
+	return append(ordered, names[:first]...)
+}
+
+// startCooldown 上游失败后暂停使用；Retry-After 更长时以其为准（最长 10 分钟）
+func (gs *GatewayService) startCooldown(upstream gatewayUpstream, retryAfter string) {
+	duration := upstream.Cooldown
+	if duration <= 0 {
+		duration = gatewayDefaultCooldown
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil {
+		if wait := time.Duration(seconds) * time.Second; wait > duration {
+			duration = min(wait, gatewayMaxCooldown)
+		}
+	}
+	gs.mu.Lock()
+	gs.cooldowns[upstream.EnvName] = time.Now().Add(duration)
+	gs.mu.Unlock()
+}
+
+func (gs *GatewayService) clearCooldown(envName string) {
+	gs.mu.Lock()
+	delete(gs.cooldowns, envName)
+	gs.mu.Unlock()
+}
+
+// gatewayRetryableStatus 可改用下一个上游重试的状态码
+func gatewayRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func findEnvInConfig(config Config, name string) *EnvConfig {
+	for i := range config.Environments {
+		if config.Environments[i].Name == name {
+			return &config.Environments[i]
+		}
+	}
+	return nil
+}
+
+// transport 按代理设置复用 Transport
+func (gs *GatewayService) transport(proxy *ProxyConfig) *http.Transport {
+	key := ""
+	if proxy != nil {
+		data, _ := json.Marshal(proxy)
+		key = string(data)
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if transport, ok := gs.transports[key]; ok {
+		return transport
+	}
+	transport := newProxyTransport(proxy)
+	gs.transports[key] = transport
+	return transport
+}
+
+// gatewayUpstreamForEnv 按 Provider 解析环境的上游地址与认证头；环境声明了上游协议时按该协议注入认证头
+func gatewayUpstreamForEnv(env EnvConfig, provider string) (gatewayUpstream, error) {
+	vars := env.Variables
+	get := func(key string) string { return strings.TrimSpace(vars[key]) }
+	protocol, err := normalizeUpstreamProtocol(env)
+	if err != nil {
+		return gatewayUpstream{}, fmt.Errorf("环境 '%s': %v", env.Name, err)
+	}
+	var root, credential, wire string
+	bearer := true
+
+	switch provider {
+	case "claude":
+		switch normalizeClaudeBackend(env.Backend) {
+		case claudeBackendBedrock, claudeBackendVertex:
+			return gatewayUpstream{}, fmt.Errorf("环境 '%s' 使用 Bedrock / Vertex 后端，网关不支持转发", env.Name)
+		}
+		if key := get("ANTHROPIC_API_KEY"); key != "" {
+			credential, bearer = key, false
+		} else {
+			credential = get("ANTHROPIC_AUTH_TOKEN")
+		}
+		root = firstNonEmpty(claudeBackendURL(env), anthropicDefaultBaseURL)
+		wire = protocolAnthropic
+	case "codex":
+		if normalizeCodexAuthMode(env.AuthMode) == codexAuthChatGPT {
+			return gatewayUpstream{}, fmt.Errorf("环境 '%s' 使用 ChatGPT 登录，网关不支持转发", env.Name)
+		}
+		credential = get("OPENAI_API_KEY")
+		root = firstNonEmpty(get("base_url"), openaiDefaultBaseURL)
+		wire = protocolOpenAIResponses
+	case "gemini":
+		if normalizeGeminiAuthMode(env.AuthMode) != geminiAuthAPIKey {
+			return gatewayUpstream{}, fmt.Errorf("环境 '%s' 不是 API Key 模式，网关不支持转发", env.Name)
+		}
+		credential = get("GEMINI_API_KEY")
+		root = firstNonEmpty(get("GOOGLE_GEMINI_BASE_URL"), geminiDefaultBaseURL)
+	case "openclaw":
+		credential = firstNonEmpty(get("OPENCLAW_GATEWAY_TOKEN"), get("OPENAI_API_KEY"))
+		root = get("OPENCLAW_GATEWAY_BASE_URL")
+		if root == "" {
+			return gatewayUpstream{}, fmt.Errorf("环境 '%s' 缺少 OPENCLAW_GATEWAY_BASE_URL", env.Name)
+		}
+		wire = protocolOpenAIChat
+	default:
+		return gatewayUpstream{}, fmt.Errorf("未知的 Provider: %s", provider)
+	}
+
+	headers := map[string]string{}
+	if protocol != "" {
+		// 其他 Provider 的密钥访问 Anthropic 时使用 x-api-key
+		bearer = bearer && provider == "claude"
+		wire = protocol
+		if protocol == protocolAnthropic {
+			headers["anthropic-version"] = anthropicAPIVersion
+		}
+	}
+	if credential != "" {
+		switch {
+		case provider == "gemini":
+			headers["x-goog-api-key"] = credential
+		case wire == protocolAnthropic && !bearer:
+			headers["x-api-key"] = credential
+		default:
+			headers["Authorization"] = "Bearer " + credential
+		}
+	}
+
+	base, err := url.Parse(strings.TrimRight(root, "/"))
+	if err != nil || base.Host == "" {
+		return gatewayUpstream{}, fmt.Errorf("环境 '%s' 的 Base URL 无效: %s", env.Name, root)
+	}
+	upstream := gatewayUpstream{EnvName: env.Name, BaseURL: base, Headers: headers, Proxy: env.Proxy, Protocol: protocol, PricingAliases: env.ModelMapping.pricingAliases()}
+	if credential != "" {
+		upstream.KeyFingerprint = keyFingerprint(credential)
+	}
+	return upstream, nil
+}
+
+// gatewayRoutedEnv 网关开启 RouteCLI 且正在运行时返回 Base URL 指向网关、API Key 换成网关令牌的环境副本，
+// 否则原样返回（网关未运行时 CLI 直连上游，不会指向无人监听的端口）
+func gatewayRoutedEnv(env *EnvConfig) *EnvConfig {
+	port := runningGatewayPort()
+	if port == 0 {
+		return env
+	}
+	settings, err := loadGatewaySettings()
+	if err != nil || !settings.Enabled || !settings.RouteCLI {
+		return env
+	}
+	if settings, err = ensureGatewayToken(); err != nil {
+		return env
+	}
+	provider := strings.ToLower(strings.TrimSpace(env.Provider))
+	if provider == "" {
+		provider = "claude"
+	}
+	if _, err := gatewayUpstreamForEnv(*env, provider); err != nil {
+		return env
+	}
+
+	routed := *env
+	routed.Variables = make(map[string]string, len(env.Variables)+1)
+	for key, value := range env.Variables {
+		routed.Variables[key] = value
+	}
+	endpoint := gatewayEndpoints(port)[provider]
+	switch provider {
+	case "claude":
+		if normalizeClaudeBackend(routed.Backend) == claudeBackendAPI {
+			routed.Backend = claudeBackendRelay
+		}
+		delete(routed.Variables, "API_BASE_URL")
+		delete(routed.Variables, "ANTHROPIC_API_KEY")
+		routed.Variables["ANTHROPIC_BASE_URL"] = endpoint
+		routed.Variables["ANTHROPIC_AUTH_TOKEN"] = settings.Token
+	case "codex":
+		routed.Variables["base_url"] = endpoint
+		routed.Variables["OPENAI_API_KEY"] = settings.Token
+	case "gemini":
+		routed.Variables["GOOGLE_GEMINI_BASE_URL"] = endpoint
+		routed.Variables["GEMINI_API_KEY"] = settings.Token
+	case "openclaw":
+		routed.Variables["OPENCLAW_GATEWAY_BASE_URL"] = endpoint
+		routed.Variables["OPENCLAW_GATEWAY_TOKEN"] = settings.Token
+	}
+	return &routed
+}
+
+func setGatewayListenPort(port int) {
+	gatewayListenMu.Lock()
+	gatewayListenPort = port
+	gatewayListenMu.Unlock()
+}
+
+func runningGatewayPort() int {
+	gatewayListenMu.Lock()
+	defer gatewayListenMu.Unlock()
+	return gatewayListenPort
+}
+
+// ensureGatewayToken 读取网关设置，尚无令牌时生成并保存
+func ensureGatewayToken() (GatewaySettings, error) {
+	settings, err := loadGatewaySettings()
+	if err != nil {
+		return settings, err
+	}
+	if settings.Token != "" {
+		return settings, nil
+	}
+	if settings.Token, err = newGatewayToken(); err != nil {
+		return settings, err
+	}
+	if err := saveGatewaySettings(settings); err != nil {
+		return settings, fmt.Errorf("保存网关设置失败: %v", err)
+	}
+	return settings, nil
+}
+
+func newGatewayToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成网关令牌失败: %v", err)
+	}
+	return "cms-gw-" + hex.EncodeToString(buf), nil
+}
+
+func gatewayEndpoints(port int) map[string]string {
+	endpoints := make(map[string]string, len(gatewayProviders))
+	for _, provider := range gatewayProviders {
+		endpoints[provider] = fmt.Sprintf("http://127.0.0.1:%d/%s", port, provider)
+	}
+	return endpoints
+}
+
+// splitGatewayPath 拆分 /<provider>/<rest>，provider 未知时返回空
+func splitGatewayPath(path string) (string, string) {
+	trimmed := strings.TrimPrefix(path, "/")
+	provider, rest, _ := strings.Cut(trimmed, "/")
+	if !containsString(gatewayProviders, provider) {
+		return "", ""
+	}
+	return provider, "/" + rest
+}
+
+// writeGatewayError 以 JSON 返回网关自身的错误，格式与常见 API 的 error 对象一致
+func writeGatewayError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"type": "gateway_error", "message": message},
+	})
+}
+
+func normalizeGatewaySettings(settings GatewaySettings) GatewaySettings {
+	if settings.Port <= 0 || settings.Port > 65535 {
+		settings.Port = gatewayDefaultPort
+	}
+	return settings
+}
+
+func loadGatewaySettings() (GatewaySettings, error) {
+	settings := GatewaySettings{Port: gatewayDefaultPort}
+	if _, err := loadJSONStore(gatewayStoreFile, &settings); err != nil {
+		return GatewaySettings{Port: gatewayDefaultPort}, err
+	}
+	return normalizeGatewaySettings(settings), nil
+}
+
+func saveGatewaySettings(settings GatewaySettings) error {
+	// 含访问令牌，仅当前用户可读
+	return saveJSONStore(gatewayStoreFile, settings, 0o600)
+}
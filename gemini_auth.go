@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Gemini CLI 的认证方式（对应 settings.json 的 security.auth.selectedType）
+const (
+	geminiAuthAPIKey   = "gemini-api-key"
+	geminiAuthOAuth    = "oauth-personal"
+	geminiAuthVertexAI = "vertex-ai"
+)
+
+// normalizeGeminiAuthMode 规范化 Gemini 环境的认证方式，未设置时为 gemini-api-key
+func normalizeGeminiAuthMode(mode string) string {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case geminiAuthOAuth, "oauth", "login-with-google":
+		return geminiAuthOAuth
+	case geminiAuthVertexAI, "vertex", "vertexai":
+		return geminiAuthVertexAI
+	default:
+		return geminiAuthAPIKey
+	}
+}
+
+// validateGeminiEnv 按认证方式检查必填变量
+func validateGeminiEnv(env *EnvConfig) error {
+	vars := env.Variables
+	has := func(key string) bool { return strings.TrimSpace(vars[key]) != "" }
+
+	switch normalizeGeminiAuthMode(env.AuthMode) {
+	case geminiAuthVertexAI:
+		var missing []string
+		for _, key := range []string{"GOOGLE_CLOUD_PROJECT", "GOOGLE_CLOUD_LOCATION"} {
+			if !has(key) {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("Vertex AI 模式缺少 %s", strings.Join(missing, ", "))
+		}
+		// 服务账号文件或 Vertex AI API Key（express mode）二选一
+		if !has("GOOGLE_APPLICATION_CREDENTIALS") && !has("GOOGLE_API_KEY") {
+			return fmt.Errorf("Vertex AI 模式需要 GOOGLE_APPLICATION_CREDENTIALS 或 GOOGLE_API_KEY")
+		}
+		if has("GOOGLE_APPLICATION_CREDENTIALS") {
+			home, _ := os.UserHomeDir()
+			credentials := expandAndNormalizePath(vars["GOOGLE_APPLICATION_CREDENTIALS"], home, home)
+			if !fileExistsFile(credentials) {
+				return fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS 文件不存在: %s", credentials)
+			}
+		}
+	case geminiAuthOAuth:
+		// 个人 Google 账号登录无需密钥；Workspace 账号可选 GOOGLE_CLOUD_PROJECT
+	default:
+		if !has("GEMINI_API_KEY") {
+			return fmt.Errorf("API Key 模式缺少 GEMINI_API_KEY")
+		}
+	}
+	return nil
+}
+
+// geminiEnvKeys 各认证方式写入 ~/.gemini/.env 的变量（按顺序，空值跳过）
+func geminiEnvKeys(mode string) []string {
+	switch mode {
+	case geminiAuthVertexAI:
+		return []string{"GOOGLE_CLOUD_PROJECT", "GOOGLE_CLOUD_LOCATION", "GOOGLE_APPLICATION_CREDENTIALS", "GOOGLE_API_KEY", "GEMINI_MODEL"}
+	case geminiAuthOAuth:
+		return []string{"GOOGLE_CLOUD_PROJECT", "GEMINI_MODEL"}
+	default:
+		return []string{"GOOGLE_GEMINI_BASE_URL", "GEMINI_API_KEY", "GEMINI_MODEL"}
+	}
+}
+
+// buildGeminiEnvContent 生成默认的 .env 内容
+func buildGeminiEnvContent(env *EnvConfig) string {
+	mode := normalizeGeminiAuthMode(env.AuthMode)
+
+	var lines []string
+	if mode == geminiAuthVertexAI {
+		lines = append(lines, "GOOGLE_GENAI_USE_VERTEXAI=true")
+	}
+	for _, key := range geminiEnvKeys(mode) {
+		value := strings.TrimSpace(env.Variables[key])
+		if value == "" {
+			continue
+		}
+		if key == "GOOGLE_APPLICATION_CREDENTIALS" {
+			home, _ := os.UserHomeDir()
+			value = expandAndNormalizePath(value, home, home)
+		}
+		lines = append(lines, key+"="+value)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// readGeminiAuthMode 读取 ~/.gemini/settings.json 中当前选择的认证方式
+func readGeminiAuthMode(geminiDir string) string {
+	data, err := os.ReadFile(filepath.Join(geminiDir, "settings.json"))
+	if err != nil {
+		return ""
+	}
+	var settings map[string]any
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return ""
+	}
+	if security, ok := settings["security"].(map[string]any); ok {
+		if auth, ok := security["auth"].(map[string]any); ok {
+			if selected, ok := auth["selectedType"].(string); ok && strings.TrimSpace(selected) != "" {
+				return strings.TrimSpace(selected)
+			}
+		}
+	}
+	// 旧版 Gemini CLI 使用顶层 selectedAuthType
+	if selected, ok := settings["selectedAuthType"].(string); ok {
+		return strings.TrimSpace(selected)
+	}
+	return ""
+}
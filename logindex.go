@@ -0,0 +1,789 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// usageIndexStoreDir 索引按文件路径分片保存，写盘时只重写有变化的分片
+	usageIndexStoreDir = "usage_index"
+	usageIndexShards   = 64
+	// usageIndexLegacyFile 未分片时的索引文件，加载时删除
+	usageIndexLegacyFile = "usage_index.json"
+	// usageIndexVersion 解析逻辑或格式变化时递增，旧索引整体重建
+	usageIndexVersion = 2
+	// usageIndexHeadLen 用文件开头的摘要判断文件是否被替换（而不是追加）
+	usageIndexHeadLen = 1024
+	// usageIndexRefreshGap 同一 Provider 两次扫描的最小间隔；仪表盘会同时调用多个统计接口
+	usageIndexRefreshGap = 3 * time.Second
+	usageIndexFlush      = 2 * time.Second
+	usageTimestampLayout = "2006-01-02 15:04:05"
+
+	logScanMaxWorkers    = 8
+	logScanProgressEvent = "logscan:progress"
+	logScanProgressGap   = 200 * time.Millisecond
+)
+
+// errLogScanCancelled 扫描被 CancelLogScan 取消；已解析的文件保留在索引中
+var errLogScanCancelled = errors.New("日志扫描已取消")
+
+// usageLogIndex 日志用量索引：记录每个文件已解析到的位置，只解析追加的内容。
+// 记录中只保存 token 数，成本在查询时按当前定价计算
+type usageLogIndex struct {
+	mu          sync.Mutex
+	loaded      bool
+	files       map[string]*indexedLogFile // 文件路径 -> 解析结果
+	refreshedAt map[string]time.Time       // provider -> 最近一次完成的扫描
+	dirty       map[int]bool               // 有变化、等待写盘的分片
+	timer       *time.Timer
+	scanMu      sync.Mutex         // 同一时间只进行一次扫描
+	cancel      context.CancelFunc // 取消正在进行的扫描
+	cancels     int                // CancelLogScan 的次数；等待中的请求据此放弃扫描
+}
+
+// LogScanProgress 日志扫描进度（logscan:progress 事件）
+type LogScanProgress struct {
+	Phase     string   `json:"phase"` // discover | parse | done | cancelled
+	Providers []string `json:"providers"`
+	Files     int      `json:"files"` // 发现的日志文件数
+	Total     int      `json:"total"` // 需要解析的文件数（新增或有变化）
+	Done      int      `json:"done"`
+}
+
+// indexedLogFile 单个日志文件的解析进度与记录
+type indexedLogFile struct {
+	Provider string `json:"provider"`
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`  // UnixNano
+	Offset   int64  `json:"offset"` // 已解析的完整行的末尾位置（JSONL）
+	HeadLen  int    `json:"head_len"`
+	HeadSum  string `json:"head_sum"`
+	Session  string `json:"session"`
+	Project  string `json:"project"`
+	// Codex 的 token_count 是累计值，续读时需要上次的累计值与模型
+	Codex   *codexParseState `json:"codex,omitempty"`
+	Records []indexedRecord  `json:"records"`
+}
+
+type codexParseState struct {
+	Model string           `json:"model"`
+	Last  *codexTokenUsage `json:"last,omitempty"`
+}
+
+// indexedRecord 一条用量记录（字段名缩写以减小索引文件）
+type indexedRecord struct {
+	Timestamp  string `json:"t"` // UTC，格式同 UsageRecord.Timestamp
+	Model      string `json:"m"`
+	Input      int    `json:"i,omitempty"`
+	Output     int    `json:"o,omitempty"`
+	CacheRead  int    `json:"cr,omitempty"`
+	CacheWrite int    `json:"cw,omitempty"`
+}
+
+type usageIndexFile struct {
+	Version int                        `json:"version"`
+	Files   map[string]*indexedLogFile `json:"files"`
+}
+
+// logFileInfo 扫描到的日志文件
+type logFileInfo struct {
+	path    string
+	size    int64
+	modTime int64
+	session string
+	project string
+}
+
+func newUsageLogIndex() *usageLogIndex {
+	return &usageLogIndex{refreshedAt: map[string]time.Time{}, dirty: map[int]bool{}}
+}
+
+// indexedRecords 返回索引中 Provider 最近 days 天的记录；不扫描日志，由调用方先 refreshLogIndex
+func (ls *LogService) indexedRecords(provider string, days int) []UsageRecord {
+	return ls.indexedRecordsSince(provider, time.Now().AddDate(0, 0, -days))
+}
+
+// indexedRecordsSince 返回索引中 Provider 在 since 之后的记录；先按时间过滤，只为范围内的记录计算成本
+func (ls *LogService) indexedRecordsSince(provider string, since time.Time) []UsageRecord {
+	idx := ls.index
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.loadLocked()
+
+	cutoff := since.UTC().Format(usageTimestampLayout)
+	records := []UsageRecord{}
+	for _, file := range idx.files {
+		if file.Provider != provider {
+			continue
+		}
+		for _, item := range file.Records {
+			if item.Timestamp < cutoff {
+				continue
+			}
+			cost, priced := ls.calculateCost(item.Model, item.Input, item.Output, item.CacheWrite, item.CacheRead)
+			records = append(records, UsageRecord{
+				Timestamp:        item.Timestamp,
+				Model:            item.Model,
+				InputTokens:      item.Input,
+				OutputTokens:     item.Output,
+				CacheReadTokens:  item.CacheRead,
+				CacheWriteTokens: item.CacheWrite,
+				TotalCost:        cost,
+				SessionID:        file.Session,
+				ProjectPath:      file.Project,
+				Unpriced:         !priced,
+			})
+		}
+	}
+	return records
+}
+
+// OnStartup 保存应用上下文，用于发送扫描进度事件
+func (ls *LogService) OnStartup(ctx context.Context) {
+	ls.ctx = ctx
+}
+
+// CancelLogScan 取消正在进行的日志扫描；已解析的文件保留在索引中，下次查询时继续
+func (ls *LogService) CancelLogScan() bool {
+	idx := ls.index
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.cancel == nil {
+		return false
+	}
+	idx.cancel()
+	idx.cancels++
+	return true
+}
+
+// logScanProviders 平台筛选对应的 Provider
+func logScanProviders(platform string) []string {
+	switch platform {
+	case "claude", "codex", "gemini":
+		return []string{platform}
+	default:
+		return []string{"claude", "codex", "gemini"}
+	}
+}
+
+// logParseJob 需要解析的文件
+type logParseJob struct {
+	provider string
+	info     logFileInfo
+	previous *indexedLogFile
+}
+
+type logParseResult struct {
+	path string
+	file *indexedLogFile
+	err  error
+}
+
+// refreshLogIndex 更新 Provider 的索引：各 Provider 并行扫描目录，
+// 新增或变化的文件交给有上限的 worker 池解析；可通过 CancelLogScan 取消
+func (ls *LogService) refreshLogIndex(providers ...string) error {
+	idx := ls.index
+	idx.mu.Lock()
+	cancels := idx.cancels
+	idx.mu.Unlock()
+
+	idx.scanMu.Lock()
+	defer idx.scanMu.Unlock()
+
+	idx.mu.Lock()
+	// 等待期间用户取消了扫描：不再重新开始
+	if idx.cancels != cancels {
+		idx.mu.Unlock()
+		return errLogScanCancelled
+	}
+	idx.loadLocked()
+	var due []string
+	for _, provider := range providers {
+		if time.Since(idx.refreshedAt[provider]) >= usageIndexRefreshGap {
+			due = append(due, provider)
+		}
+	}
+	if len(due) == 0 {
+		idx.mu.Unlock()
+		return nil
+	}
+	parent := ls.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	idx.cancel = cancel
+	idx.mu.Unlock()
+	defer func() {
+		cancel()
+		idx.mu.Lock()
+		idx.cancel = nil
+		idx.mu.Unlock()
+	}()
+
+	progress := LogScanProgress{Phase: "discover", Providers: due}
+	emit := ls.logScanEmitter()
+	emit(progress, true)
+
+	// 1. 并行发现文件
+	found := make([][]logFileInfo, len(due))
+	var wg sync.WaitGroup
+	for i, provider := range due {
+		wg.Add(1)
+		go func(i int, provider string) {
+			defer wg.Done()
+			found[i] = ls.discoverLogFiles(ctx, provider)
+		}(i, provider)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		progress.Phase = "cancelled"
+		emit(progress, true)
+		return errLogScanCancelled
+	}
+
+	// 2. 找出新增或变化的文件，移除已删除的文件
+	var jobs []logParseJob
+	seen := map[string]bool{}
+	changed := false
+	idx.mu.Lock()
+	for i, provider := range due {
+		for _, info := range found[i] {
+			seen[info.path] = true
+			file := idx.files[info.path]
+			if file != nil && file.Size == info.size && file.ModTime == info.modTime {
+				continue
+			}
+			jobs = append(jobs, logParseJob{provider: provider, info: info, previous: file})
+		}
+	}
+	for path, file := range idx.files {
+		if containsString(due, file.Provider) && !seen[path] {
+			idx.deleteFileLocked(path)
+			changed = true
+		}
+	}
+	idx.mu.Unlock()
+
+	// 3. worker 池解析
+	progress.Phase = "parse"
+	progress.Files = len(seen)
+	progress.Total = len(jobs)
+	emit(progress, true)
+
+	jobCh := make(chan logParseJob)
+	results := make(chan logParseResult)
+	workers := min(runtime.NumCPU(), logScanMaxWorkers, max(len(jobs), 1))
+	go func() {
+		defer close(jobCh)
+		for _, job := range jobs {
+			select {
+			case jobCh <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var workerWG sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			for job := range jobCh {
+				file, err := ls.parseLogFile(ctx, job.provider, job.info, job.previous)
+				results <- logParseResult{path: job.info.path, file: file, err: err}
+			}
+		}()
+	}
+	go func() {
+		workerWG.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		progress.Done++
+		if result.err == nil {
+			// 读取失败时保留旧结果，下次重试
+			idx.mu.Lock()
+			idx.setFileLocked(result.path, result.file)
+			idx.mu.Unlock()
+			changed = true
+		}
+		emit(progress, false)
+	}
+
+	idx.mu.Lock()
+	if changed && idx.timer == nil {
+		idx.timer = time.AfterFunc(usageIndexFlush, idx.flush)
+	}
+	if ctx.Err() == nil {
+		now := time.Now()
+		for _, provider := range due {
+			idx.refreshedAt[provider] = now
+		}
+	}
+	idx.mu.Unlock()
+
+	if ctx.Err() != nil {
+		progress.Phase = "cancelled"
+		emit(progress, true)
+		return errLogScanCancelled
+	}
+	progress.Phase = "done"
+	emit(progress, true)
+	return nil
+}
+
+// logScanEmitter 返回发送进度事件的函数；非强制的进度按间隔节流
+func (ls *LogService) logScanEmitter() func(progress LogScanProgress, force bool) {
+	var last time.Time
+	return func(progress LogScanProgress, force bool) {
+		if ls.ctx == nil {
+			return
+		}
+		if !force && time.Since(last) < logScanProgressGap {
+			return
+		}
+		last = time.Now()
+		wailsruntime.EventsEmit(ls.ctx, logScanProgressEvent, progress)
+	}
+}
+
+// discoverLogFiles 列出 Provider 的日志文件（只读取文件信息）
+func (ls *LogService) discoverLogFiles(ctx context.Context, provider string) []logFileInfo {
+	var mu sync.Mutex
+	var files []logFileInfo
+	add := func(path string, info os.FileInfo, session, project string) {
+		mu.Lock()
+		defer mu.Unlock()
+		files = append(files, logFileInfo{
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime().UnixNano(),
+			session: session,
+			project: project,
+		})
+	}
+
+	switch provider {
+	case "claude":
+		// ~/.claude/projects/<project>/<session>.jsonl
+		root := ls.getClaudeProjectsDir()
+		if root == "" {
+			return nil
+		}
+		walkLogDirs(ctx, root, func(path string, info os.FileInfo) {
+			if strings.HasSuffix(info.Name(), ".jsonl") {
+				add(path, info, strings.TrimSuffix(info.Name(), ".jsonl"), extractProjectPath(path))
+			}
+		})
+	case "codex":
+		// ~/.codex/sessions/YYYY/MM/DD/rollout-*.jsonl
+		root := ls.getCodexDir()
+		if root == "" {
+			return nil
+		}
+		walkLogDirs(ctx, filepath.Join(root, "sessions"), func(path string, info os.FileInfo) {
+			if strings.HasSuffix(info.Name(), ".jsonl") {
+				add(path, info, info.Name(), info.Name())
+			}
+		})
+	case "gemini":
+		// ~/.gemini/tmp/<project hash>/chats/<session>.json
+		root := ls.getGeminiTmpDir()
+		if root == "" {
+			return nil
+		}
+		projects, err := os.ReadDir(root)
+		if err != nil {
+			return nil
+		}
+		for _, project := range projects {
+			if ctx.Err() != nil {
+				break
+			}
+			if !project.IsDir() {
+				continue
+			}
+			chatsDir := filepath.Join(root, project.Name(), "chats")
+			sessions, err := os.ReadDir(chatsDir)
+			if err != nil {
+				continue
+			}
+			for _, session := range sessions {
+				if session.IsDir() || !strings.HasSuffix(session.Name(), ".json") {
+					continue
+				}
+				info, err := session.Info()
+				if err != nil {
+					continue
+				}
+				add(filepath.Join(chatsDir, session.Name()), info, "", project.Name())
+			}
+		}
+	}
+	return files
+}
+
+// walkLogDirs 遍历 root 下的文件；第一层子目录并行遍历（Claude 每个项目一个目录，Codex 按年份分目录）
+func walkLogDirs(ctx context.Context, root string, visit func(path string, info os.FileInfo)) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	sem := make(chan struct{}, logScanMaxWorkers)
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		if !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				visit(path, info)
+			}
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_ = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil || info.IsDir() {
+					return nil // 忽略错误，继续遍历
+				}
+				visit(path, info)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// parseLogFile 解析一个文件：JSONL 文件只是追加时从上次位置续读，否则从头解析
+func (ls *LogService) parseLogFile(ctx context.Context, provider string, info logFileInfo, previous *indexedLogFile) (*indexedLogFile, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if provider == "gemini" {
+		records, session, err := parseGeminiSessionFile(info.path)
+		if err != nil {
+			return nil, err
+		}
+		return &indexedLogFile{
+			Provider: provider,
+			Size:     info.size,
+			ModTime:  info.modTime,
+			Session:  session,
+			Project:  info.project,
+			Records:  records,
+		}, nil
+	}
+
+	f, err := os.Open(info.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file := &indexedLogFile{Provider: provider, Session: info.session, Project: info.project}
+	if previous != nil && previous.Offset <= info.size && headMatches(f, previous) {
+		copied := *previous
+		copied.Records = append([]indexedRecord(nil), previous.Records...)
+		if previous.Codex != nil {
+			state := *previous.Codex
+			copied.Codex = &state
+		}
+		file = &copied
+	} else {
+		head := make([]byte, min(info.size, usageIndexHeadLen))
+		if _, err := io.ReadFull(f, head); err != nil {
+			return nil, err
+		}
+		file.HeadLen = len(head)
+		file.HeadSum = headSum(head)
+	}
+	file.Size = info.size
+	file.ModTime = info.modTime
+	if provider == "codex" && file.Codex == nil {
+		file.Codex = &codexParseState{Model: "gpt-5-codex"} // 默认模型
+	}
+
+	if _, err := f.Seek(file.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReaderSize(f, 1024*1024)
+	for lines := 1; ; lines++ {
+		// 大文件解析中途也能响应取消
+		if lines%1000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			// 只消费完整的行；最后一行可能还在写入，下次再读
+			file.Offset += int64(len(line))
+			var record indexedRecord
+			var ok bool
+			if provider == "codex" {
+				record, ok = parseCodexLine(line, file.Codex)
+			} else {
+				record, ok = parseClaudeLine(line)
+			}
+			if ok {
+				file.Records = append(file.Records, record)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return file, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// headMatches 文件开头与上次解析时一致，说明只是追加了内容
+func headMatches(f *os.File, previous *indexedLogFile) bool {
+	head := make([]byte, previous.HeadLen)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return headSum(head) == previous.HeadSum
+}
+
+func headSum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseClaudeLine 解析 Claude Code 日志的一行（只处理带 message.usage 的条目）
+func parseClaudeLine(line []byte) (indexedRecord, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return indexedRecord{}, false
+	}
+	var entry claudeLogEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return indexedRecord{}, false
+	}
+	if entry.Message == nil || entry.Message.Usage == nil {
+		return indexedRecord{}, false
+	}
+	ts, _ := parseTimestamp(entry.Timestamp)
+	if ts.IsZero() {
+		return indexedRecord{}, false
+	}
+	usage := entry.Message.Usage
+	return indexedRecord{
+		Timestamp:  ts.UTC().Format(usageTimestampLayout),
+		Model:      entry.Message.Model,
+		Input:      usage.InputTokens,
+		Output:     usage.OutputTokens,
+		CacheRead:  usage.CacheRead,
+		CacheWrite: usage.CacheCreate,
+	}, true
+}
+
+// parseCodexLine 解析 Codex 会话日志的一行：turn_context 更新模型，
+// token_count 为累计值，与上次的累计值相减得到本次用量
+func parseCodexLine(line []byte, state *codexParseState) (indexedRecord, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return indexedRecord{}, false
+	}
+	var entry codexLogEntry
+	if err := json.Unmarshal(line, &entry); err != nil || entry.Payload == nil {
+		return indexedRecord{}, false
+	}
+
+	if entry.Type == "turn_context" && entry.Payload.Model != "" {
+		state.Model = entry.Payload.Model
+		return indexedRecord{}, false
+	}
+	if entry.Type != "event_msg" || entry.Payload.Type != "token_count" {
+		return indexedRecord{}, false
+	}
+	if entry.Payload.Info == nil || entry.Payload.Info.TotalTokenUsage == nil {
+		return indexedRecord{}, false
+	}
+
+	total := entry.Payload.Info.TotalTokenUsage
+	input, cached, output := total.InputTokens, total.CachedInputTokens, total.OutputTokens
+	if last := state.Last; last != nil {
+		input -= last.InputTokens
+		cached -= last.CachedInputTokens
+		output -= last.OutputTokens
+	}
+	state.Last = total
+
+	ts, _ := parseTimestamp(entry.Timestamp)
+	// 只记录有增量的条目
+	if ts.IsZero() || (input <= 0 && output <= 0) {
+		return indexedRecord{}, false
+	}
+	return indexedRecord{
+		Timestamp: ts.UTC().Format(usageTimestampLayout),
+		Model:     state.Model,
+		Input:     input,
+		Output:    output,
+		CacheRead: cached,
+	}, true
+}
+
+// parseGeminiSessionFile 解析 Gemini 会话文件（整个文件是一个 JSON，每次整体解析）
+func parseGeminiSessionFile(path string) ([]indexedRecord, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var session geminiSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, "", err
+	}
+
+	var records []indexedRecord
+	for _, msg := range session.Messages {
+		// 只处理有 token 统计的 gemini 响应
+		if msg.Type != "gemini" || msg.Tokens == nil {
+			continue
+		}
+		ts, _ := parseTimestamp(msg.Timestamp)
+		if ts.IsZero() {
+			continue
+		}
+		model := msg.Model
+		if model == "" {
+			model = "gemini-2.5-pro" // 默认模型
+		}
+		records = append(records, indexedRecord{
+			Timestamp: ts.UTC().Format(usageTimestampLayout),
+			Model:     model,
+			Input:     msg.Tokens.Input,
+			Output:    msg.Tokens.Output,
+			CacheRead: msg.Tokens.Cached, // Gemini 没有 cache create 概念
+		})
+	}
+	return records, session.SessionID, nil
+}
+
+// usageIndexShard 文件路径所在的分片
+func usageIndexShard(path string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return int(h.Sum32() % usageIndexShards)
+}
+
+func (idx *usageLogIndex) setFileLocked(path string, file *indexedLogFile) {
+	idx.files[path] = file
+	idx.dirty[usageIndexShard(path)] = true
+}
+
+func (idx *usageLogIndex) deleteFileLocked(path string) {
+	delete(idx.files, path)
+	idx.dirty[usageIndexShard(path)] = true
+}
+
+func (idx *usageLogIndex) loadLocked() {
+	if idx.loaded {
+		return
+	}
+	idx.loaded = true
+	idx.files = map[string]*indexedLogFile{}
+
+	dir, err := usageIndexDir()
+	if err != nil {
+		return
+	}
+	if legacy, err := storePath(usageIndexLegacyFile); err == nil {
+		_ = os.Remove(legacy)
+	}
+	for shard := 0; shard < usageIndexShards; shard++ {
+		data, err := os.ReadFile(usageIndexShardPath(dir, shard))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		// 分片损坏或版本不符时丢弃，其中的文件下次扫描时重新解析
+		var stored usageIndexFile
+		if err := json.Unmarshal(data, &stored); err != nil || stored.Version != usageIndexVersion {
+			continue
+		}
+		for path, file := range stored.Files {
+			if file != nil && usageIndexShard(path) == shard {
+				idx.files[path] = file
+			}
+		}
+	}
+}
+
+// flush 将有变化的分片写盘（先写临时文件再替换，避免写到一半时被读取）
+func (idx *usageLogIndex) flush() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.timer = nil
+	if !idx.loaded || len(idx.dirty) == 0 {
+		return
+	}
+	dir, err := usageIndexDir()
+	if err != nil {
+		return
+	}
+	shards := make(map[int]map[string]*indexedLogFile, len(idx.dirty))
+	for shard := range idx.dirty {
+		shards[shard] = map[string]*indexedLogFile{}
+	}
+	for path, file := range idx.files {
+		if files, ok := shards[usageIndexShard(path)]; ok {
+			files[path] = file
+		}
+	}
+	// 写入失败的分片保持待写状态，下次 flush 时重试
+	for shard, files := range shards {
+		data, err := json.Marshal(usageIndexFile{Version: usageIndexVersion, Files: files})
+		if err != nil {
+			continue
+		}
+		path := usageIndexShardPath(dir, shard)
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o644); err != nil {
+			continue
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			continue
+		}
+		delete(idx.dirty, shard)
+	}
+}
+
+func usageIndexDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, mcpStoreDir, usageIndexStoreDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func usageIndexShardPath(dir string, shard int) string {
+	return filepath.Join(dir, fmt.Sprintf("shard-%02d.json", shard))
+}
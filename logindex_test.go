@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUsageIndexFlushesOnlyChangedShards(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	idx := newUsageLogIndex()
+	idx.loadLocked()
+	paths := []string{"/logs/a.jsonl", "/logs/b.jsonl", "/logs/c.jsonl"}
+	for _, path := range paths {
+		idx.setFileLocked(path, &indexedLogFile{Provider: "claude", Records: []indexedRecord{{Timestamp: "2026-01-01 00:00:00", Model: "m"}}})
+	}
+	idx.flush()
+	if len(idx.dirty) != 0 {
+		t.Fatalf("dirty shards left after flush: %v", idx.dirty)
+	}
+
+	dir, err := usageIndexDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只修改一个文件时只重写它所在的分片
+	untouched := usageIndexShardPath(dir, usageIndexShard(paths[1]))
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(untouched, past, past); err != nil {
+		t.Fatal(err)
+	}
+	idx.setFileLocked(paths[0], &indexedLogFile{Provider: "claude"})
+	idx.flush()
+	if info, err := os.Stat(untouched); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("unchanged shard was rewritten: %v", err)
+	}
+
+	reloaded := newUsageLogIndex()
+	reloaded.loadLocked()
+	if len(reloaded.files) != len(paths) || len(reloaded.files[paths[0]].Records) != 0 || len(reloaded.files[paths[1]].Records) != 1 {
+		t.Errorf("reloaded index mismatch: %+v", reloaded.files)
+	}
+}
+
+func TestIndexedRecordsSinceFiltersByTime(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	ls := &LogService{index: newUsageLogIndex()}
+	ls.index.loadLocked()
+	now := time.Now().UTC()
+	ls.index.setFileLocked("/logs/a.jsonl", &indexedLogFile{Provider: "claude", Session: "s", Records: []indexedRecord{
+		{Timestamp: now.Add(-48 * time.Hour).Format(usageTimestampLayout), Model: "old"},
+		{Timestamp: now.Add(-time.Hour).Format(usageTimestampLayout), Model: "new", Input: 10},
+	}})
+	ls.index.setFileLocked("/logs/b.json", &indexedLogFile{Provider: "gemini", Records: []indexedRecord{
+		{Timestamp: now.Format(usageTimestampLayout), Model: "gemini"},
+	}})
+
+	records := ls.indexedRecordsSince("claude", now.Add(-24*time.Hour))
+	if len(records) != 1 || records[0].Model != "new" || records[0].InputTokens != 10 || records[0].SessionID != "s" {
+		t.Errorf("records = %+v, want only the recent claude record", records)
+	}
+}
@@ -1,22 +1,30 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 // LogService 日志统计服务
-type LogService struct{}
+type LogService struct {
+	configPath     string
+	aliasMu        sync.Mutex
+	aliases        map[string]string // 中转模型名 -> Anthropic 模型名（来自 Claude 环境的模型映射）
+	aliasModTime   time.Time
+	aliasCheckedAt time.Time
+	index          *usageLogIndex // 日志用量的增量索引
+	ctx            context.Context
+}
 
 // NewLogService 创建日志服务
 func NewLogService() *LogService {
-	return &LogService{}
+	return &LogService{configPath: resolveMainConfigPath(), index: newUsageLogIndex()}
 }
 
 // GetLogDirectory 获取日志目录路径 (供前端调试)
@@ -35,6 +43,7 @@ type UsageRecord struct {
 	TotalCost       float64 `json:"total_cost"`
 	SessionID       string  `json:"session_id"`
 	ProjectPath     string  `json:"project_path"`
+	Unpriced        bool    `json:"unpriced,omitempty"` // 模型不在定价表中，成本未计入
 }
 
 // ModelStats 模型统计
@@ -42,6 +51,7 @@ type ModelStats struct {
 	Requests int     `json:"requests"`
 	Tokens   int64   `json:"tokens"`
 	Cost     float64 `json:"cost"`
+	Unpriced bool    `json:"unpriced,omitempty"`
 }
 
 // UsageStats 使用统计
@@ -54,6 +64,8 @@ type UsageStats struct {
 	TotalCost         float64               `json:"total_cost"`
 	ByModel           map[string]ModelStats `json:"by_model"`
 	Series            []HourlyStat          `json:"series"`
+	UnpricedRequests  int                   `json:"unpriced_requests,omitempty"`
+	UnpricedModels    []string              `json:"unpriced_models,omitempty"` // 未计价的模型，需在定价文件中补充
 }
 
 // HourlyStat 小时统计
@@ -83,6 +95,9 @@ type EnvUsageSummary struct {
 	CacheWriteTokens int64  `json:"cache_write_tokens"`
 	TotalCost       float64 `json:"total_cost"`
 	LastTimestamp   string  `json:"last_timestamp,omitempty"`
+	UnpricedRequests int    `json:"unpriced_requests,omitempty"`
+	// Metered 经本地网关转发的精确用量（来自响应的 usage，无需按时间线归因）
+	Metered *MeteredTotals `json:"metered,omitempty"`
 }
 
 // Claude Code 日志条目结构
@@ -104,61 +119,15 @@ type claudeUsage struct {
 	CacheCreate  int `json:"cache_creation_input_tokens"`
 }
 
-// Model pricing (USD per 1M tokens)
-// Cache pricing: CacheCreate = 1.25 × Input, CacheRead = 0.1 × Input
-// Reference: https://docs.anthropic.com/en/docs/about-claude/models
-var modelPricing = map[string]struct {
-	Input       float64
-	Output      float64
-	CacheCreate float64
-	CacheRead   float64
-}{
-	// Claude Opus 4.5 ($5/$25)
-	"claude-opus-4-5-20251101":     {Input: 5.0, Output: 25.0, CacheCreate: 6.25, CacheRead: 0.50},
-	// Claude Opus 4 / 4.1 ($15/$75)
-	"claude-opus-4-20250514":       {Input: 15.0, Output: 75.0, CacheCreate: 18.75, CacheRead: 1.50},
-	"claude-opus-4-1-20250805":     {Input: 15.0, Output: 75.0, CacheCreate: 18.75, CacheRead: 1.50},
-	// Claude 3 Opus ($15/$75)
-	"claude-3-opus-20240229":       {Input: 15.0, Output: 75.0, CacheCreate: 18.75, CacheRead: 1.50},
-	// Claude Sonnet 4 / 4.5 / 3.7 / 3.5 ($3/$15)
-	"claude-sonnet-4-20250514":     {Input: 3.0, Output: 15.0, CacheCreate: 3.75, CacheRead: 0.30},
-	"claude-sonnet-4-5-20250929":   {Input: 3.0, Output: 15.0, CacheCreate: 3.75, CacheRead: 0.30},
-	"claude-3-7-sonnet-20250219":   {Input: 3.0, Output: 15.0, CacheCreate: 3.75, CacheRead: 0.30},
-	"claude-3-5-sonnet-20241022":   {Input: 3.0, Output: 15.0, CacheCreate: 3.75, CacheRead: 0.30},
-	"claude-3-5-sonnet-20240620":   {Input: 3.0, Output: 15.0, CacheCreate: 3.75, CacheRead: 0.30},
-	// Claude 3.5 Haiku ($0.80/$4)
-	"claude-3-5-haiku-20241022":    {Input: 0.80, Output: 4.0, CacheCreate: 1.0, CacheRead: 0.08},
-	// Claude 3 Haiku ($0.25/$1.25)
-	"claude-3-haiku-20240307":      {Input: 0.25, Output: 1.25, CacheCreate: 0.3125, CacheRead: 0.025},
-	// GPT-4 series
-	"gpt-4":                        {Input: 30.0, Output: 60.0, CacheCreate: 0, CacheRead: 0},
-	"gpt-4-turbo":                  {Input: 10.0, Output: 30.0, CacheCreate: 0, CacheRead: 0},
-	"gpt-4o":                       {Input: 2.5, Output: 10.0, CacheCreate: 0, CacheRead: 0},
-	"gpt-4o-mini":                  {Input: 0.15, Output: 0.6, CacheCreate: 0, CacheRead: 0},
-	// Gemini series (https://ai.google.dev/gemini-api/docs/pricing)
-	"gemini-2.5-pro":               {Input: 1.25, Output: 10.0, CacheCreate: 0.3125, CacheRead: 0},
-	"gemini-2.5-flash":             {Input: 0.15, Output: 0.60, CacheCreate: 0.0375, CacheRead: 0},
-	"gemini-2.0-flash":             {Input: 0.10, Output: 0.40, CacheCreate: 0.025, CacheRead: 0},
-	"gemini-1.5-pro":               {Input: 1.25, Output: 5.0, CacheCreate: 0.3125, CacheRead: 0},
-	"gemini-1.5-flash":             {Input: 0.075, Output: 0.3, CacheCreate: 0.01875, CacheRead: 0},
-	"gemini-3-pro":                 {Input: 2.5, Output: 15.0, CacheCreate: 0.625, CacheRead: 0},
-	// OpenAI Codex series (https://developers.openai.com/codex/pricing/)
-	"gpt-5.2-codex":                {Input: 1.75, Output: 14.0, CacheCreate: 0, CacheRead: 0.175},
-	"gpt-5.2":                      {Input: 1.75, Output: 14.0, CacheCreate: 0, CacheRead: 0.175},
-	"gpt-5.1-codex-mini":           {Input: 0.30, Output: 1.20, CacheCreate: 0, CacheRead: 0.03},
-	"gpt-5.1-codex-max":            {Input: 1.50, Output: 12.0, CacheCreate: 0, CacheRead: 0.15},
-	"gpt-5.1-codex":                {Input: 1.50, Output: 12.0, CacheCreate: 0, CacheRead: 0.15},
-	"gpt-5.1":                      {Input: 1.50, Output: 12.0, CacheCreate: 0, CacheRead: 0.15},
-	"gpt-5-codex":                  {Input: 1.25, Output: 10.0, CacheCreate: 0, CacheRead: 0.125},
-	"gpt-5":                        {Input: 1.25, Output: 10.0, CacheCreate: 0, CacheRead: 0.125},
-	"codex-1":                      {Input: 1.25, Output: 10.0, CacheCreate: 0, CacheRead: 0.125},
-}
-
 // GetUsageStats 获取使用统计 (最近N天, 按平台筛选, days=0 表示全部时间)
 func (ls *LogService) GetUsageStats(days int, platform string) (UsageStats, error) {
 	if days <= 0 {
 		days = 3650 // 约10年，相当于全部
 	}
+	// 各 Provider 的日志并行更新到索引；扫描被取消时不再继续读取
+	if err := ls.refreshLogIndex(logScanProviders(platform)...); err != nil {
+		return UsageStats{}, err
+	}
 
 	stats := UsageStats{
 		ByModel: make(map[string]ModelStats),
@@ -224,6 +193,13 @@ func (ls *LogService) GetUsageStats(days int, platform string) (UsageStats, erro
 		modelStat.Requests++
 		modelStat.Tokens += int64(record.InputTokens + record.OutputTokens)
 		modelStat.Cost += record.TotalCost
+		if record.Unpriced {
+			modelStat.Unpriced = true
+			stats.UnpricedRequests++
+			if !containsString(stats.UnpricedModels, record.Model) {
+				stats.UnpricedModels = append(stats.UnpricedModels, record.Model)
+			}
+		}
 		stats.ByModel[record.Model] = modelStat
 
 		// 按小时聚合
@@ -245,6 +221,7 @@ func (ls *LogService) GetUsageStats(days int, platform string) (UsageStats, erro
 		hours = append(hours, h)
 	}
 	sort.Strings(hours)
+	sort.Strings(stats.UnpricedModels)
 
 	for _, h := range hours {
 		stats.Series = append(stats.Series, *hourlyMap[h])
@@ -258,6 +235,10 @@ func (ls *LogService) GetHeatmapData(days int, platform string) ([]HeatmapData,
 	if days <= 0 {
 		days = 3650 // 约10年，相当于全部
 	}
+	// 各 Provider 的日志并行更新到索引；扫描被取消时不再继续读取
+	if err := ls.refreshLogIndex(logScanProviders(platform)...); err != nil {
+		return nil, err
+	}
 
 	var records []UsageRecord
 
@@ -337,6 +318,9 @@ func (ls *LogService) GetRecentLogs(limit int, platform string) ([]UsageRecord,
 	if limit <= 0 {
 		limit = 50
 	}
+	if err := ls.refreshLogIndex(logScanProviders(platform)...); err != nil {
+		return nil, err
+	}
 
 	var records []UsageRecord
 
@@ -397,19 +381,26 @@ func (ls *LogService) GetRecentLogs(limit int, platform string) ([]UsageRecord,
 //
 // 说明：日志本身不包含“使用的是哪个配置”，这里通过本软件记录的“切换/应用配置时间线”来近似归因。
 // 如果你平时不是通过本软件切换配置，或历史记录不完整，结果会偏差。
+// 经本地网关转发的请求另有精确计量，见 Metered 字段。
 func (ls *LogService) GetEnvUsageSummary(days int) (map[string]EnvUsageSummary, error) {
 	if days <= 0 {
 		days = 7
 	}
+	return ls.envUsageSince(time.Now().AddDate(0, 0, -days))
+}
 
+// envUsageSince 按配置聚合 cutoff 之后的用量
+func (ls *LogService) envUsageSince(cutoff time.Time) (map[string]EnvUsageSummary, error) {
 	byEnv := map[string]EnvUsageSummary{}
+	if err := ls.refreshLogIndex("claude", "codex", "gemini"); err != nil {
+		return nil, err
+	}
 
 	activations, err := LoadEnvActivations()
 	if err != nil {
 		activations = map[string][]EnvActivationEvent{}
 	}
 
-	cutoff := time.Now().AddDate(0, 0, -days)
 	cutoffUnix := cutoff.Unix()
 
 	providers := []string{"claude", "codex", "gemini"}
@@ -447,6 +438,9 @@ func (ls *LogService) GetEnvUsageSummary(days int) (map[string]EnvUsageSummary,
 			item.CacheReadTokens += int64(record.CacheReadTokens)
 			item.CacheWriteTokens += int64(record.CacheWriteTokens)
 			item.TotalCost += record.TotalCost
+			if record.Unpriced {
+				item.UnpricedRequests++
+			}
 			if item.LastTimestamp == "" || record.Timestamp > item.LastTimestamp {
 				item.LastTimestamp = record.Timestamp
 			}
@@ -454,73 +448,18 @@ func (ls *LogService) GetEnvUsageSummary(days int) (map[string]EnvUsageSummary,
 		}
 	}
 
-	claudeRecords, _ := ls.readClaudeLogs(days)
-	accumulate("claude", claudeRecords)
-
-	codexRecords, _ := ls.readCodexLogs(days)
-	accumulate("codex", codexRecords)
+	for _, provider := range providers {
+		accumulate(provider, ls.indexedRecordsSince(provider, cutoff))
+	}
 
-	geminiRecords, _ := ls.readGeminiLogs(days)
-	accumulate("gemini", geminiRecords)
+	ls.addMeteredUsage(byEnv, cutoff)
 
 	return byEnv, nil
 }
 
-// readClaudeLogs 读取 Claude Code 日志文件
+// readClaudeLogs 读取 Claude Code 日志（来自增量索引）
 func (ls *LogService) readClaudeLogs(days int) ([]UsageRecord, error) {
-	projectsDir := ls.getClaudeProjectsDir()
-	if projectsDir == "" {
-		return []UsageRecord{}, nil
-	}
-
-	// 检查目录是否存在
-	if _, err := os.Stat(projectsDir); os.IsNotExist(err) {
-		return []UsageRecord{}, nil
-	}
-
-	// 计算时间范围
-	cutoff := time.Now().AddDate(0, 0, -days)
-
-	var records []UsageRecord
-
-	// 遍历 projects 目录
-	err := filepath.Walk(projectsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // 忽略错误，继续遍历
-		}
-
-		// 只处理 .jsonl 文件
-		if info.IsDir() || !strings.HasSuffix(info.Name(), ".jsonl") {
-			return nil
-		}
-
-		// 检查文件修改时间
-		if info.ModTime().Before(cutoff) {
-			return nil
-		}
-
-		// 从路径提取项目信息
-		projectPath := extractProjectPath(path)
-
-		// 读取文件
-		fileRecords, err := ls.parseJSONLFile(path, projectPath, cutoff)
-		if err != nil {
-			return nil // 忽略解析错误
-		}
-
-		records = append(records, fileRecords...)
-		return nil
-	})
-
-	if err != nil {
-		return []UsageRecord{}, nil
-	}
-
-	if records == nil {
-		records = []UsageRecord{}
-	}
-
-	return records, nil
+	return ls.indexedRecords("claude", days), nil
 }
 
 func activeEnvAt(events []EnvActivationEvent, atUnix int64) string {
@@ -562,130 +501,9 @@ type geminiTokens struct {
 	Total    int `json:"total"`
 }
 
-// readGeminiLogs 读取 Gemini CLI 日志文件
+// readGeminiLogs 读取 Gemini CLI 日志（来自增量索引）
 func (ls *LogService) readGeminiLogs(days int) ([]UsageRecord, error) {
-	geminiDir := ls.getGeminiTmpDir()
-	if geminiDir == "" {
-		return []UsageRecord{}, nil
-	}
-
-	// 检查目录是否存在
-	if _, err := os.Stat(geminiDir); os.IsNotExist(err) {
-		return []UsageRecord{}, nil
-	}
-
-	// 计算时间范围
-	cutoff := time.Now().AddDate(0, 0, -days)
-
-	var records []UsageRecord
-
-	// 遍历 tmp 目录下的所有项目 hash 目录
-	entries, err := os.ReadDir(geminiDir)
-	if err != nil {
-		return []UsageRecord{}, nil
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		// 检查 chats 子目录
-		chatsDir := filepath.Join(geminiDir, entry.Name(), "chats")
-		if _, err := os.Stat(chatsDir); os.IsNotExist(err) {
-			continue
-		}
-
-		// 遍历 session 文件
-		sessionFiles, err := os.ReadDir(chatsDir)
-		if err != nil {
-			continue
-		}
-
-		for _, sessionFile := range sessionFiles {
-			if sessionFile.IsDir() || !strings.HasSuffix(sessionFile.Name(), ".json") {
-				continue
-			}
-
-			sessionPath := filepath.Join(chatsDir, sessionFile.Name())
-			info, err := sessionFile.Info()
-			if err != nil || info.ModTime().Before(cutoff) {
-				continue
-			}
-
-			// 解析会话文件
-			sessionRecords, err := ls.parseGeminiSession(sessionPath, entry.Name(), cutoff)
-			if err != nil {
-				continue
-			}
-
-			records = append(records, sessionRecords...)
-		}
-	}
-
-	if records == nil {
-		records = []UsageRecord{}
-	}
-
-	return records, nil
-}
-
-// parseGeminiSession 解析 Gemini 会话文件
-func (ls *LogService) parseGeminiSession(path string, projectHash string, cutoff time.Time) ([]UsageRecord, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	var session geminiSession
-	if err := json.Unmarshal(data, &session); err != nil {
-		return nil, err
-	}
-
-	var records []UsageRecord
-
-	for _, msg := range session.Messages {
-		// 只处理有 token 统计的 gemini 响应
-		if msg.Type != "gemini" || msg.Tokens == nil {
-			continue
-		}
-
-		// 解析时间戳
-		ts, err := parseTimestamp(msg.Timestamp)
-		if err != nil || ts.Before(cutoff) {
-			continue
-		}
-
-		model := msg.Model
-		if model == "" {
-			model = "gemini-2.5-pro" // 默认模型
-		}
-
-		// 计算成本
-		cost := ls.calculateCost(
-			model,
-			msg.Tokens.Input,
-			msg.Tokens.Output,
-			0, // Gemini 没有 cache create 概念
-			msg.Tokens.Cached,
-		)
-
-		record := UsageRecord{
-			Timestamp:        ts.Format("2006-01-02 15:04:05"),
-			Model:            model,
-			InputTokens:      msg.Tokens.Input,
-			OutputTokens:     msg.Tokens.Output,
-			CacheReadTokens:  msg.Tokens.Cached,
-			CacheWriteTokens: 0,
-			TotalCost:        cost,
-			SessionID:        session.SessionID,
-			ProjectPath:      projectHash,
-		}
-
-		records = append(records, record)
-	}
-
-	return records, nil
+	return ls.indexedRecords("gemini", days), nil
 }
 
 // getGeminiTmpDir 获取 Gemini CLI 临时目录路径
@@ -756,98 +574,34 @@ func (ls *LogService) getClaudeProjectsDir() string {
 	return primaryPath
 }
 
-// parseJSONLFile 解析单个 JSONL 文件
-func (ls *LogService) parseJSONLFile(path string, projectPath string, cutoff time.Time) ([]UsageRecord, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var records []UsageRecord
-	scanner := bufio.NewScanner(file)
-	// 增大缓冲区以处理长行
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
-
-	sessionID := filepath.Base(path)
-	sessionID = strings.TrimSuffix(sessionID, ".jsonl")
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		var entry claudeLogEntry
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
-			continue
-		}
-
-		// 只处理包含 message.usage 信息的条目
-		if entry.Message == nil || entry.Message.Usage == nil {
-			continue
-		}
-
-		// 解析时间戳
-		ts, err := parseTimestamp(entry.Timestamp)
-		if err != nil || ts.Before(cutoff) {
-			continue
-		}
-
-		// 计算成本 (包含缓存成本)
-		cost := ls.calculateCost(
-			entry.Message.Model,
-			entry.Message.Usage.InputTokens,
-			entry.Message.Usage.OutputTokens,
-			entry.Message.Usage.CacheCreate,
-			entry.Message.Usage.CacheRead,
-		)
-
-		record := UsageRecord{
-			Timestamp:        ts.Format("2006-01-02 15:04:05"),
-			Model:            entry.Message.Model,
-			InputTokens:      entry.Message.Usage.InputTokens,
-			OutputTokens:     entry.Message.Usage.OutputTokens,
-			CacheReadTokens:  entry.Message.Usage.CacheRead,
-			CacheWriteTokens: entry.Message.Usage.CacheCreate,
-			TotalCost:        cost,
-			SessionID:        sessionID,
-			ProjectPath:      projectPath,
-		}
-
-		records = append(records, record)
+// calculateCost 计算成本 (包含缓存成本)；模型不在定价表中时返回 false，成本记为 0
+func (ls *LogService) calculateCost(model string, inputTokens, outputTokens, cacheCreateTokens, cacheReadTokens int) (float64, bool) {
+	pricing, ok := currentPricing().lookup(ls.resolvePricingModel(model))
+	if !ok {
+		return 0, false
 	}
-
-	return records, nil
+	longContext := pricing.longContext(inputTokens, cacheCreateTokens, cacheReadTokens)
+	return pricing.cost(inputTokens, outputTokens, cacheCreateTokens, cacheReadTokens, longContext), true
 }
 
-// calculateCost 计算成本 (包含缓存成本)
-func (ls *LogService) calculateCost(model string, inputTokens, outputTokens, cacheCreateTokens, cacheReadTokens int) float64 {
-	pricing, ok := modelPricing[model]
-	if !ok {
-		// 尝试模糊匹配
-		for name, p := range modelPricing {
-			if strings.Contains(strings.ToLower(model), strings.ToLower(name)) {
-				pricing = p
-				ok = true
-				break
-			}
+// resolvePricingModel 按 Claude 环境的模型映射，把中转模型名换成其对应的 Anthropic 模型名
+func (ls *LogService) resolvePricingModel(model string) string {
+	ls.aliasMu.Lock()
+	defer ls.aliasMu.Unlock()
+
+	// 每条记录都会调用，主配置最多每秒检查一次是否变化
+	if now := time.Now(); now.Sub(ls.aliasCheckedAt) > time.Second {
+		ls.aliasCheckedAt = now
+		if info, err := os.Stat(ls.configPath); err == nil && !info.ModTime().Equal(ls.aliasModTime) {
+			ls.aliases = loadModelPricingAliases(ls.configPath)
+			ls.aliasModTime = info.ModTime()
 		}
 	}
 
-	if !ok {
-		// 默认使用 sonnet 定价
-		pricing = modelPricing["claude-sonnet-4-20250514"]
+	if alias, ok := ls.aliases[strings.ToLower(strings.TrimSpace(model))]; ok {
+		return alias
 	}
-
-	// 计算成本 (价格是每百万 token)
-	inputCost := float64(inputTokens) * pricing.Input / 1_000_000
-	outputCost := float64(outputTokens) * pricing.Output / 1_000_000
-	cacheCreateCost := float64(cacheCreateTokens) * pricing.CacheCreate / 1_000_000
-	cacheReadCost := float64(cacheReadTokens) * pricing.CacheRead / 1_000_000
-
-	return inputCost + outputCost + cacheCreateCost + cacheReadCost
+	return model
 }
 
 // extractProjectPath 从文件路径提取项目路径
@@ -907,160 +661,9 @@ type codexTokenUsage struct {
 	TotalTokens           int `json:"total_tokens"`
 }
 
-// readCodexLogs 读取 Codex CLI 日志文件
+// readCodexLogs 读取 Codex CLI 日志（来自增量索引）
 func (ls *LogService) readCodexLogs(days int) ([]UsageRecord, error) {
-	codexDir := ls.getCodexDir()
-	if codexDir == "" {
-		return []UsageRecord{}, nil
-	}
-
-	// Codex 日志路径: ~/.codex/sessions/YYYY/MM/DD/rollout-*.jsonl
-	sessionsDir := filepath.Join(codexDir, "sessions")
-	if _, err := os.Stat(sessionsDir); os.IsNotExist(err) {
-		return []UsageRecord{}, nil
-	}
-
-	// 计算时间范围
-	cutoff := time.Now().AddDate(0, 0, -days)
-
-	var records []UsageRecord
-
-	// 遍历 sessions 目录下的日期文件夹
-	err := filepath.Walk(sessionsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // 忽略错误，继续遍历
-		}
-
-		// 只处理 .jsonl 文件
-		if info.IsDir() || !strings.HasSuffix(info.Name(), ".jsonl") {
-			return nil
-		}
-
-		// 检查文件修改时间
-		if info.ModTime().Before(cutoff) {
-			return nil
-		}
-
-		// 解析会话文件
-		sessionRecords, err := ls.parseCodexSession(path, info.Name(), cutoff)
-		if err != nil {
-			return nil // 忽略解析错误
-		}
-
-		records = append(records, sessionRecords...)
-		return nil
-	})
-
-	if err != nil {
-		return []UsageRecord{}, nil
-	}
-
-	if records == nil {
-		records = []UsageRecord{}
-	}
-
-	return records, nil
-}
-
-// parseCodexSession 解析 Codex 会话 JSONL 文件
-func (ls *LogService) parseCodexSession(path string, sessionID string, cutoff time.Time) ([]UsageRecord, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var records []UsageRecord
-	scanner := bufio.NewScanner(file)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
-
-	var lastTotalTokens *codexTokenUsage
-	var currentModel string = "gpt-5-codex" // 默认模型
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		// 先尝试解析为 event_msg 格式
-		var eventEntry struct {
-			Type      string `json:"type"`
-			Timestamp string `json:"timestamp"`
-			Payload   *struct {
-				Type  string          `json:"type"`
-				Info  *codexTokenInfo `json:"info"`
-				Model string          `json:"model"`
-			} `json:"payload"`
-		}
-
-		if err := json.Unmarshal([]byte(line), &eventEntry); err != nil {
-			continue
-		}
-
-		// 解析时间戳
-		ts, err := parseTimestamp(eventEntry.Timestamp)
-		if err != nil || ts.Before(cutoff) {
-			continue
-		}
-
-		// 处理 turn_context 类型 - 获取模型信息
-		if eventEntry.Type == "turn_context" && eventEntry.Payload != nil && eventEntry.Payload.Model != "" {
-			currentModel = eventEntry.Payload.Model
-			continue
-		}
-
-		// 处理 event_msg 类型的 token_count
-		if eventEntry.Type == "event_msg" && eventEntry.Payload != nil && eventEntry.Payload.Type == "token_count" {
-			if eventEntry.Payload.Info == nil || eventEntry.Payload.Info.TotalTokenUsage == nil {
-				continue
-			}
-
-			tc := eventEntry.Payload.Info.TotalTokenUsage
-
-			// 计算增量 (Codex 日志是累计值)
-			var inputDelta, cachedDelta, outputDelta int
-			if lastTotalTokens != nil {
-				inputDelta = tc.InputTokens - lastTotalTokens.InputTokens
-				cachedDelta = tc.CachedInputTokens - lastTotalTokens.CachedInputTokens
-				outputDelta = tc.OutputTokens - lastTotalTokens.OutputTokens
-			} else {
-				inputDelta = tc.InputTokens
-				cachedDelta = tc.CachedInputTokens
-				outputDelta = tc.OutputTokens
-			}
-
-			// 只记录有增量的条目
-			if inputDelta > 0 || outputDelta > 0 {
-				cost := ls.calculateCost(
-					currentModel,
-					inputDelta,
-					outputDelta,
-					0,
-					cachedDelta,
-				)
-
-				record := UsageRecord{
-					Timestamp:        ts.Format("2006-01-02 15:04:05"),
-					Model:            currentModel,
-					InputTokens:      inputDelta,
-					OutputTokens:     outputDelta,
-					CacheReadTokens:  cachedDelta,
-					CacheWriteTokens: 0,
-					TotalCost:        cost,
-					SessionID:        sessionID,
-					ProjectPath:      filepath.Base(path),
-				}
-
-				records = append(records, record)
-			}
-
-			lastTotalTokens = tc
-		}
-	}
-
-	return records, nil
+	return ls.indexedRecords("codex", days), nil
 }
 
 // getCodexDir 获取 Codex CLI 目录路径
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 
 	"github.com/wailsapp/wails/v2"
@@ -19,6 +20,8 @@ func main() {
 	logService := NewLogService()
 	skillService := NewSkillService()
 	uptimeService := NewUptimeService(app)
+	gatewayService := NewGatewayService(app, uptimeService)
+	budgetService := NewBudgetService(app, logService)
 
 	// Create application with options
 	err := wails.Run(&options.App{
@@ -29,10 +32,18 @@ func main() {
 			Assets: assets,
 		},
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
-		OnStartup:        app.OnStartup,
-		OnDomReady:       nil,
-		OnBeforeClose:    nil,
-		OnShutdown:       nil,
+		OnStartup: func(ctx context.Context) {
+			app.OnStartup(ctx)
+			logService.OnStartup(ctx)
+			gatewayService.OnStartup(ctx)
+			budgetService.OnStartup(ctx)
+		},
+		OnDomReady:    nil,
+		OnBeforeClose: nil,
+		OnShutdown: func(ctx context.Context) {
+			gatewayService.OnShutdown(ctx)
+			budgetService.OnShutdown(ctx)
+		},
 		WindowStartState: options.Normal,
 		Frameless:        true, // 启用无边框模式
 		Windows: &windows.Options{
@@ -48,6 +59,8 @@ func main() {
 			logService,
 			skillService,
 			uptimeService,
+			gatewayService,
+			budgetService,
 		},
 	})
 
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -1100,7 +1099,7 @@ func (ms *MCPService) testHTTPServer(url string, start time.Time) MCPTestResult
 		return MCPTestResult{Success: false, Message: "URL 为空"}
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := newProxyClient(loadGlobalProxyConfig(), 10*time.Second)
 	resp, err := client.Get(url)
 	latency := time.Since(start).Milliseconds()
 
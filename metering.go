@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	meteredUsageStoreFile = "gateway_usage.json"
+	meteredUsageKeepDays  = 90
+	meteredUsageFlush     = 2 * time.Second
+	usageMeterBodyLimit   = 8 * 1024 * 1024 // 非流式响应最多缓存的字节数
+	meteredHourLayout     = "2006-01-02T15"
+)
+
+// MeteredUsage 网关按 环境 / 模型 / 密钥指纹 / 小时 记录的精确用量。
+// InputTokens 不含缓存读取（与 Anthropic 的 usage 口径一致）
+type MeteredUsage struct {
+	Hour             string `json:"hour"` // 本地时间，2006-01-02T15
+	EnvName          string `json:"env_name"`
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	KeyFingerprint   string `json:"key_fingerprint,omitempty"`
+	Requests         int    `json:"requests"`
+	InputTokens      int64  `json:"input_tokens"`
+	OutputTokens     int64  `json:"output_tokens"`
+	CacheReadTokens  int64  `json:"cache_read_tokens"`
+	CacheWriteTokens int64  `json:"cache_write_tokens"`
+	// LongContext 单次请求输入超过该模型长上下文阈值的请求单独计量，按长上下文价格计价
+	LongContext bool    `json:"long_context,omitempty"`
+	Cost        float64 `json:"cost,omitempty"`     // 读取时按定价计算，不写盘
+	Unpriced    bool    `json:"unpriced,omitempty"` // 模型不在定价表中
+}
+
+// MeteredTotals 单个配置经网关计量的用量合计
+type MeteredTotals struct {
+	Requests         int     `json:"requests"`
+	InputTokens      int64   `json:"input_tokens"`
+	OutputTokens     int64   `json:"output_tokens"`
+	CacheReadTokens  int64   `json:"cache_read_tokens"`
+	CacheWriteTokens int64   `json:"cache_write_tokens"`
+	TotalCost        float64 `json:"total_cost"`
+	LastHour         string  `json:"last_hour,omitempty"`
+}
+
+// GetMeteredUsage 获取最近 N 天经本地网关计量的用量（按小时 / 环境 / 模型 / 密钥）
+func (ls *LogService) GetMeteredUsage(days int) ([]MeteredUsage, error) {
+	if days <= 0 {
+		days = 7
+	}
+	items, err := loadMeteredUsage(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Cost, items[i].Unpriced = ls.meteredCost(items[i])
+	}
+	return items, nil
+}
+
+// addMeteredUsage 将网关计量的用量并入按配置的汇总
+func (ls *LogService) addMeteredUsage(byEnv map[string]EnvUsageSummary, since time.Time) {
+	items, _ := loadMeteredUsage(since)
+	for _, item := range items {
+		summary := byEnv[item.EnvName]
+		if summary.Provider == "" {
+			summary.Provider = item.Provider
+		}
+		if summary.Metered == nil {
+			summary.Metered = &MeteredTotals{}
+		}
+		totals := summary.Metered
+		totals.Requests += item.Requests
+		totals.InputTokens += item.InputTokens
+		totals.OutputTokens += item.OutputTokens
+		totals.CacheReadTokens += item.CacheReadTokens
+		totals.CacheWriteTokens += item.CacheWriteTokens
+		cost, _ := ls.meteredCost(item)
+		totals.TotalCost += cost
+		if item.Hour > totals.LastHour {
+			totals.LastHour = item.Hour
+		}
+		byEnv[item.EnvName] = summary
+	}
+}
+
+// meteredCost 按小时汇总的用量无法逐次判断档位，长上下文请求已在记录时分桶
+func (ls *LogService) meteredCost(item MeteredUsage) (float64, bool) {
+	pricing, ok := currentPricing().lookup(ls.resolvePricingModel(item.Model))
+	if !ok {
+		return 0, false
+	}
+	return pricing.cost(int(item.InputTokens), int(item.OutputTokens), int(item.CacheWriteTokens), int(item.CacheReadTokens), item.LongContext), true
+}
+
+// meteredUsageState 内存中的用量记录，延迟写盘
+type meteredUsageState struct {
+	mu      sync.Mutex
+	loaded  bool
+	loadErr error // 计量文件无法解析时不写回，避免覆盖已有记录
+	buckets map[string]*MeteredUsage
+	timer   *time.Timer
+}
+
+var meteredUsage = &meteredUsageState{}
+
+// usageCounts 一次响应的用量
+type usageCounts struct {
+	Model      string
+	Input      int64
+	Output     int64
+	CacheRead  int64
+	CacheWrite int64
+}
+
+func (c usageCounts) empty() bool {
+	return c.Input == 0 && c.Output == 0 && c.CacheRead == 0 && c.CacheWrite == 0
+}
+
+// usageMeter 包装上游响应体：原样透传的同时解析 usage，读完或关闭时记录一次
+type usageMeter struct {
+	body     io.ReadCloser
+	envName  string
+	provider string
+	keyFP    string
+	aliases  map[string]string // 计价别名，记录时据此查找模型的长上下文阈值
+	stream   bool
+	line     []byte       // 流式响应中未结束的一行
+	buf      bytes.Buffer // 非流式响应的内容
+	counts   usageCounts
+	done     bool
+}
+
+func newUsageMeter(body io.ReadCloser, contentType string, upstream gatewayUpstream, provider, requestModel string) *usageMeter {
+	return &usageMeter{
+		body:     body,
+		envName:  upstream.EnvName,
+		provider: provider,
+		keyFP:    upstream.KeyFingerprint,
+		aliases:  upstream.PricingAliases,
+		stream:   strings.Contains(strings.ToLower(contentType), "text/event-stream"),
+		counts:   usageCounts{Model: requestModel},
+	}
+}
+
+func (m *usageMeter) Read(p []byte) (int, error) {
+	n, err := m.body.Read(p)
+	if n > 0 {
+		m.observe(p[:n])
+	}
+	if err == io.EOF {
+		m.finish()
+	}
+	return n, err
+}
+
+func (m *usageMeter) Close() error {
+	m.finish()
+	return m.body.Close()
+}
+
+func (m *usageMeter) observe(chunk []byte) {
+	if !m.stream {
+		if m.buf.Len()+len(chunk) <= usageMeterBodyLimit {
+			m.buf.Write(chunk)
+		}
+		return
+	}
+	m.line = append(m.line, chunk...)
+	for {
+		idx := bytes.IndexByte(m.line, '\n')
+		if idx < 0 {
+			break
+		}
+		m.observeLine(m.line[:idx])
+		m.line = m.line[idx+1:]
+	}
+}
+
+// observeLine 解析 SSE 的 data 行（Anthropic message_start / message_delta、
+// OpenAI response.completed 与带 usage 的最后一个 chunk、Gemini usageMetadata）
+func (m *usageMeter) observeLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	data := bytes.TrimSpace(line[len("data:"):])
+	if len(data) == 0 || data[0] != '{' {
+		return
+	}
+	var payload map[string]any
+	if json.Unmarshal(data, &payload) == nil {
+		m.counts.merge(payload)
+	}
+}
+
+func (m *usageMeter) finish() {
+	if m.done {
+		return
+	}
+	m.done = true
+	if m.stream {
+		if len(m.line) > 0 {
+			m.observeLine(m.line)
+		}
+	} else {
+		var payload map[string]any
+		if json.Unmarshal(m.buf.Bytes(), &payload) == nil {
+			m.counts.merge(payload)
+		}
+	}
+	if m.counts.empty() {
+		return
+	}
+	recordMeteredUsage(time.Now(), m.envName, m.provider, m.keyFP, m.counts, meteredLongContext(m.counts, m.aliases))
+}
+
+// withChatStreamUsage 流式 Chat Completions 请求未要求 usage 时加上 stream_options.include_usage，
+// 否则上游不返回用量、无法计量；返回是否由网关加上（此时需用 dropChatUsageChunk 去掉多出的 chunk）
+func withChatStreamUsage(body []byte) ([]byte, bool) {
+	var req map[string]any
+	if json.Unmarshal(body, &req) != nil {
+		return body, false
+	}
+	if stream, _ := req["stream"].(bool); !stream {
+		return body, false
+	}
+	options, _ := req["stream_options"].(map[string]any)
+	if include, _ := options["include_usage"].(bool); include {
+		return body, false
+	}
+	if options == nil {
+		options = map[string]any{}
+	}
+	options["include_usage"] = true
+	req["stream_options"] = options
+	converted, err := json.Marshal(req)
+	if err != nil {
+		return body, false
+	}
+	return converted, true
+}
+
+// dropChatUsageChunk 去掉 include_usage 在流末尾追加的只含 usage 的 chunk（choices 为空），
+// 客户端收到的事件流与未注入时一致
+func dropChatUsageChunk(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		err := readSSE(body, func(event string, data []byte) error {
+			var chunk map[string]any
+			if json.Unmarshal(data, &chunk) == nil && len(asSlice(chunk["choices"])) == 0 && chunk["usage"] != nil {
+				return nil
+			}
+			if event != "" {
+				if _, err := fmt.Fprintf(pw, "event: %s\n", event); err != nil {
+					return err
+				}
+			}
+			for _, line := range bytes.Split(data, []byte("\n")) {
+				if _, err := fmt.Fprintf(pw, "data: %s\n", line); err != nil {
+					return err
+				}
+			}
+			_, err := io.WriteString(pw, "\n")
+			return err
+		})
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return &pipeBody{PipeReader: pr, upstream: body}
+}
+
+// meteredLongContext 按模型定价的长上下文阈值判断单次请求的档位；未定价或没有长上下文档位的模型不区分
+func meteredLongContext(counts usageCounts, aliases map[string]string) bool {
+	model := counts.Model
+	if alias, ok := aliases[strings.ToLower(strings.TrimSpace(model))]; ok {
+		model = alias
+	}
+	price, ok := currentPricing().lookup(model)
+	return ok && price.longContext(int(counts.Input), int(counts.CacheWrite), int(counts.CacheRead))
+}
+
+// merge 从一个响应对象或流式事件中提取 usage；流式事件中的计数为累计值，取最大值
+func (c *usageCounts) merge(payload map[string]any) {
+	if model := usageString(payload, "model"); model != "" {
+		c.Model = model
+	} else if model := usageString(payload, "modelVersion"); model != "" {
+		c.Model = model
+	}
+	for _, key := range []string{"message", "response"} {
+		if nested, ok := payload[key].(map[string]any); ok {
+			c.merge(nested)
+		}
+	}
+
+	if usage, ok := payload["usage"].(map[string]any); ok {
+		// Anthropic 的 usage 没有 total_tokens / prompt_tokens，输入不含缓存
+		if !usageHas(usage, "total_tokens") && !usageHas(usage, "prompt_tokens") {
+			c.Input = max(c.Input, usageInt(usage, "input_tokens"))
+			c.Output = max(c.Output, usageInt(usage, "output_tokens"))
+			c.CacheRead = max(c.CacheRead, usageInt(usage, "cache_read_input_tokens"))
+			c.CacheWrite = max(c.CacheWrite, usageInt(usage, "cache_creation_input_tokens"))
+			return
+		}
+		// OpenAI：输入包含缓存命中部分
+		input := max(usageInt(usage, "input_tokens"), usageInt(usage, "prompt_tokens"))
+		cached := usageNestedInt(usage, "input_tokens_details", "cached_tokens") + usageNestedInt(usage, "prompt_tokens_details", "cached_tokens")
+		c.mergeInclusiveInput(input, cached)
+		c.Output = max(c.Output, max(usageInt(usage, "output_tokens"), usageInt(usage, "completion_tokens")))
+	}
+
+	if usage, ok := payload["usageMetadata"].(map[string]any); ok {
+		c.mergeInclusiveInput(usageInt(usage, "promptTokenCount"), usageInt(usage, "cachedContentTokenCount"))
+		c.Output = max(c.Output, usageInt(usage, "candidatesTokenCount")+usageInt(usage, "thoughtsTokenCount"))
+	}
+}
+
+// mergeInclusiveInput 合并包含缓存命中的输入计数（OpenAI / Gemini）。总数与缓存分别取最大值后再相减，
+// 先到的事件没有缓存计数时不会把缓存部分算进输入
+func (c *usageCounts) mergeInclusiveInput(input, cached int64) {
+	total := max(c.Input+c.CacheRead, input)
+	c.CacheRead = max(c.CacheRead, cached)
+	c.Input = max(total-c.CacheRead, 0)
+}
+
+func usageHas(m map[string]any, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func usageString(m map[string]any, key string) string {
+	value, _ := m[key].(string)
+	return strings.TrimSpace(value)
+}
+
+func usageInt(m map[string]any, key string) int64 {
+	switch value := m[key].(type) {
+	case float64:
+		return max(int64(value), 0)
+	case int64:
+		return max(value, 0)
+	case int:
+		return max(int64(value), 0)
+	}
+	return 0
+}
+
+func usageNestedInt(m map[string]any, key, field string) int64 {
+	if nested, ok := m[key].(map[string]any); ok {
+		return usageInt(nested, field)
+	}
+	return 0
+}
+
+// gatewayRequestModel 从请求中取模型名（响应未携带模型时使用）
+func gatewayRequestModel(body []byte, path string) string {
+	var payload struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &payload) == nil && strings.TrimSpace(payload.Model) != "" {
+		return strings.TrimSpace(payload.Model)
+	}
+	// Gemini：/v1beta/models/<model>:generateContent
+	if idx := strings.Index(path, "/models/"); idx >= 0 {
+		model := path[idx+len("/models/"):]
+		if colon := strings.Index(model, ":"); colon >= 0 {
+			model = model[:colon]
+		}
+		return model
+	}
+	return ""
+}
+
+// keyFingerprint 密钥指纹（不保存密钥本身）
+func keyFingerprint(key string) string {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// recordMeteredUsage 累加到按小时、配置、模型、密钥与长上下文档位划分的桶
+func recordMeteredUsage(at time.Time, envName, provider, keyFP string, counts usageCounts, longContext bool) {
+	s := meteredUsage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadLocked()
+
+	hour := at.Format(meteredHourLayout)
+	key := meteredBucketKey(hour, envName, counts.Model, keyFP, longContext)
+	bucket := s.buckets[key]
+	if bucket == nil {
+		bucket = &MeteredUsage{Hour: hour, EnvName: envName, Provider: provider, Model: counts.Model, KeyFingerprint: keyFP, LongContext: longContext}
+		s.buckets[key] = bucket
+	}
+	bucket.Requests++
+	bucket.InputTokens += counts.Input
+	bucket.OutputTokens += counts.Output
+	bucket.CacheReadTokens += counts.CacheRead
+	bucket.CacheWriteTokens += counts.CacheWrite
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(meteredUsageFlush, flushMeteredUsage)
+	}
+}
+
+// flushMeteredUsage 将内存中的用量写盘，并清理超过保留期的记录
+func flushMeteredUsage() {
+	s := meteredUsage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer = nil
+	if !s.loaded || s.loadErr != nil {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -meteredUsageKeepDays).Format(meteredHourLayout)
+	items := make([]MeteredUsage, 0, len(s.buckets))
+	for key, bucket := range s.buckets {
+		if bucket.Hour < cutoff {
+			delete(s.buckets, key)
+			continue
+		}
+		item := *bucket
+		item.Cost = 0
+		item.Unpriced = false
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Hour != items[j].Hour {
+			return items[i].Hour < items[j].Hour
+		}
+		if items[i].EnvName != items[j].EnvName {
+			return items[i].EnvName < items[j].EnvName
+		}
+		return items[i].Model < items[j].Model
+	})
+	_ = saveMeteredUsageFile(items)
+}
+
+// loadMeteredUsage 返回 since 之后的用量记录
+func loadMeteredUsage(since time.Time) ([]MeteredUsage, error) {
+	s := meteredUsage
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadLocked()
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+
+	from := since.Format(meteredHourLayout)
+	items := []MeteredUsage{}
+	for _, bucket := range s.buckets {
+		if bucket.Hour >= from {
+			items = append(items, *bucket)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Hour < items[j].Hour })
+	return items, nil
+}
+
+func meteredBucketKey(hour, envName, model, keyFP string, longContext bool) string {
+	return strings.Join([]string{hour, envName, model, keyFP, strconv.FormatBool(longContext)}, "\x00")
+}
+
+func (s *meteredUsageState) loadLocked() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	s.buckets = map[string]*MeteredUsage{}
+	items, err := loadMeteredUsageFile()
+	if err != nil {
+		s.loadErr = err
+		return
+	}
+	for i := range items {
+		item := items[i]
+		key := meteredBucketKey(item.Hour, item.EnvName, item.Model, item.KeyFingerprint, item.LongContext)
+		s.buckets[key] = &item
+	}
+}
+
+func loadMeteredUsageFile() ([]MeteredUsage, error) {
+	var items []MeteredUsage
+	if _, err := loadJSONStore(meteredUsageStoreFile, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func saveMeteredUsageFile(items []MeteredUsage) error {
+	return saveJSONStore(meteredUsageStoreFile, items, 0o644)
+}
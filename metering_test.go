@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUsageCountsMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string // 依次合并的响应对象或流式事件
+		want   usageCounts
+	}{
+		{
+			name:   "anthropic message",
+			events: []string{`{"model":"claude-sonnet-4","usage":{"input_tokens":100,"output_tokens":20,"cache_read_input_tokens":300,"cache_creation_input_tokens":40}}`},
+			want:   usageCounts{Model: "claude-sonnet-4", Input: 100, Output: 20, CacheRead: 300, CacheWrite: 40},
+		},
+		{
+			name: "anthropic stream",
+			events: []string{
+				`{"type":"message_start","message":{"model":"claude-sonnet-4","usage":{"input_tokens":100,"output_tokens":1,"cache_read_input_tokens":300}}}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":57}}`,
+			},
+			want: usageCounts{Model: "claude-sonnet-4", Input: 100, Output: 57, CacheRead: 300},
+		},
+		{
+			name: "openai chat stream with usage chunk",
+			events: []string{
+				`{"model":"gpt-4.1","choices":[{"index":0,"delta":{"content":"hi"}}]}`,
+				`{"model":"gpt-4.1","choices":[],"usage":{"prompt_tokens":120,"completion_tokens":30,"total_tokens":150,"prompt_tokens_details":{"cached_tokens":100}}}`,
+			},
+			want: usageCounts{Model: "gpt-4.1", Input: 20, Output: 30, CacheRead: 100},
+		},
+		{
+			name: "openai responses completed event",
+			events: []string{
+				`{"type":"response.created","response":{"model":"gpt-5","usage":null}}`,
+				`{"type":"response.completed","response":{"model":"gpt-5","usage":{"input_tokens":500,"output_tokens":80,"total_tokens":580,"input_tokens_details":{"cached_tokens":400}}}}`,
+			},
+			want: usageCounts{Model: "gpt-5", Input: 100, Output: 80, CacheRead: 400},
+		},
+		{
+			name: "gemini stream",
+			events: []string{
+				`{"modelVersion":"gemini-2.5-pro","usageMetadata":{"promptTokenCount":1000,"candidatesTokenCount":5}}`,
+				`{"modelVersion":"gemini-2.5-pro","usageMetadata":{"promptTokenCount":1000,"candidatesTokenCount":50,"thoughtsTokenCount":25,"cachedContentTokenCount":600}}`,
+			},
+			want: usageCounts{Model: "gemini-2.5-pro", Input: 400, Output: 75, CacheRead: 600},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got usageCounts
+			for _, event := range tt.events {
+				var payload map[string]any
+				if err := json.Unmarshal([]byte(event), &payload); err != nil {
+					t.Fatal(err)
+				}
+				got.merge(payload)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithChatStreamUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		injected bool
+	}{
+		{"stream without options", `{"model":"m","stream":true}`, true},
+		{"stream with other options", `{"model":"m","stream":true,"stream_options":{"include_obfuscation":false}}`, true},
+		{"client already asks for usage", `{"model":"m","stream":true,"stream_options":{"include_usage":true}}`, false},
+		{"not streaming", `{"model":"m"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, injected := withChatStreamUsage([]byte(tt.body))
+			if injected != tt.injected {
+				t.Fatalf("injected = %v, want %v", injected, tt.injected)
+			}
+			var req map[string]any
+			if err := json.Unmarshal(body, &req); err != nil {
+				t.Fatal(err)
+			}
+			options, _ := req["stream_options"].(map[string]any)
+			if stream, _ := req["stream"].(bool); stream && options["include_usage"] != true {
+				t.Errorf("include_usage missing: %s", body)
+			}
+		})
+	}
+}
+
+func TestDropChatUsageChunk(t *testing.T) {
+	upstream := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":1,\"total_tokens\":4}}\n\n" +
+		"data: [DONE]\n\n"
+	body := dropChatUsageChunk(io.NopCloser(strings.NewReader(upstream)))
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	if string(got) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	modelListCacheTTL  = 10 * time.Minute
+	modelListBodyLimit = 8 * 1024 * 1024
+	modelListMaxPages  = 20
+	modelListPageSize  = "1000"
+)
+
+// ModelInfo 端点返回的模型
+type ModelInfo struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// ModelListResult 环境可用模型列表
+type ModelListResult struct {
+	EnvName  string      `json:"env_name"`
+	Provider string      `json:"provider"`
+	Models   []ModelInfo `json:"models"`
+	// ConfiguredModel 环境中配置的模型（ANTHROPIC_MODEL / model / GEMINI_MODEL / OPENCLAW_PRIMARY_MODEL）
+	ConfiguredModel string `json:"configured_model,omitempty"`
+	// ModelMissing 配置的模型不在列表中
+	ModelMissing bool  `json:"model_missing"`
+	Cached       bool  `json:"cached"`
+	FetchedAt    int64 `json:"fetched_at"`
+}
+
+type modelListCacheEntry struct {
+	key       string // 端点与凭据的指纹，变化时缓存失效
+	models    []ModelInfo
+	fetchedAt time.Time
+}
+
+var (
+	modelListCacheMu sync.Mutex
+	modelListCache   = map[string]modelListCacheEntry{}
+)
+
+// ListModels 获取环境端点的可用模型（带缓存）
+func (a *App) ListModels(envName string) (ModelListResult, error) {
+	return a.listModels(envName, false)
+}
+
+// RefreshModels 忽略缓存重新获取环境端点的可用模型
+func (a *App) RefreshModels(envName string) (ModelListResult, error) {
+	return a.listModels(envName, true)
+}
+
+func (a *App) listModels(envName string, force bool) (ModelListResult, error) {
+	env := a.findEnv(envName)
+	if env == nil {
+		return ModelListResult{}, fmt.Errorf("环境 '%s' 不存在", envName)
+	}
+	return newEnvProber(newProxyClient(a.effectiveProxy(env), probeDefaultTimeout)).listModels(*env, force)
+}
+
+func (p *envProber) listModels(env EnvConfig, force bool) (ModelListResult, error) {
+	provider := strings.ToLower(strings.TrimSpace(env.Provider))
+	if provider == "" {
+		provider = "claude"
+	}
+	result := ModelListResult{EnvName: env.Name, Provider: provider, ConfiguredModel: configuredEnvModel(env)}
+
+	req, status, message := p.buildRequest(env, provider, probeModeModels)
+	if status != "" {
+		return result, fmt.Errorf("无法获取模型列表: %s", message)
+	}
+	cacheKey := modelListFingerprint(req)
+
+	modelListCacheMu.Lock()
+	entry, ok := modelListCache[env.Name]
+	modelListCacheMu.Unlock()
+	if !force && ok && entry.key == cacheKey && time.Since(entry.fetchedAt) < modelListCacheTTL {
+		result.Cached = true
+	} else {
+		models, err := p.fetchModels(req)
+		if err != nil {
+			return result, err
+		}
+		entry = modelListCacheEntry{key: cacheKey, models: models, fetchedAt: time.Now()}
+		modelListCacheMu.Lock()
+		modelListCache[env.Name] = entry
+		modelListCacheMu.Unlock()
+	}
+
+	result.Models = entry.models
+	result.FetchedAt = entry.fetchedAt.Unix()
+	if result.ConfiguredModel != "" {
+		result.ModelMissing = !modelListContains(entry.models, result.ConfiguredModel)
+	}
+	return result, nil
+}
+
+// fetchModels 按 Provider 的分页方式获取完整模型列表
+func (p *envProber) fetchModels(req probeRequest) ([]ModelInfo, error) {
+	var models []ModelInfo
+	seen := map[string]struct{}{}
+	pageURL := req.url
+	if req.kind == "anthropic-models" {
+		pageURL = appendQuery(pageURL, "limit", modelListPageSize)
+	} else if req.kind == "gemini-models" {
+		pageURL = appendQuery(pageURL, "pageSize", modelListPageSize)
+	}
+
+	for page := 0; page < modelListMaxPages && pageURL != ""; page++ {
+		pageReq := req
+		pageReq.url = pageURL
+		resp, err := p.send(pageReq, modelListBodyLimit)
+		if err != nil {
+			return nil, fmt.Errorf("请求模型列表失败: %v", err)
+		}
+		if resp.statusCode < 200 || resp.statusCode >= 300 {
+			status, message := classifyProbeResponse(resp.statusCode, resp.body, false)
+			return nil, fmt.Errorf("请求模型列表失败 (%s, HTTP %d): %s", status, resp.statusCode, message)
+		}
+
+		var payload struct {
+			Data []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"`
+			} `json:"data"`
+			HasMore       bool   `json:"has_more"`
+			LastID        string `json:"last_id"`
+			NextPageToken string `json:"nextPageToken"`
+			Models        []struct {
+				Name        string `json:"name"`
+				DisplayName string `json:"displayName"`
+			} `json:"models"`
+		}
+		if err := json.Unmarshal(resp.body, &payload); err != nil {
+			return nil, fmt.Errorf("解析模型列表失败: %v", err)
+		}
+
+		add := func(id, displayName string) {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				return
+			}
+			if _, ok := seen[id]; ok {
+				return
+			}
+			seen[id] = struct{}{}
+			models = append(models, ModelInfo{ID: id, DisplayName: strings.TrimSpace(displayName)})
+		}
+		for _, item := range payload.Data {
+			add(item.ID, item.DisplayName)
+		}
+		for _, item := range payload.Models {
+			add(strings.TrimPrefix(item.Name, "models/"), item.DisplayName)
+		}
+
+		pageURL = ""
+		switch {
+		case req.kind == "anthropic-models" && payload.HasMore && payload.LastID != "":
+			pageURL = appendQuery(appendQuery(req.url, "limit", modelListPageSize), "after_id", payload.LastID)
+		case req.kind == "gemini-models" && payload.NextPageToken != "":
+			pageURL = appendQuery(appendQuery(req.url, "pageSize", modelListPageSize), "pageToken", payload.NextPageToken)
+		}
+	}
+
+	sort.SliceStable(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	if models == nil {
+		models = []ModelInfo{}
+	}
+	return models, nil
+}
+
+// configuredEnvModel 返回环境中配置的主模型
+func configuredEnvModel(env EnvConfig) string {
+	vars := env.Variables
+	switch strings.ToLower(strings.TrimSpace(env.Provider)) {
+	case "codex":
+		return strings.TrimSpace(vars["model"])
+	case "gemini":
+		return strings.TrimPrefix(strings.TrimSpace(vars["GEMINI_MODEL"]), "models/")
+	case "openclaw":
+		return strings.TrimSpace(vars["OPENCLAW_PRIMARY_MODEL"])
+	default:
+		if env.ModelMapping != nil && strings.TrimSpace(env.ModelMapping.Model) != "" {
+			return strings.TrimSpace(env.ModelMapping.Model)
+		}
+		return strings.TrimSpace(vars["ANTHROPIC_MODEL"])
+	}
+}
+
+// modelListContains 判断模型是否在列表中；OpenClaw 的 provider/model 形式同时按模型名匹配
+func modelListContains(models []ModelInfo, model string) bool {
+	candidates := []string{strings.ToLower(model)}
+	if idx := strings.Index(model, "/"); idx >= 0 {
+		candidates = append(candidates, strings.ToLower(model[idx+1:]))
+	}
+	for _, item := range models {
+		id := strings.ToLower(item.ID)
+		for _, candidate := range candidates {
+			if id == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func modelListFingerprint(req probeRequest) string {
+	keys := make([]string, 0, len(req.headers))
+	for key := range req.headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	h.Write([]byte(req.url))
+	for _, key := range keys {
+		h.Write([]byte("\n" + key + "=" + req.headers[key]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func appendQuery(rawURL, key, value string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsed.Query()
+	query.Set(key, value)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
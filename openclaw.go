@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// OpenclawAgent OpenClaw 命名 agent（agents.<name>）
+type OpenclawAgent struct {
+	Name       string   `json:"name"`
+	Model      string   `json:"model,omitempty"`
+	Fallbacks  []string `json:"fallbacks,omitempty"`
+	ImageModel string   `json:"image_model,omitempty"`
+	PdfModel   string   `json:"pdf_model,omitempty"`
+	// Skills 技能白名单；nil 表示不限制
+	Skills []string `json:"skills,omitempty"`
+	// Extra 未识别的键，原样保留
+	Extra map[string]any `json:"extra,omitempty"`
+}
+
+// OpenclawProvider OpenClaw 模型提供方（providers.<name>）
+type OpenclawProvider struct {
+	Name    string         `json:"name"`
+	BaseURL string         `json:"base_url,omitempty"`
+	APIKey  string         `json:"api_key,omitempty"`
+	Extra   map[string]any `json:"extra,omitempty"`
+}
+
+// OpenclawConfig 结构化的 OpenClaw agents / providers 配置
+type OpenclawConfig struct {
+	Agents    []OpenclawAgent    `json:"agents"`
+	Providers []OpenclawProvider `json:"providers"`
+}
+
+var (
+	openclawAgentKnownKeys    = []string{"model", "imageModel", "pdfModel", "skills"}
+	openclawProviderKnownKeys = []string{"baseURL", "apiKey"}
+)
+
+// GetOpenclawConfig 读取当前 OpenClaw 配置文件中的命名 agents 与 providers
+func (a *App) GetOpenclawConfig() (OpenclawConfig, error) {
+	configFile := a.activeOpenclawConfigFile()
+	payload := map[string]any{}
+	if data, err := os.ReadFile(configFile); err == nil && len(data) > 0 {
+		parsed, err := parseJSONLikeObject(data)
+		if err != nil {
+			return OpenclawConfig{}, err
+		}
+		payload = parsed
+	} else if err != nil && !os.IsNotExist(err) {
+		return OpenclawConfig{}, fmt.Errorf("读取 OpenClaw 配置失败: %v", err)
+	}
+	return extractOpenclawConfig(payload), nil
+}
+
+// SaveOpenclawConfig 将命名 agents 与 providers 写回 OpenClaw 配置文件，保留其他键
+func (a *App) SaveOpenclawConfig(cfg OpenclawConfig) error {
+	if err := validateOpenclawConfig(cfg); err != nil {
+		return err
+	}
+
+	configFile := a.activeOpenclawConfigFile()
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		return fmt.Errorf("创建 OpenClaw 配置目录失败: %v", err)
+	}
+
+	payload := map[string]any{}
+	if data, err := os.ReadFile(configFile); err == nil && len(data) > 0 {
+		parsed, err := parseJSONLikeObject(data)
+		if err != nil {
+			return err
+		}
+		payload = parsed
+	}
+
+	mergeOpenclawConfig(payload, &cfg)
+	content, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化 OpenClaw 配置失败: %v", err)
+	}
+	if err := os.WriteFile(configFile, content, 0644); err != nil {
+		return fmt.Errorf("写入 OpenClaw 配置失败: %v", err)
+	}
+	return nil
+}
+
+func (a *App) activeOpenclawConfigFile() string {
+	activeVars := map[string]string{}
+	if env := a.findEnv(a.config.CurrentEnvOpenclaw); env != nil {
+		activeVars = env.Variables
+	}
+	_, _, configFile := resolveOpenclawPaths(activeVars)
+	return configFile
+}
+
+func validateOpenclawConfig(cfg OpenclawConfig) error {
+	seen := map[string]struct{}{}
+	for _, agent := range cfg.Agents {
+		name := strings.TrimSpace(agent.Name)
+		if name == "" {
+			return fmt.Errorf("agent 名称不能为空")
+		}
+		if name == "defaults" {
+			return fmt.Errorf("agent 名称不能为 defaults（请使用默认模型设置）")
+		}
+		if _, ok := seen["agent:"+name]; ok {
+			return fmt.Errorf("agent 名称重复：%s", name)
+		}
+		seen["agent:"+name] = struct{}{}
+	}
+	for _, provider := range cfg.Providers {
+		name := strings.TrimSpace(provider.Name)
+		if name == "" {
+			return fmt.Errorf("provider 名称不能为空")
+		}
+		if _, ok := seen["provider:"+name]; ok {
+			return fmt.Errorf("provider 名称重复：%s", name)
+		}
+		seen["provider:"+name] = struct{}{}
+	}
+	return nil
+}
+
+// extractOpenclawConfig 从配置文档中解析命名 agents / providers
+func extractOpenclawConfig(payload map[string]any) OpenclawConfig {
+	cfg := OpenclawConfig{Agents: []OpenclawAgent{}, Providers: []OpenclawProvider{}}
+
+	if agents, ok := payload["agents"].(map[string]any); ok {
+		for _, name := range sortedKeys(agents) {
+			raw, ok := agents[name].(map[string]any)
+			if name == "defaults" || !ok {
+				continue
+			}
+			agent := OpenclawAgent{Name: name}
+			agent.Model, agent.Fallbacks = parseOpenclawModelValue(raw["model"])
+			agent.ImageModel, _ = raw["imageModel"].(string)
+			agent.PdfModel, _ = raw["pdfModel"].(string)
+			if skills, ok := raw["skills"].([]any); ok {
+				agent.Skills = anyToStringList(skills)
+			}
+			agent.Extra = extraKeys(raw, openclawAgentKnownKeys)
+			cfg.Agents = append(cfg.Agents, agent)
+		}
+	}
+
+	if providers, ok := payload["providers"].(map[string]any); ok {
+		for _, name := range sortedKeys(providers) {
+			raw, ok := providers[name].(map[string]any)
+			if !ok {
+				continue
+			}
+			provider := OpenclawProvider{Name: name}
+			provider.BaseURL, _ = raw["baseURL"].(string)
+			provider.APIKey, _ = raw["apiKey"].(string)
+			provider.Extra = extraKeys(raw, openclawProviderKnownKeys)
+			cfg.Providers = append(cfg.Providers, provider)
+		}
+	}
+
+	return cfg
+}
+
+// mergeOpenclawConfig 用结构化配置替换文档中的命名 agents / providers；
+// agents.defaults 与各条目中未识别的键保持不变
+func mergeOpenclawConfig(payload map[string]any, cfg *OpenclawConfig) {
+	agents := ensureTable(payload, "agents")
+	keepAgents := map[string]struct{}{"defaults": {}}
+	for _, agent := range cfg.Agents {
+		name := strings.TrimSpace(agent.Name)
+		keepAgents[name] = struct{}{}
+
+		raw, _ := agents[name].(map[string]any)
+		if raw == nil {
+			raw = map[string]any{}
+		}
+		for key, value := range agent.Extra {
+			raw[key] = value
+		}
+		if value := buildOpenclawModelValue(agent.Model, agent.Fallbacks); value != nil {
+			raw["model"] = value
+		} else {
+			delete(raw, "model")
+		}
+		setOrDeleteString(raw, "imageModel", agent.ImageModel)
+		setOrDeleteString(raw, "pdfModel", agent.PdfModel)
+		if agent.Skills != nil {
+			raw["skills"] = cleanArgs(agent.Skills)
+		} else {
+			delete(raw, "skills")
+		}
+		agents[name] = raw
+	}
+	for name := range agents {
+		if _, ok := keepAgents[name]; !ok {
+			delete(agents, name)
+		}
+	}
+	if len(agents) == 0 {
+		delete(payload, "agents")
+	}
+
+	providers := ensureTable(payload, "providers")
+	keepProviders := map[string]struct{}{}
+	for _, provider := range cfg.Providers {
+		name := strings.TrimSpace(provider.Name)
+		keepProviders[name] = struct{}{}
+
+		raw, _ := providers[name].(map[string]any)
+		if raw == nil {
+			raw = map[string]any{}
+		}
+		for key, value := range provider.Extra {
+			raw[key] = value
+		}
+		setOrDeleteString(raw, "baseURL", provider.BaseURL)
+		setOrDeleteString(raw, "apiKey", provider.APIKey)
+		providers[name] = raw
+	}
+	for name := range providers {
+		if _, ok := keepProviders[name]; !ok {
+			delete(providers, name)
+		}
+	}
+	if len(providers) == 0 {
+		delete(payload, "providers")
+	}
+}
+
+// parseOpenclawModelValue 解析 model 字段（字符串或 {primary, fallbacks}）
+func parseOpenclawModelValue(value any) (string, []string) {
+	switch mv := value.(type) {
+	case string:
+		return strings.TrimSpace(mv), nil
+	case map[string]any:
+		primary, _ := mv["primary"].(string)
+		var fallbacks []string
+		if list, ok := mv["fallbacks"].([]any); ok {
+			fallbacks = anyToStringList(list)
+		}
+		return strings.TrimSpace(primary), fallbacks
+	default:
+		return "", nil
+	}
+}
+
+func buildOpenclawModelValue(model string, fallbacks []string) any {
+	model = strings.TrimSpace(model)
+	if model == "" {
+		return nil
+	}
+	fallbacks = cleanArgs(fallbacks)
+	if len(fallbacks) == 0 {
+		return model
+	}
+	return map[string]any{
+		"primary":   model,
+		"fallbacks": fallbacks,
+	}
+}
+
+func anyToStringList(values []any) []string {
+	list := make([]string, 0, len(values))
+	for _, item := range values {
+		if s, ok := item.(string); ok && strings.TrimSpace(s) != "" {
+			list = append(list, strings.TrimSpace(s))
+		}
+	}
+	return list
+}
+
+func extraKeys(raw map[string]any, known []string) map[string]any {
+	extra := map[string]any{}
+	for key, value := range raw {
+		if !containsString(known, key) {
+			extra[key] = value
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envOpenclawConfig 返回环境挂载的结构化配置；OPENCLAW_GATEWAY_BASE_URL 对应的 openai provider 会被保留
+func envOpenclawConfig(env *EnvConfig) *OpenclawConfig {
+	if env.Openclaw == nil {
+		return nil
+	}
+	cfg := *env.Openclaw
+	gatewayURL := strings.TrimSpace(env.Variables["OPENCLAW_GATEWAY_BASE_URL"])
+	if gatewayURL == "" {
+		return &cfg
+	}
+	for _, provider := range cfg.Providers {
+		if strings.TrimSpace(provider.Name) == "openai" {
+			return &cfg
+		}
+	}
+	cfg.Providers = append(append([]OpenclawProvider{}, cfg.Providers...), OpenclawProvider{Name: "openai", BaseURL: gatewayURL})
+	return &cfg
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const permissionBackupFile = "permissions_backup.json"
+
+var permissionBackupMu sync.Mutex
+
+// PermissionProfile 权限配置（可挂到 EnvConfig 上，切换环境时一并应用）
+type PermissionProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Claude Code: ~/.claude/settings.json 的 permissions 字段
+	Allow                 []string `json:"allow"`
+	Deny                  []string `json:"deny"`
+	AdditionalDirectories []string `json:"additional_directories"`
+	DefaultMode           string   `json:"default_mode,omitempty"` // default | acceptEdits | plan | bypassPermissions
+	// Codex: ~/.codex/config.toml 顶层及 [profiles.<env>] 下的键
+	CodexApprovalPolicy string `json:"codex_approval_policy,omitempty"` // untrusted | on-failure | on-request | never
+	CodexSandboxMode    string `json:"codex_sandbox_mode,omitempty"`    // read-only | workspace-write | danger-full-access
+}
+
+// permissionSnapshot 首次应用权限配置前，被覆盖键的原始值（用于切回无权限配置的环境时还原）
+type permissionSnapshot struct {
+	Values map[string]any `json:"values,omitempty"`
+	Absent []string       `json:"absent,omitempty"`
+}
+
+type permissionBackupStore struct {
+	Providers map[string]*permissionSnapshot `json:"providers"`
+}
+
+var (
+	claudeDefaultModes    = []string{"default", "acceptEdits", "plan", "bypassPermissions"}
+	codexApprovalPolicies = []string{"untrusted", "on-failure", "on-request", "never"}
+	codexSandboxModes     = []string{"read-only", "workspace-write", "danger-full-access"}
+)
+
+// ListPermissionProfiles 列出所有权限配置
+func (a *App) ListPermissionProfiles() []PermissionProfile {
+	if a.config.PermissionProfiles == nil {
+		return []PermissionProfile{}
+	}
+	return a.config.PermissionProfiles
+}
+
+// SavePermissionProfile 新增或更新权限配置（按名称匹配）
+func (a *App) SavePermissionProfile(profile PermissionProfile) error {
+	profile = normalizePermissionProfile(profile)
+	if err := validatePermissionProfile(profile); err != nil {
+		return err
+	}
+
+	for i := range a.config.PermissionProfiles {
+		if a.config.PermissionProfiles[i].Name == profile.Name {
+			a.config.PermissionProfiles[i] = profile
+			return a.saveConfig()
+		}
+	}
+
+	a.config.PermissionProfiles = append(a.config.PermissionProfiles, profile)
+	sort.SliceStable(a.config.PermissionProfiles, func(i, j int) bool {
+		return strings.ToLower(a.config.PermissionProfiles[i].Name) < strings.ToLower(a.config.PermissionProfiles[j].Name)
+	})
+	return a.saveConfig()
+}
+
+// DeletePermissionProfile 删除权限配置，并解除所有环境对它的引用
+func (a *App) DeletePermissionProfile(name string) error {
+	trimmed := strings.TrimSpace(name)
+	for i, profile := range a.config.PermissionProfiles {
+		if profile.Name != trimmed {
+			continue
+		}
+		a.config.PermissionProfiles = append(a.config.PermissionProfiles[:i], a.config.PermissionProfiles[i+1:]...)
+		for j := range a.config.Environments {
+			if a.config.Environments[j].PermissionProfile == trimmed {
+				a.config.Environments[j].PermissionProfile = ""
+			}
+		}
+		return a.saveConfig()
+	}
+	return fmt.Errorf("权限配置 '%s' 不存在", trimmed)
+}
+
+func (a *App) findPermissionProfile(name string) *PermissionProfile {
+	for _, profile := range a.config.PermissionProfiles {
+		if profile.Name == name {
+			return &profile
+		}
+	}
+	return nil
+}
+
+// resolveEnvPermissionProfile 返回环境引用的权限配置；未引用时返回 nil
+func (a *App) resolveEnvPermissionProfile(env *EnvConfig) (*PermissionProfile, error) {
+	name := strings.TrimSpace(env.PermissionProfile)
+	if name == "" {
+		return nil, nil
+	}
+	profile := a.findPermissionProfile(name)
+	if profile == nil {
+		return nil, fmt.Errorf("环境 '%s' 引用的权限配置 '%s' 不存在", env.Name, name)
+	}
+	return profile, nil
+}
+
+func normalizePermissionProfile(profile PermissionProfile) PermissionProfile {
+	profile.Name = strings.TrimSpace(profile.Name)
+	profile.Description = strings.TrimSpace(profile.Description)
+	profile.Allow = cleanArgs(profile.Allow)
+	profile.Deny = cleanArgs(profile.Deny)
+	profile.AdditionalDirectories = cleanArgs(profile.AdditionalDirectories)
+	profile.DefaultMode = strings.TrimSpace(profile.DefaultMode)
+	profile.CodexApprovalPolicy = strings.ToLower(strings.TrimSpace(profile.CodexApprovalPolicy))
+	profile.CodexSandboxMode = strings.ToLower(strings.TrimSpace(profile.CodexSandboxMode))
+	return profile
+}
+
+func validatePermissionProfile(profile PermissionProfile) error {
+	if profile.Name == "" {
+		return fmt.Errorf("权限配置名称不能为空")
+	}
+	if profile.DefaultMode != "" && !containsString(claudeDefaultModes, profile.DefaultMode) {
+		return fmt.Errorf("defaultMode 必须是 %s", strings.Join(claudeDefaultModes, "/"))
+	}
+	if profile.CodexApprovalPolicy != "" && !containsString(codexApprovalPolicies, profile.CodexApprovalPolicy) {
+		return fmt.Errorf("approval_policy 必须是 %s", strings.Join(codexApprovalPolicies, "/"))
+	}
+	if profile.CodexSandboxMode != "" && !containsString(codexSandboxModes, profile.CodexSandboxMode) {
+		return fmt.Errorf("sandbox_mode 必须是 %s", strings.Join(codexSandboxModes, "/"))
+	}
+	for _, rule := range append(append([]string{}, profile.Allow...), profile.Deny...) {
+		if strings.Count(rule, "(") != strings.Count(rule, ")") {
+			return fmt.Errorf("权限规则格式不正确：%s", rule)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// claudePermissionValues 生成 settings.json 中需要写入的键（路径 -> 值）；
+// 按 permissions 下的子键写入，保留 ask 等未由权限配置管理的键
+func claudePermissionValues(profile *PermissionProfile) map[string]any {
+	values := map[string]any{
+		"permissions.allow": cleanArgs(profile.Allow),
+		"permissions.deny":  cleanArgs(profile.Deny),
+	}
+	if len(profile.AdditionalDirectories) > 0 {
+		values["permissions.additionalDirectories"] = profile.AdditionalDirectories
+	}
+	if profile.DefaultMode != "" {
+		values["permissions.defaultMode"] = profile.DefaultMode
+	}
+	return values
+}
+
+// codexPermissionValues 生成 config.toml 顶层需要写入的键（[profiles.<env>] 内的键由 writeCodexEnvTables 负责）
+func codexPermissionValues(profile *PermissionProfile) map[string]any {
+	values := map[string]any{}
+	if profile.CodexApprovalPolicy != "" {
+		values["approval_policy"] = profile.CodexApprovalPolicy
+	}
+	if profile.CodexSandboxMode != "" {
+		values["sandbox_mode"] = profile.CodexSandboxMode
+	}
+	if profile.CodexSandboxMode == "workspace-write" && len(profile.AdditionalDirectories) > 0 {
+		values["sandbox_workspace_write.writable_roots"] = profile.AdditionalDirectories
+	}
+	return values
+}
+
+// applyClaudePermissionProfile 将环境的权限配置写入 settings（原地修改）
+func (a *App) applyClaudePermissionProfile(settings map[string]any, env *EnvConfig) error {
+	profile, err := a.resolveEnvPermissionProfile(env)
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		return restorePermissionSnapshot("claude", jsonPermissionTarget(settings))
+	}
+	return applyPermissionValues("claude", jsonPermissionTarget(settings), claudePermissionValues(profile))
+}
+
+// applyCodexPermissionProfile 将环境的权限配置写入 config.toml 内容；只改写涉及的键，保留用户的注释与顺序
+func (a *App) applyCodexPermissionProfile(configData []byte, env *EnvConfig) ([]byte, error) {
+	profile, err := a.resolveEnvPermissionProfile(env)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parseTOMLDocument(configData)
+	if err != nil {
+		if profile != nil {
+			return nil, fmt.Errorf("config.toml 不是有效 TOML，无法应用权限配置: %v", err)
+		}
+		return configData, nil
+	}
+
+	if profile == nil {
+		if err := restorePermissionSnapshot("codex", doc); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := applyPermissionValues("codex", doc, codexPermissionValues(profile)); err != nil {
+			return nil, err
+		}
+	}
+	return doc.Bytes(), nil
+}
+
+// permissionTarget 权限键（点分路径）的读写目标：Claude 的 settings.json 或 Codex 的 config.toml
+type permissionTarget interface {
+	getPath(path string) (any, bool)
+	setPath(path string, value any) error
+	deletePath(path string)
+}
+
+// jsonPermissionTarget settings.json 解析后的对象
+type jsonPermissionTarget map[string]any
+
+func (t jsonPermissionTarget) getPath(path string) (any, bool) { return getMapPath(t, path) }
+
+func (t jsonPermissionTarget) setPath(path string, value any) error {
+	setMapPath(t, path, value)
+	return nil
+}
+
+func (t jsonPermissionTarget) deletePath(path string) { deleteMapPath(t, path) }
+
+func (d *tomlDocument) getPath(path string) (any, bool) { return d.Get(strings.Split(path, ".")) }
+
+func (d *tomlDocument) setPath(path string, value any) error {
+	return d.Set(strings.Split(path, "."), value)
+}
+
+func (d *tomlDocument) deletePath(path string) { d.Delete(strings.Split(path, ".")) }
+
+// applyPermissionValues 写入 values（键为点分路径），并在首次覆盖某个键前记录原值。
+// 上一个权限配置写入、而这次不写的键先还原，避免切换后仍保留上一个配置的权限
+func applyPermissionValues(provider string, target permissionTarget, values map[string]any) error {
+	permissionBackupMu.Lock()
+	defer permissionBackupMu.Unlock()
+
+	store, err := loadPermissionBackupStore()
+	if err != nil {
+		return err
+	}
+	snapshot := store.Providers[provider]
+	if snapshot == nil {
+		snapshot = &permissionSnapshot{Values: map[string]any{}}
+		store.Providers[provider] = snapshot
+	}
+	if snapshot.Values == nil {
+		snapshot.Values = map[string]any{}
+	}
+
+	changed := false
+	for path, value := range snapshot.Values {
+		if _, ok := values[path]; !ok {
+			if err := target.setPath(path, value); err != nil {
+				return err
+			}
+			delete(snapshot.Values, path)
+			changed = true
+		}
+	}
+	absent := snapshot.Absent[:0]
+	for _, path := range snapshot.Absent {
+		if _, ok := values[path]; ok {
+			absent = append(absent, path)
+			continue
+		}
+		target.deletePath(path)
+		changed = true
+	}
+	snapshot.Absent = absent
+
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if _, ok := snapshot.Values[path]; !ok && !containsString(snapshot.Absent, path) {
+			if current, ok := target.getPath(path); ok {
+				snapshot.Values[path] = current
+			} else {
+				snapshot.Absent = append(snapshot.Absent, path)
+			}
+			changed = true
+		}
+		if err := target.setPath(path, values[path]); err != nil {
+			return err
+		}
+	}
+
+	if changed {
+		return savePermissionBackupStore(store)
+	}
+	return nil
+}
+
+// restorePermissionSnapshot 还原首次应用权限配置前的原值，并清除快照
+func restorePermissionSnapshot(provider string, target permissionTarget) error {
+	permissionBackupMu.Lock()
+	defer permissionBackupMu.Unlock()
+
+	store, err := loadPermissionBackupStore()
+	if err != nil {
+		return err
+	}
+	snapshot := store.Providers[provider]
+	if snapshot == nil {
+		return nil
+	}
+
+	for path, value := range snapshot.Values {
+		if err := target.setPath(path, value); err != nil {
+			return err
+		}
+	}
+	for _, path := range snapshot.Absent {
+		target.deletePath(path)
+	}
+
+	delete(store.Providers, provider)
+	return savePermissionBackupStore(store)
+}
+
+func getMapPath(root map[string]any, path string) (any, bool) {
+	parts := strings.Split(path, ".")
+	current := root
+	for i, part := range parts {
+		value, ok := current[part]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		next, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return nil, false
+}
+
+func setMapPath(root map[string]any, path string, value any) {
+	parts := strings.Split(path, ".")
+	current := root
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// deleteMapPath 删除键，并移除因此变空的上级表（如 sandbox_workspace_write）
+func deleteMapPath(root map[string]any, path string) {
+	parts := strings.Split(path, ".")
+	if len(parts) == 1 {
+		delete(root, path)
+		return
+	}
+	next, ok := root[parts[0]].(map[string]any)
+	if !ok {
+		return
+	}
+	deleteMapPath(next, strings.Join(parts[1:], "."))
+	if len(next) == 0 {
+		delete(root, parts[0])
+	}
+}
+
+func loadPermissionBackupStore() (permissionBackupStore, error) {
+	store := permissionBackupStore{}
+	if _, err := loadJSONStore(permissionBackupFile, &store); err != nil {
+		return store, err
+	}
+	if store.Providers == nil {
+		store.Providers = map[string]*permissionSnapshot{}
+	}
+	return store, nil
+}
+
+func savePermissionBackupStore(store permissionBackupStore) error {
+	return saveJSONStore(permissionBackupFile, store, 0o644)
+}
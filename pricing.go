@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pricingStoreFile = "pricing.json"
+	// pricingFileVersion 定价文件格式版本；读到更高版本时只使用内置定价
+	pricingFileVersion = 1
+	// defaultLongContextThreshold 长上下文档位的默认阈值（单次请求的输入 token，含缓存）
+	defaultLongContextThreshold = 200_000
+	pricingCheckGap             = time.Second
+)
+
+// ModelPrice 模型定价（USD / 1M tokens）
+type ModelPrice struct {
+	Input       float64    `json:"input"`
+	Output      float64    `json:"output"`
+	CacheWrite  float64    `json:"cache_write"`
+	CacheRead   float64    `json:"cache_read"`
+	LongContext *PriceTier `json:"long_context,omitempty"`
+}
+
+// PriceTier 单次请求输入超过 Threshold 时整次请求改用的价格
+type PriceTier struct {
+	Threshold  int     `json:"threshold,omitempty"` // 默认 200000
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write"`
+	CacheRead  float64 `json:"cache_read"`
+}
+
+// PricingEntry 定价表中的一项
+type PricingEntry struct {
+	Model  string     `json:"model"`
+	Price  ModelPrice `json:"price"`
+	Source string     `json:"source"` // builtin | imported | user
+}
+
+// PricingTable 当前生效的定价表
+type PricingTable struct {
+	Version   int    `json:"version"`
+	Path      string `json:"path"`
+	LoadError string `json:"load_error,omitempty"`
+	// 最近一次导入的价格目录
+	ImportSource string         `json:"import_source,omitempty"`
+	ImportedAt   string         `json:"imported_at,omitempty"`
+	Models       []PricingEntry `json:"models"`
+}
+
+// pricingFile 用户定价文件，优先级：Models（用户定价）> Imported（导入的价格目录）> 内置定价。
+// 模型名忽略日期或版本后缀，例如 "claude-sonnet-4-5" 同时适用于 "claude-sonnet-4-5-20250929"
+type pricingFile struct {
+	Version      int                   `json:"version"`
+	Models       map[string]ModelPrice `json:"models"`
+	Imported     map[string]ModelPrice `json:"imported,omitempty"`
+	ImportSource string                `json:"import_source,omitempty"`
+	ImportedAt   string                `json:"imported_at,omitempty"`
+}
+
+// Model pricing (USD per 1M tokens)
+// Cache pricing: CacheWrite = 1.25 × Input, CacheRead = 0.1 × Input
+// Reference: https://docs.anthropic.com/en/docs/about-claude/models
+var builtinModelPricing = map[string]ModelPrice{
+	// Claude Opus 4.5 ($5/$25)
+	"claude-opus-4-5": {Input: 5.0, Output: 25.0, CacheWrite: 6.25, CacheRead: 0.50},
+	// Claude Opus 4 / 4.1 ($15/$75)
+	"claude-opus-4":   {Input: 15.0, Output: 75.0, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-opus-4-1": {Input: 15.0, Output: 75.0, CacheWrite: 18.75, CacheRead: 1.50},
+	// Claude 3 Opus ($15/$75)
+	"claude-3-opus": {Input: 15.0, Output: 75.0, CacheWrite: 18.75, CacheRead: 1.50},
+	// Claude Sonnet 4 / 4.5 ($3/$15，1M 上下文超过 200K 输入时 $6/$22.50)
+	"claude-sonnet-4":   {Input: 3.0, Output: 15.0, CacheWrite: 3.75, CacheRead: 0.30, LongContext: &PriceTier{Input: 6.0, Output: 22.5, CacheWrite: 7.5, CacheRead: 0.60}},
+	"claude-sonnet-4-5": {Input: 3.0, Output: 15.0, CacheWrite: 3.75, CacheRead: 0.30, LongContext: &PriceTier{Input: 6.0, Output: 22.5, CacheWrite: 7.5, CacheRead: 0.60}},
+	// Claude Sonnet 3.7 / 3.5 ($3/$15)
+	"claude-3-7-sonnet": {Input: 3.0, Output: 15.0, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-5-sonnet": {Input: 3.0, Output: 15.0, CacheWrite: 3.75, CacheRead: 0.30},
+	// Claude Haiku 4.5 ($1/$5)
+	"claude-haiku-4-5": {Input: 1.0, Output: 5.0, CacheWrite: 1.25, CacheRead: 0.10},
+	// Claude 3.5 Haiku ($0.80/$4)
+	"claude-3-5-haiku": {Input: 0.80, Output: 4.0, CacheWrite: 1.0, CacheRead: 0.08},
+	// Claude 3 Haiku ($0.25/$1.25)
+	"claude-3-haiku": {Input: 0.25, Output: 1.25, CacheWrite: 0.3125, CacheRead: 0.025},
+	// GPT-4 series
+	"gpt-4":       {Input: 30.0, Output: 60.0},
+	"gpt-4-turbo": {Input: 10.0, Output: 30.0},
+	"gpt-4o":      {Input: 2.5, Output: 10.0},
+	"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+	// Gemini series (https://ai.google.dev/gemini-api/docs/pricing)
+	"gemini-2.5-pro":   {Input: 1.25, Output: 10.0, CacheWrite: 0.3125, LongContext: &PriceTier{Input: 2.5, Output: 15.0, CacheWrite: 0.625}},
+	"gemini-2.5-flash": {Input: 0.15, Output: 0.60, CacheWrite: 0.0375},
+	"gemini-2.0-flash": {Input: 0.10, Output: 0.40, CacheWrite: 0.025},
+	"gemini-1.5-pro":   {Input: 1.25, Output: 5.0, CacheWrite: 0.3125},
+	"gemini-1.5-flash": {Input: 0.075, Output: 0.3, CacheWrite: 0.01875},
+	"gemini-3-pro":     {Input: 2.5, Output: 15.0, CacheWrite: 0.625},
+	// OpenAI Codex series (https://developers.openai.com/codex/pricing/)
+	"gpt-5.2-codex":      {Input: 1.75, Output: 14.0, CacheRead: 0.175},
+	"gpt-5.2":            {Input: 1.75, Output: 14.0, CacheRead: 0.175},
+	"gpt-5.1-codex-mini": {Input: 0.30, Output: 1.20, CacheRead: 0.03},
+	"gpt-5.1-codex-max":  {Input: 1.50, Output: 12.0, CacheRead: 0.15},
+	"gpt-5.1-codex":      {Input: 1.50, Output: 12.0, CacheRead: 0.15},
+	"gpt-5.1":            {Input: 1.50, Output: 12.0, CacheRead: 0.15},
+	"gpt-5-codex":        {Input: 1.25, Output: 10.0, CacheRead: 0.125},
+	"gpt-5":              {Input: 1.25, Output: 10.0, CacheRead: 0.125},
+	"codex-1":            {Input: 1.25, Output: 10.0, CacheRead: 0.125},
+}
+
+// modelPricingTable 合并后的定价表，查找时忽略日期或版本后缀
+type modelPricingTable struct {
+	models  map[string]ModelPrice
+	sources map[string]string
+	// layers 按优先级排列的定价来源（用户、导入、内置），lookup 逐层查找
+	layers []pricingLayer
+	file   pricingFile
+	err    error
+}
+
+// pricingLayer 一个来源的定价
+type pricingLayer struct {
+	source string // builtin | imported | user
+	models map[string]ModelPrice
+}
+
+// pricingState 缓存定价表；每条记录都会查价，文件最多每秒检查一次是否变化
+var pricingState struct {
+	mu        sync.Mutex
+	table     *modelPricingTable
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// currentPricing 返回当前生效的定价表
+func currentPricing() *modelPricingTable {
+	s := &pricingState
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.table != nil && now.Sub(s.checkedAt) < pricingCheckGap {
+		return s.table
+	}
+	s.checkedAt = now
+	var modTime time.Time
+	if path, err := storePath(pricingStoreFile); err == nil {
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+	if s.table == nil || !modTime.Equal(s.modTime) {
+		s.table = buildPricingTable()
+		s.modTime = modTime
+	}
+	return s.table
+}
+
+// invalidatePricing 定价文件被本软件修改后立即重新加载
+func invalidatePricing() {
+	pricingState.mu.Lock()
+	pricingState.table = nil
+	pricingState.mu.Unlock()
+}
+
+func buildPricingTable() *modelPricingTable {
+	file, err := loadPricingFile()
+	if err != nil {
+		table := newPricingTable(pricingFile{})
+		table.err = err
+		return table
+	}
+	return newPricingTable(file)
+}
+
+// newPricingTable 按 用户 > 导入 > 内置 的优先级合并定价
+func newPricingTable(file pricingFile) *modelPricingTable {
+	table := &modelPricingTable{
+		models:  map[string]ModelPrice{},
+		sources: map[string]string{},
+		layers: []pricingLayer{
+			{source: "user", models: file.Models},
+			{source: "imported", models: file.Imported},
+			{source: "builtin", models: builtinModelPricing},
+		},
+		file: file,
+	}
+	for i := len(table.layers) - 1; i >= 0; i-- {
+		layer := table.layers[i]
+		for name, price := range layer.models {
+			table.models[name] = price
+			table.sources[name] = layer.source
+		}
+	}
+	return table
+}
+
+// pricingVersionSuffix 可忽略的日期或版本后缀：-20250514、-2024-08-06、-0613、-001、-v1、-latest
+var pricingVersionSuffix = regexp.MustCompile(`-(\d{8}|\d{4}-\d{2}-\d{2}|\d{3,4}|v\d+|latest)$`)
+
+// lookup 查找模型定价：先精确匹配，再逐次去掉日期或版本后缀（@…、:…、-YYYYMMDD 等）后查表。
+// 其他后缀（如 gpt-5-mini 的 -mini）不回退到基础模型的价格，按未定价处理。
+// 来源优先于匹配精度：用户定价的 "claude-sonnet-4-5" 也覆盖导入目录中的 "claude-sonnet-4-5-20250929"
+func (t *modelPricingTable) lookup(model string) (ModelPrice, bool) {
+	price, _, ok := t.lookupSource(model)
+	return price, ok
+}
+
+// lookupSource 同 lookup，并返回命中的来源（builtin | imported | user）
+func (t *modelPricingTable) lookupSource(model string) (ModelPrice, string, bool) {
+	names := pricingLookupNames(model)
+	for _, layer := range t.layers {
+		for _, name := range names {
+			if price, ok := layer.models[name]; ok {
+				return price, layer.source, true
+			}
+		}
+	}
+	return ModelPrice{}, "", false
+}
+
+// pricingLookupNames 按匹配精度排列的查表名称：各候选名称及其逐次去掉后缀的结果
+func pricingLookupNames(model string) []string {
+	var names []string
+	for _, name := range pricingModelCandidates(model) {
+		for name != "" {
+			names = append(names, name)
+			trimmed := trimPricingVersion(name)
+			if trimmed == name {
+				break
+			}
+			name = trimmed
+		}
+	}
+	return names
+}
+
+// trimPricingVersion 去掉模型名最后一段日期或版本后缀；没有时原样返回
+func trimPricingVersion(name string) string {
+	if i := strings.LastIndexAny(name, "@:"); i > 0 {
+		return name[:i]
+	}
+	if loc := pricingVersionSuffix.FindStringIndex(name); loc != nil {
+		return name[:loc[0]]
+	}
+	return name
+}
+
+// pricingModelCandidates 模型名及去掉渠道前缀后的名称，
+// 如 "anthropic/claude-sonnet-4-5"、"us.anthropic.claude-sonnet-4-5-20250929-v1:0"
+func pricingModelCandidates(model string) []string {
+	name := strings.ToLower(strings.TrimSpace(model))
+	if name == "" {
+		return nil
+	}
+	candidates := []string{name}
+	bare := name
+	if idx := strings.LastIndex(bare, "/"); idx >= 0 {
+		bare = bare[idx+1:]
+	}
+	if idx := strings.LastIndex(bare, "anthropic."); idx >= 0 {
+		bare = bare[idx+len("anthropic."):]
+	}
+	if bare != name && bare != "" {
+		candidates = append(candidates, bare)
+	}
+	return candidates
+}
+
+func (t *PriceTier) threshold() int {
+	if t.Threshold > 0 {
+		return t.Threshold
+	}
+	return defaultLongContextThreshold
+}
+
+// longContext 单次请求是否适用长上下文价格
+func (p ModelPrice) longContext(inputTokens, cacheWriteTokens, cacheReadTokens int) bool {
+	return p.LongContext != nil && inputTokens+cacheWriteTokens+cacheReadTokens > p.LongContext.threshold()
+}
+
+// cost 计算成本 (价格是每百万 token)
+func (p ModelPrice) cost(inputTokens, outputTokens, cacheWriteTokens, cacheReadTokens int, longContext bool) float64 {
+	input, output, cacheWrite, cacheRead := p.Input, p.Output, p.CacheWrite, p.CacheRead
+	if longContext && p.LongContext != nil {
+		tier := p.LongContext
+		input, output, cacheWrite, cacheRead = tier.Input, tier.Output, tier.CacheWrite, tier.CacheRead
+	}
+	return (float64(inputTokens)*input +
+		float64(outputTokens)*output +
+		float64(cacheWriteTokens)*cacheWrite +
+		float64(cacheReadTokens)*cacheRead) / 1_000_000
+}
+
+// GetPricing 获取当前生效的定价表（内置、导入与用户定价合并）
+func (ls *LogService) GetPricing() (PricingTable, error) {
+	table := currentPricing()
+	result := PricingTable{Version: pricingFileVersion, Models: []PricingEntry{}}
+	if path, err := storePath(pricingStoreFile); err == nil {
+		result.Path = path
+	}
+	if table.err != nil {
+		result.LoadError = table.err.Error()
+	}
+	result.ImportSource = table.file.ImportSource
+	result.ImportedAt = table.file.ImportedAt
+	for name, price := range table.models {
+		result.Models = append(result.Models, PricingEntry{Model: name, Price: price, Source: table.sources[name]})
+	}
+	sort.Slice(result.Models, func(i, j int) bool {
+		return result.Models[i].Model < result.Models[j].Model
+	})
+	return result, nil
+}
+
+// SaveModelPrice 保存用户定价（覆盖同名的导入与内置定价）
+func (ls *LogService) SaveModelPrice(model string, price ModelPrice) error {
+	name := strings.ToLower(strings.TrimSpace(model))
+	if name == "" {
+		return fmt.Errorf("模型名不能为空")
+	}
+	if err := validateModelPrice(price); err != nil {
+		return fmt.Errorf("%s 定价无效: %v", name, err)
+	}
+	file, err := loadPricingFile()
+	if err != nil {
+		return err
+	}
+	file.Models[name] = price
+	return savePricingFile(file)
+}
+
+// DeleteModelPrice 删除用户定价；同名的导入或内置定价重新生效
+func (ls *LogService) DeleteModelPrice(model string) error {
+	name := strings.ToLower(strings.TrimSpace(model))
+	file, err := loadPricingFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := file.Models[name]; !ok {
+		return fmt.Errorf("未找到用户定价: %s", name)
+	}
+	delete(file.Models, name)
+	return savePricingFile(file)
+}
+
+func validateModelPrice(price ModelPrice) error {
+	values := []float64{price.Input, price.Output, price.CacheWrite, price.CacheRead}
+	if tier := price.LongContext; tier != nil {
+		if tier.Threshold < 0 {
+			return fmt.Errorf("长上下文阈值不能为负数")
+		}
+		values = append(values, tier.Input, tier.Output, tier.CacheWrite, tier.CacheRead)
+	}
+	for _, value := range values {
+		if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("价格必须是非负数")
+		}
+	}
+	return nil
+}
+
+// loadPricingFile 读取用户定价文件；文件格式错误时返回错误，避免保存时覆盖用户的手工修改
+func loadPricingFile() (pricingFile, error) {
+	file := pricingFile{Version: pricingFileVersion, Models: map[string]ModelPrice{}}
+	var loaded pricingFile
+	if _, err := loadJSONStore(pricingStoreFile, &loaded); err != nil {
+		return file, err
+	}
+	if loaded.Version > pricingFileVersion {
+		return file, fmt.Errorf("定价文件版本 %d 高于当前支持的版本 %d", loaded.Version, pricingFileVersion)
+	}
+	for name, price := range loaded.Models {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if err := validateModelPrice(price); err != nil {
+			return file, fmt.Errorf("定价文件中 %s 的定价无效: %v", name, err)
+		}
+		file.Models[name] = price
+	}
+	// 导入的条目已在导入时校验，这里只跳过无效项
+	if len(loaded.Imported) > 0 {
+		file.Imported = map[string]ModelPrice{}
+		for name, price := range loaded.Imported {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && validateModelPrice(price) == nil {
+				file.Imported[name] = price
+			}
+		}
+		file.ImportSource = loaded.ImportSource
+		file.ImportedAt = loaded.ImportedAt
+	}
+	return file, nil
+}
+
+func savePricingFile(file pricingFile) error {
+	file.Version = pricingFileVersion
+	if file.Models == nil {
+		file.Models = map[string]ModelPrice{}
+	}
+	if err := saveJSONStore(pricingStoreFile, file, 0o644); err != nil {
+		return fmt.Errorf("保存定价文件失败: %v", err)
+	}
+	invalidatePricing()
+	return nil
+}
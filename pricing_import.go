@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// litellmPrice LiteLLM model_prices_and_context_window.json 中的一项（价格单位：USD / token）
+type litellmPrice struct {
+	Mode           string   `json:"mode"`
+	Input          *float64 `json:"input_cost_per_token"`
+	Output         *float64 `json:"output_cost_per_token"`
+	CacheRead      *float64 `json:"cache_read_input_token_cost"`
+	CacheWrite     *float64 `json:"cache_creation_input_token_cost"`
+	Input200K      *float64 `json:"input_cost_per_token_above_200k_tokens"`
+	Output200K     *float64 `json:"output_cost_per_token_above_200k_tokens"`
+	CacheRead200K  *float64 `json:"cache_read_input_token_cost_above_200k_tokens"`
+	CacheWrite200K *float64 `json:"cache_creation_input_token_cost_above_200k_tokens"`
+	Input128K      *float64 `json:"input_cost_per_token_above_128k_tokens"`
+	Output128K     *float64 `json:"output_cost_per_token_above_128k_tokens"`
+}
+
+// PricingChange 导入前后某个模型的价格变化
+type PricingChange struct {
+	Model     string      `json:"model"`
+	Old       *ModelPrice `json:"old,omitempty"`
+	New       *ModelPrice `json:"new,omitempty"`
+	OldSource string      `json:"old_source,omitempty"` // builtin | imported | user
+	// Overridden 用户定价（含忽略后缀后匹配的）优先生效，导入的价格不会生效
+	Overridden bool `json:"overridden,omitempty"`
+}
+
+// PricingImportResult 价格目录导入结果（预览时 Applied 为 false）
+type PricingImportResult struct {
+	Source    string          `json:"source"`
+	Models    int             `json:"models"`  // 目录中可导入的模型数
+	Skipped   int             `json:"skipped"` // 非对话模型或未按 token 计价的条目
+	Added     []PricingChange `json:"added"`
+	Changed   []PricingChange `json:"changed"`
+	Removed   []PricingChange `json:"removed"` // 上次导入、本次目录中已没有的模型
+	Unchanged int             `json:"unchanged"`
+	Applied   bool            `json:"applied"`
+}
+
+// SelectPricingCatalog 选择本地的价格目录文件
+func (ls *LogService) SelectPricingCatalog() (string, error) {
+	if ls.ctx == nil {
+		return "", fmt.Errorf("应用尚未启动")
+	}
+	path, err := wailsruntime.OpenFileDialog(ls.ctx, wailsruntime.OpenDialogOptions{
+		Title: "导入价格目录 (model_prices_and_context_window.json)",
+		Filters: []wailsruntime.FileFilter{
+			{DisplayName: "JSON 文件", Pattern: "*.json"},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("打开对话框失败: %v", err)
+	}
+	return path, nil
+}
+
+// PreviewPricingImport 预览导入价格目录后的价格变化，不修改定价文件
+func (ls *LogService) PreviewPricingImport(path string) (PricingImportResult, error) {
+	result, _, _, err := preparePricingImport(path)
+	return result, err
+}
+
+// ImportPricingCatalog 导入 LiteLLM 格式的价格目录，替换上次导入的价格；用户定价不受影响且优先生效
+func (ls *LogService) ImportPricingCatalog(path string) (PricingImportResult, error) {
+	result, prices, file, err := preparePricingImport(path)
+	if err != nil {
+		return result, err
+	}
+	file.Imported = prices
+	file.ImportSource = result.Source
+	file.ImportedAt = time.Now().Format(time.RFC3339)
+	if err := savePricingFile(file); err != nil {
+		return result, err
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// ClearImportedPricing 删除导入的价格，恢复为内置定价与用户定价
+func (ls *LogService) ClearImportedPricing() error {
+	file, err := loadPricingFile()
+	if err != nil {
+		return err
+	}
+	file.Imported = nil
+	file.ImportSource = ""
+	file.ImportedAt = ""
+	return savePricingFile(file)
+}
+
+// preparePricingImport 解析价格目录并与当前定价比较
+func preparePricingImport(path string) (PricingImportResult, map[string]ModelPrice, pricingFile, error) {
+	result := PricingImportResult{Added: []PricingChange{}, Changed: []PricingChange{}, Removed: []PricingChange{}}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return result, nil, pricingFile{}, fmt.Errorf("请选择价格目录文件")
+	}
+	result.Source = filepath.Base(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result, nil, pricingFile{}, fmt.Errorf("读取价格目录失败: %v", err)
+	}
+	prices, skipped, err := parseLiteLLMCatalog(data)
+	if err != nil {
+		return result, nil, pricingFile{}, err
+	}
+	if len(prices) == 0 {
+		return result, nil, pricingFile{}, fmt.Errorf("价格目录中没有可导入的模型")
+	}
+	result.Models = len(prices)
+	result.Skipped = skipped
+
+	file, err := loadPricingFile()
+	if err != nil {
+		return result, nil, file, err
+	}
+
+	// 与导入前后实际生效的价格比较，查找规则同 lookup
+	current := currentPricing()
+	next := file
+	next.Imported = prices
+	after := newPricingTable(next)
+	for name, price := range prices {
+		price := price
+		change := PricingChange{Model: name, New: &price}
+		if previous, source, ok := current.lookupSource(name); ok {
+			change.Old, change.OldSource = &previous, source
+		}
+		_, source, _ := after.lookupSource(name)
+		change.Overridden = source == "user"
+		switch {
+		case change.Old == nil:
+			result.Added = append(result.Added, change)
+		case reflect.DeepEqual(*change.Old, price):
+			result.Unchanged++
+		default:
+			result.Changed = append(result.Changed, change)
+		}
+	}
+	for name, previous := range file.Imported {
+		if _, ok := prices[name]; ok {
+			continue
+		}
+		change := PricingChange{Model: name, Old: &previous, OldSource: "imported"}
+		if effective, source, ok := current.lookupSource(name); ok {
+			change.Old, change.OldSource = &effective, source
+		}
+		if fallback, source, ok := after.lookupSource(name); ok {
+			change.New = &fallback
+			change.Overridden = source == "user"
+		}
+		result.Removed = append(result.Removed, change)
+	}
+	for _, list := range [][]PricingChange{result.Added, result.Changed, result.Removed} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Model < list[j].Model })
+	}
+	return result, prices, file, nil
+}
+
+// parseLiteLLMCatalog 解析价格目录，返回 模型名 -> 定价（USD / 1M tokens）与跳过的条目数
+func parseLiteLLMCatalog(data []byte) (map[string]ModelPrice, int, error) {
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, 0, fmt.Errorf("解析价格目录失败: %v", err)
+	}
+	prices := map[string]ModelPrice{}
+	skipped := 0
+	for name, raw := range entries {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "sample_spec" {
+			continue
+		}
+		var entry litellmPrice
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			skipped++
+			continue
+		}
+		price, ok := entry.modelPrice()
+		if !ok || validateModelPrice(price) != nil {
+			skipped++
+			continue
+		}
+		prices[name] = price
+	}
+	return prices, skipped, nil
+}
+
+// modelPrice 转换为本软件的定价；只导入对话类模型
+func (e litellmPrice) modelPrice() (ModelPrice, bool) {
+	switch e.Mode {
+	case "", "chat", "completion", "responses":
+	default:
+		return ModelPrice{}, false
+	}
+	if e.Input == nil && e.Output == nil {
+		return ModelPrice{}, false
+	}
+	price := ModelPrice{
+		Input:      perMillionTokens(e.Input, 0),
+		Output:     perMillionTokens(e.Output, 0),
+		CacheWrite: perMillionTokens(e.CacheWrite, 0),
+		CacheRead:  perMillionTokens(e.CacheRead, 0),
+	}
+	// 长上下文档位中未给出的价格沿用基础价格
+	switch {
+	case e.Input200K != nil || e.Output200K != nil:
+		price.LongContext = &PriceTier{
+			Input:      perMillionTokens(e.Input200K, price.Input),
+			Output:     perMillionTokens(e.Output200K, price.Output),
+			CacheWrite: perMillionTokens(e.CacheWrite200K, price.CacheWrite),
+			CacheRead:  perMillionTokens(e.CacheRead200K, price.CacheRead),
+		}
+	case e.Input128K != nil || e.Output128K != nil:
+		price.LongContext = &PriceTier{
+			Threshold:  128_000,
+			Input:      perMillionTokens(e.Input128K, price.Input),
+			Output:     perMillionTokens(e.Output128K, price.Output),
+			CacheWrite: price.CacheWrite,
+			CacheRead:  price.CacheRead,
+		}
+	}
+	return price, true
+}
+
+// perMillionTokens 每 token 价格换算为每百万 token，去掉浮点误差
+func perMillionTokens(perToken *float64, fallback float64) float64 {
+	if perToken == nil {
+		return fallback
+	}
+	return math.Round(*perToken*1e12) / 1e6
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPricingLookupPrefersSourceOverMatchPrecision(t *testing.T) {
+	table := newPricingTable(pricingFile{
+		Models: map[string]ModelPrice{"claude-sonnet-4-5": {Input: 1, Output: 1}},
+		Imported: map[string]ModelPrice{
+			"claude-sonnet-4-5-20250929": {Input: 2, Output: 2},
+			"gpt-5-mini":                 {Input: 3, Output: 3},
+		},
+	})
+	tests := []struct {
+		model      string
+		wantInput  float64
+		wantSource string
+	}{
+		// 用户定价忽略后缀后匹配，也优先于导入目录中的精确匹配
+		{"claude-sonnet-4-5-20250929", 1, "user"},
+		{"anthropic/claude-sonnet-4-5-20250929", 1, "user"},
+		{"gpt-5-mini", 3, "imported"},
+		{"gpt-5-2025-08-07", 1.25, "builtin"},
+		{"claude-opus-4-5@20251101", 5, "builtin"},
+	}
+	for _, tt := range tests {
+		price, source, ok := table.lookupSource(tt.model)
+		if !ok || price.Input != tt.wantInput || source != tt.wantSource {
+			t.Errorf("lookupSource(%q) = %v, %q, %v; want input %v from %s", tt.model, price.Input, source, ok, tt.wantInput, tt.wantSource)
+		}
+	}
+	if _, ok := table.lookup("gpt-5-nano"); ok {
+		t.Error("gpt-5-nano should not fall back to gpt-5")
+	}
+}
+
+func TestPreparePricingImportComparesEffectivePrices(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	invalidatePricing()
+	t.Cleanup(invalidatePricing)
+
+	err := savePricingFile(pricingFile{
+		Models:   map[string]ModelPrice{"claude-sonnet-4-5": {Input: 1, Output: 1}},
+		Imported: map[string]ModelPrice{"old-model": {Input: 7, Output: 7}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog := filepath.Join(home, "prices.json")
+	data := `{
+		"claude-sonnet-4-5-20250929": {"mode": "chat", "input_cost_per_token": 0.000003, "output_cost_per_token": 0.000015},
+		"gpt-5-2025-08-07": {"mode": "chat", "input_cost_per_token": 0.00000125, "output_cost_per_token": 0.00001, "cache_read_input_token_cost": 0.000000125},
+		"new-model": {"mode": "chat", "input_cost_per_token": 0.000001, "output_cost_per_token": 0.000002}
+	}`
+	if err := os.WriteFile(catalog, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, _, err := preparePricingImport(catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gpt-5-2025-08-07 与当前生效的内置 gpt-5 价格相同
+	if result.Unchanged != 1 {
+		t.Errorf("unchanged = %d, want 1", result.Unchanged)
+	}
+	if len(result.Added) != 1 || result.Added[0].Model != "new-model" || result.Added[0].Overridden {
+		t.Errorf("added = %+v", result.Added)
+	}
+	// 当前生效的是忽略后缀后匹配的用户定价，导入后仍被覆盖
+	if len(result.Changed) != 1 {
+		t.Fatalf("changed = %+v", result.Changed)
+	}
+	if change := result.Changed[0]; change.OldSource != "user" || change.Old.Input != 1 || !change.Overridden {
+		t.Errorf("changed = %+v", change)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Model != "old-model" || result.Removed[0].New != nil {
+		t.Errorf("removed = %+v", result.Removed)
+	}
+}
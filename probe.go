@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 探测结果状态
+const (
+	probeOK            = "ok"
+	probeAuthError     = "auth_error"      // 401 / 403：密钥错误或无权限
+	probeModelNotFound = "model_not_found" // 模型不存在或不可用
+	probeRateLimited   = "rate_limited"    // 429 / 529：限流或过载
+	probeNotFound      = "not_found"       // 接口路径不存在（Base URL 可能有误）
+	probeBadRequest    = "bad_request"     // 其他 4xx
+	probeServerError   = "server_error"    // 5xx
+	probeNetworkError  = "network_error"   // 连接失败 / 超时
+	probeConfigError   = "config_error"    // 环境缺少必要配置
+	probeUnsupported   = "unsupported"     // 该后端 / 认证方式不支持探测
+)
+
+const (
+	probeModeGenerate   = "generate" // 发送最小生成请求
+	probeModeModels     = "models"   // 请求模型列表
+	probeDefaultTimeout = 15 * time.Second
+	probeBodyLimit      = 64 * 1024
+)
+
+// 各 Provider 的默认 Base URL 与探测模型
+const (
+	anthropicDefaultBaseURL = "https://api.anthropic.com"
+	openaiDefaultBaseURL    = "https://api.openai.com/v1"
+	geminiDefaultBaseURL    = "https://generativelanguage.googleapis.com"
+	anthropicProbeModel     = "claude-haiku-4-5-20251001"
+	anthropicAPIVersion     = "2023-06-01"
+)
+
+// ProbeResult 环境认证探测结果
+type ProbeResult struct {
+	EnvName    string `json:"env_name"`
+	Provider   string `json:"provider"`
+	Mode       string `json:"mode"`     // generate | models
+	Kind       string `json:"kind"`     // anthropic-messages / openai-responses / openai-chat / gemini-generate / *-models
+	Endpoint   string `json:"endpoint"` // 实际请求的 URL
+	Model      string `json:"model,omitempty"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	RetryAfter string `json:"retry_after,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// envProber 按 Provider 构造认证请求；client 与 baseURL 可替换，便于对接本地 httptest 服务
+type envProber struct {
+	client  *http.Client
+	baseURL string // 非空时覆盖环境的 Base URL
+}
+
+func newEnvProber(client *http.Client) *envProber {
+	if client == nil {
+		client = &http.Client{Timeout: probeDefaultTimeout}
+	}
+	return &envProber{client: client}
+}
+
+// ProbeEnv 使用环境的密钥与模型发送最小认证请求；mode 为 generate（默认）或 models
+func (a *App) ProbeEnv(envName, mode string) (ProbeResult, error) {
+	env := a.findEnv(envName)
+	if env == nil {
+		return ProbeResult{}, fmt.Errorf("环境 '%s' 不存在", envName)
+	}
+	return newEnvProber(newProxyClient(a.effectiveProxy(env), probeDefaultTimeout)).probe(*env, mode), nil
+}
+
+// probeRequest 单个探测请求
+type probeRequest struct {
+	kind    string
+	method  string
+	url     string
+	headers map[string]string
+	body    any
+	model   string
+}
+
+func (p *envProber) probe(env EnvConfig, mode string) ProbeResult {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode != probeModeModels {
+		mode = probeModeGenerate
+	}
+	provider := strings.ToLower(strings.TrimSpace(env.Provider))
+	if provider == "" {
+		provider = "claude"
+	}
+	result := ProbeResult{EnvName: env.Name, Provider: provider, Mode: mode}
+
+	req, status, message := p.buildRequest(env, provider, mode)
+	if status != "" {
+		result.Status = status
+		result.Message = message
+		return result
+	}
+	result.Kind = req.kind
+	result.Endpoint = req.url
+	result.Model = req.model
+
+	resp, err := p.send(req, probeBodyLimit)
+	result.LatencyMs = resp.latency.Milliseconds()
+	if err != nil {
+		result.Status = probeNetworkError
+		result.Message = err.Error()
+		return result
+	}
+	result.StatusCode = resp.statusCode
+	result.RetryAfter = resp.header.Get("Retry-After")
+	result.Status, result.Message = classifyProbeResponse(resp.statusCode, resp.body, req.model != "")
+	return result
+}
+
+// probeResponse 探测请求的原始响应
+type probeResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	latency    time.Duration
+}
+
+// send 发送探测请求并读取最多 limit 字节的响应体
+func (p *envProber) send(req probeRequest, limit int64) (probeResponse, error) {
+	var body io.Reader
+	if req.body != nil {
+		payload, err := json.Marshal(req.body)
+		if err != nil {
+			return probeResponse{}, err
+		}
+		body = bytes.NewReader(payload)
+	}
+	httpReq, err := http.NewRequest(req.method, req.url, body)
+	if err != nil {
+		return probeResponse{}, err
+	}
+	if req.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range req.headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return probeResponse{latency: time.Since(start)}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	return probeResponse{statusCode: resp.StatusCode, header: resp.Header, body: data, latency: time.Since(start)}, err
+}
+
+// buildRequest 按 Provider 与认证方式生成探测请求；无法探测时返回状态与原因
+func (p *envProber) buildRequest(env EnvConfig, provider, mode string) (probeRequest, string, string) {
+	vars := env.Variables
+	get := func(key string) string { return strings.TrimSpace(vars[key]) }
+	base := func(fallback string) string {
+		return strings.TrimRight(firstNonEmpty(p.baseURL, fallback), "/")
+	}
+
+	switch provider {
+	case "claude":
+		switch normalizeClaudeBackend(env.Backend) {
+		case claudeBackendBedrock, claudeBackendVertex:
+			return probeRequest{}, probeUnsupported, "Bedrock / Vertex 后端需要云厂商签名，暂不支持认证探测"
+		}
+		headers := map[string]string{"anthropic-version": anthropicAPIVersion}
+		if key := get("ANTHROPIC_API_KEY"); key != "" {
+			headers["x-api-key"] = key
+		} else if token := get("ANTHROPIC_AUTH_TOKEN"); token != "" {
+			headers["Authorization"] = "Bearer " + token
+		} else {
+			return probeRequest{}, probeConfigError, "缺少 ANTHROPIC_API_KEY 或 ANTHROPIC_AUTH_TOKEN"
+		}
+		root := base(firstNonEmpty(claudeBackendURL(env), anthropicDefaultBaseURL))
+		if mode == probeModeModels {
+			return probeRequest{kind: "anthropic-models", method: http.MethodGet, url: root + "/v1/models", headers: headers}, "", ""
+		}
+		model := get("ANTHROPIC_MODEL")
+		if env.ModelMapping != nil && strings.TrimSpace(env.ModelMapping.Model) != "" {
+			model = strings.TrimSpace(env.ModelMapping.Model)
+		}
+		if model == "" {
+			model = anthropicProbeModel
+		}
+		return probeRequest{
+			kind:    "anthropic-messages",
+			method:  http.MethodPost,
+			url:     root + "/v1/messages",
+			headers: headers,
+			model:   model,
+			body: map[string]any{
+				"model":      model,
+				"max_tokens": 1,
+				"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+			},
+		}, "", ""
+
+	case "codex":
+		if normalizeCodexAuthMode(env.AuthMode) == codexAuthChatGPT {
+			return probeRequest{}, probeUnsupported, "ChatGPT 登录模式不使用 API Key，无法探测"
+		}
+		key := get("OPENAI_API_KEY")
+		if key == "" {
+			return probeRequest{}, probeConfigError, "缺少 OPENAI_API_KEY"
+		}
+		root := base(firstNonEmpty(get("base_url"), openaiDefaultBaseURL))
+		return openAIProbeRequest(root, key, get("model"), !strings.EqualFold(get("wire_api"), "chat"), mode)
+
+	case "gemini":
+		if normalizeGeminiAuthMode(env.AuthMode) != geminiAuthAPIKey {
+			return probeRequest{}, probeUnsupported, "仅 API Key 模式支持认证探测"
+		}
+		key := get("GEMINI_API_KEY")
+		if key == "" {
+			return probeRequest{}, probeConfigError, "缺少 GEMINI_API_KEY"
+		}
+		root := base(firstNonEmpty(get("GOOGLE_GEMINI_BASE_URL"), geminiDefaultBaseURL))
+		headers := map[string]string{"x-goog-api-key": key}
+		if mode == probeModeModels {
+			return probeRequest{kind: "gemini-models", method: http.MethodGet, url: root + "/v1beta/models", headers: headers}, "", ""
+		}
+		model := strings.TrimPrefix(get("GEMINI_MODEL"), "models/")
+		if model == "" {
+			return probeRequest{}, probeConfigError, "缺少 GEMINI_MODEL"
+		}
+		return probeRequest{
+			kind:    "gemini-generate",
+			method:  http.MethodPost,
+			url:     root + "/v1beta/models/" + url.PathEscape(model) + ":generateContent",
+			headers: headers,
+			model:   model,
+			body: map[string]any{
+				"contents":         []map[string]any{{"parts": []map[string]string{{"text": "ping"}}}},
+				"generationConfig": map[string]any{"maxOutputTokens": 1},
+			},
+		}, "", ""
+
+	case "openclaw":
+		gateway := get("OPENCLAW_GATEWAY_BASE_URL")
+		if gateway == "" && p.baseURL == "" {
+			return probeRequest{}, probeConfigError, "缺少 OPENCLAW_GATEWAY_BASE_URL"
+		}
+		key := firstNonEmpty(get("OPENCLAW_GATEWAY_TOKEN"), get("OPENAI_API_KEY"))
+		return openAIProbeRequest(base(gateway), key, get("OPENCLAW_PRIMARY_MODEL"), false, mode)
+
+	default:
+		return probeRequest{}, probeUnsupported, fmt.Sprintf("未知的 Provider: %s", provider)
+	}
+}
+
+// openAIProbeRequest OpenAI 兼容接口的探测请求（Responses 或 Chat Completions）
+func openAIProbeRequest(root, key, model string, responses bool, mode string) (probeRequest, string, string) {
+	headers := map[string]string{}
+	if key != "" {
+		headers["Authorization"] = "Bearer " + key
+	}
+	if mode == probeModeModels {
+		return probeRequest{kind: "openai-models", method: http.MethodGet, url: root + "/models", headers: headers}, "", ""
+	}
+	if model == "" {
+		return probeRequest{}, probeConfigError, "缺少模型配置"
+	}
+	if responses {
+		return probeRequest{
+			kind:    "openai-responses",
+			method:  http.MethodPost,
+			url:     root + "/responses",
+			headers: headers,
+			model:   model,
+			// Responses API 的 max_output_tokens 最小为 16
+			body: map[string]any{"model": model, "input": "ping", "max_output_tokens": 16},
+		}, "", ""
+	}
+	return probeRequest{
+		kind:    "openai-chat",
+		method:  http.MethodPost,
+		url:     root + "/chat/completions",
+		headers: headers,
+		model:   model,
+		body: map[string]any{
+			"model":      model,
+			"max_tokens": 1,
+			"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+		},
+	}, "", ""
+}
+
+// classifyProbeResponse 将 HTTP 响应归类为探测状态，并提取错误信息
+func classifyProbeResponse(statusCode int, body []byte, withModel bool) (string, string) {
+	message := probeErrorMessage(body)
+	lower := strings.ToLower(message)
+	// Anthropic 的模型不存在为 404 not_found_error，message 形如 "model: <name>"
+	mentionsModel := strings.HasPrefix(lower, "model:") || strings.Contains(lower, "model") &&
+		(strings.Contains(lower, "not found") || strings.Contains(lower, "not_found") ||
+			strings.Contains(lower, "does not exist") || strings.Contains(lower, "not exist") ||
+			strings.Contains(lower, "invalid model") || strings.Contains(lower, "not supported") ||
+			strings.Contains(lower, "unknown model") || strings.Contains(lower, "no available"))
+
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return probeOK, ""
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return probeAuthError, message
+	case statusCode == http.StatusTooManyRequests || statusCode == 529:
+		return probeRateLimited, message
+	case withModel && mentionsModel && statusCode >= 400 && statusCode < 500:
+		return probeModelNotFound, message
+	case statusCode == http.StatusNotFound:
+		return probeNotFound, message
+	case statusCode >= 500:
+		if withModel && mentionsModel {
+			return probeModelNotFound, message
+		}
+		return probeServerError, message
+	default:
+		return probeBadRequest, message
+	}
+}
+
+// probeErrorMessage 提取常见错误格式中的 message（Anthropic / OpenAI / Gemini）
+func probeErrorMessage(body []byte) string {
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if errObj, ok := payload["error"].(map[string]any); ok {
+			if msg, ok := errObj["message"].(string); ok && msg != "" {
+				return msg
+			}
+		}
+		if msg, ok := payload["error"].(string); ok && msg != "" {
+			return msg
+		}
+		if msg, ok := payload["message"].(string); ok && msg != "" {
+			return msg
+		}
+	}
+	text := strings.TrimSpace(string(body))
+	if len(text) > 300 {
+		text = text[:300] + "..."
+	}
+	return text
+}
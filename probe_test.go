@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// probeStubResponse 本地桩服务返回的响应
+type probeStubResponse struct {
+	status     int
+	body       string
+	retryAfter string
+}
+
+func TestEnvProberAgainstStub(t *testing.T) {
+	kinds := []struct {
+		name       string
+		env        EnvConfig
+		kind       string
+		path       string
+		authHeader string
+		authValue  string
+		modelError probeStubResponse
+	}{
+		{
+			name: "anthropic",
+			env: EnvConfig{Name: "claude-env", Provider: "claude", Variables: map[string]string{
+				"ANTHROPIC_API_KEY": "sk-ant-test",
+				"ANTHROPIC_MODEL":   "claude-test",
+			}},
+			kind:       "anthropic-messages",
+			path:       "/v1/messages",
+			authHeader: "x-api-key",
+			authValue:  "sk-ant-test",
+			modelError: probeStubResponse{status: http.StatusNotFound, body: `{"type":"error","error":{"type":"not_found_error","message":"model: claude-test"}}`},
+		},
+		{
+			name: "openai-responses",
+			env: EnvConfig{Name: "codex-env", Provider: "codex", Variables: map[string]string{
+				"OPENAI_API_KEY": "sk-test",
+				"model":          "gpt-test",
+			}},
+			kind:       "openai-responses",
+			path:       "/responses",
+			authHeader: "Authorization",
+			authValue:  "Bearer sk-test",
+			modelError: probeStubResponse{status: http.StatusBadRequest, body: `{"error":{"message":"The model 'gpt-test' does not exist or you do not have access to it.","code":"model_not_found"}}`},
+		},
+		{
+			name: "openai-chat",
+			env: EnvConfig{Name: "codex-chat-env", Provider: "codex", Variables: map[string]string{
+				"OPENAI_API_KEY": "sk-test",
+				"model":          "gpt-test",
+				"wire_api":       "chat",
+			}},
+			kind:       "openai-chat",
+			path:       "/chat/completions",
+			authHeader: "Authorization",
+			authValue:  "Bearer sk-test",
+			modelError: probeStubResponse{status: http.StatusNotFound, body: `{"error":{"message":"The model 'gpt-test' does not exist","code":"model_not_found"}}`},
+		},
+		{
+			name: "gemini",
+			env: EnvConfig{Name: "gemini-env", Provider: "gemini", Variables: map[string]string{
+				"GEMINI_API_KEY": "gm-test",
+				"GEMINI_MODEL":   "gemini-test",
+			}},
+			kind:       "gemini-generate",
+			path:       "/v1beta/models/gemini-test:generateContent",
+			authHeader: "x-goog-api-key",
+			authValue:  "gm-test",
+			modelError: probeStubResponse{status: http.StatusNotFound, body: `{"error":{"code":404,"message":"models/gemini-test is not found for API version v1beta","status":"NOT_FOUND"}}`},
+		},
+	}
+
+	for _, kind := range kinds {
+		scenarios := []struct {
+			name       string
+			response   probeStubResponse
+			status     string
+			retryAfter string
+		}{
+			{"auth error", probeStubResponse{status: http.StatusUnauthorized, body: `{"error":{"message":"invalid api key"}}`}, probeAuthError, ""},
+			{"model not found", kind.modelError, probeModelNotFound, ""},
+			{"rate limited", probeStubResponse{status: http.StatusTooManyRequests, body: `{"error":{"message":"rate limit exceeded"}}`, retryAfter: "17"}, probeRateLimited, "17"},
+			{"success", probeStubResponse{status: http.StatusOK, body: `{}`}, probeOK, ""},
+		}
+		for _, scenario := range scenarios {
+			t.Run(kind.name+"/"+scenario.name, func(t *testing.T) {
+				var gotPath, gotAuth string
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					gotPath = r.URL.Path
+					gotAuth = r.Header.Get(kind.authHeader)
+					if scenario.response.retryAfter != "" {
+						w.Header().Set("Retry-After", scenario.response.retryAfter)
+					}
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(scenario.response.status)
+					_, _ = w.Write([]byte(scenario.response.body))
+				}))
+				defer server.Close()
+
+				prober := newEnvProber(server.Client())
+				prober.baseURL = server.URL
+				result := prober.probe(kind.env, probeModeGenerate)
+
+				if result.Kind != kind.kind {
+					t.Errorf("kind = %q, want %q", result.Kind, kind.kind)
+				}
+				if gotPath != kind.path {
+					t.Errorf("path = %q, want %q", gotPath, kind.path)
+				}
+				if gotAuth != kind.authValue {
+					t.Errorf("%s = %q, want %q", kind.authHeader, gotAuth, kind.authValue)
+				}
+				if result.Status != scenario.status {
+					t.Errorf("status = %q (%d: %s), want %q", result.Status, result.StatusCode, result.Message, scenario.status)
+				}
+				if result.StatusCode != scenario.response.status {
+					t.Errorf("status code = %d, want %d", result.StatusCode, scenario.response.status)
+				}
+				if result.RetryAfter != scenario.retryAfter {
+					t.Errorf("retry after = %q, want %q", result.RetryAfter, scenario.retryAfter)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	promptScopeGlobal  = "global"
+	promptScopeProject = "project"
+
+	promptScanMaxDepth   = 6 // 项目扫描的最大目录深度
+	promptImportMaxDepth = 5 // Claude @import 最大递归深度（与 Claude Code 一致）
+)
+
+// 扫描项目时跳过的目录
+var promptScanSkipDirs = map[string]struct{}{
+	".git": {}, "node_modules": {}, "vendor": {}, "dist": {}, "build": {},
+	"target": {}, "out": {}, ".venv": {}, "venv": {}, "__pycache__": {},
+}
+
+// 项目根目录下可新建的指令文件（相对路径）
+var promptProjectRootFiles = []string{"CLAUDE.md", "CLAUDE.local.md", "AGENTS.md", "GEMINI.md"}
+
+var promptImportPattern = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+// PromptImport Claude 指令文件中 @import 引用解析后的节点
+type PromptImport struct {
+	Ref      string         `json:"ref"`             // 原始引用（@ 之后的部分）
+	Path     string         `json:"path"`            // 解析后的绝对路径
+	Exists   bool           `json:"exists"`          // 文件是否存在
+	Error    string         `json:"error,omitempty"` // 循环引用 / 超出深度等
+	Children []PromptImport `json:"children,omitempty"`
+}
+
+// promptProviderForFile 根据文件名判断指令文件所属的 Provider，不是指令文件时返回空字符串
+func promptProviderForFile(path string) string {
+	switch filepath.Base(path) {
+	case "CLAUDE.md", "CLAUDE.local.md":
+		return "claude"
+	case "AGENTS.md":
+		return "codex"
+	case "GEMINI.md":
+		return "gemini"
+	default:
+		return ""
+	}
+}
+
+// openclawWorkspaceDir 返回 OpenClaw 工作区目录（agents.defaults.workspace，默认 <stateDir>/workspace）
+func (a *App) openclawWorkspaceDir() string {
+	activeVars := map[string]string{}
+	if env := a.findEnv(a.config.CurrentEnvOpenclaw); env != nil {
+		activeVars = env.Variables
+	}
+	openclawHome, stateDir, configFile := resolveOpenclawPaths(activeVars)
+	if data, err := os.ReadFile(configFile); err == nil && len(data) > 0 {
+		if payload, err := parseJSONLikeObject(data); err == nil {
+			if value, ok := getMapPath(payload, "agents.defaults.workspace"); ok {
+				if workspace, ok := value.(string); ok && strings.TrimSpace(workspace) != "" {
+					return expandAndNormalizePath(workspace, openclawHome, stateDir)
+				}
+			}
+		}
+	}
+	return filepath.Join(stateDir, "workspace")
+}
+
+// globalPromptPath 返回全局指令文件路径
+func (a *App) globalPromptPath(provider string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("获取用户目录失败: %v", err)
+	}
+	switch provider {
+	case "claude":
+		return filepath.Join(homeDir, ".claude", "CLAUDE.md"), nil
+	case "codex":
+		return filepath.Join(homeDir, ".codex", "AGENTS.md"), nil
+	case "gemini":
+		return filepath.Join(homeDir, ".gemini", "GEMINI.md"), nil
+	case "openclaw":
+		return filepath.Join(a.openclawWorkspaceDir(), "AGENTS.md"), nil
+	default:
+		return "", fmt.Errorf("未知的 Provider: %s", provider)
+	}
+}
+
+// resolvePromptFile 将提示词文件 ID 解析为文件信息（不读取内容）。
+// ID 为 claude/codex/gemini/openclaw 时表示全局文件，否则为已登记项目内指令文件的绝对路径
+func (a *App) resolvePromptFile(id string) (PromptFile, error) {
+	id = strings.TrimSpace(id)
+	if !filepath.IsAbs(id) {
+		path, err := a.globalPromptPath(id)
+		if err != nil {
+			return PromptFile{}, err
+		}
+		return PromptFile{ID: id, Provider: id, Scope: promptScopeGlobal, Name: filepath.Base(path), Path: path}, nil
+	}
+
+	path := filepath.Clean(id)
+	provider := promptProviderForFile(path)
+	if provider == "" {
+		return PromptFile{}, fmt.Errorf("不是受支持的指令文件: %s", path)
+	}
+	// 项目嵌套时归属最内层的项目
+	project, name := "", ""
+	for _, root := range a.config.PromptProjects {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > len(project) {
+			project, name = root, filepath.ToSlash(rel)
+		}
+	}
+	if project == "" {
+		return PromptFile{}, fmt.Errorf("文件不在已登记的项目中: %s", path)
+	}
+	return PromptFile{
+		ID:       path,
+		Provider: provider,
+		Scope:    promptScopeProject,
+		Project:  project,
+		Name:     name,
+		Path:     path,
+	}, nil
+}
+
+// loadPromptFile 读取文件内容，Claude 文件同时解析 @import 树；
+// 内容与最新历史版本不同时记录为外部修改
+func loadPromptFile(file PromptFile) PromptFile {
+	if data, err := os.ReadFile(file.Path); err == nil {
+		file.Content = string(data)
+		file.Exists = true
+		_ = recordPromptRevision(file.Path, file.Content, true, promptSourceExternal)
+		if file.Provider == "claude" {
+			file.Imports = resolvePromptImports(file.Path, file.Content)
+		}
+	} else {
+		file.Content = ""
+		file.Exists = false
+		if os.IsNotExist(err) {
+			_ = recordPromptRevision(file.Path, "", false, promptSourceExternal)
+		}
+	}
+	return file
+}
+
+// writePromptFile 写入提示词文件，必要时创建目录；写入前后均记录历史版本
+func (a *App) writePromptFile(file PromptFile, content, source string) error {
+	if err := notePromptFileState(file.Path); err != nil {
+		return fmt.Errorf("记录历史版本失败: %v", err)
+	}
+
+	// 确保目录存在
+	if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	// 写入文件
+	if err := os.WriteFile(file.Path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	if err := recordPromptRevision(file.Path, content, true, source); err != nil {
+		return fmt.Errorf("记录历史版本失败: %v", err)
+	}
+	return nil
+}
+
+// removePromptFile 删除提示词文件（不存在时忽略），删除前保存当前内容
+func (a *App) removePromptFile(file PromptFile, source string) error {
+	if err := notePromptFileState(file.Path); err != nil {
+		return fmt.Errorf("记录历史版本失败: %v", err)
+	}
+
+	if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除文件失败: %v", err)
+	}
+
+	if err := recordPromptRevision(file.Path, "", false, source); err != nil {
+		return fmt.Errorf("记录历史版本失败: %v", err)
+	}
+	return nil
+}
+
+// ListPromptProjects 列出已登记的项目根目录
+func (a *App) ListPromptProjects() []string {
+	return append([]string{}, a.config.PromptProjects...)
+}
+
+// AddPromptProject 登记项目根目录，用于扫描项目级指令文件
+func (a *App) AddPromptProject(root string) error {
+	homeDir, _ := os.UserHomeDir()
+	path := expandAndNormalizePath(root, homeDir, homeDir)
+	if path == "" {
+		return fmt.Errorf("项目路径不能为空")
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("项目目录不存在: %s", path)
+	}
+	if containsString(a.config.PromptProjects, path) {
+		return nil
+	}
+	a.config.PromptProjects = append(a.config.PromptProjects, path)
+	sort.Strings(a.config.PromptProjects)
+	return a.saveConfig()
+}
+
+// RemovePromptProject 取消登记项目根目录（不删除任何文件）
+func (a *App) RemovePromptProject(root string) error {
+	homeDir, _ := os.UserHomeDir()
+	path := expandAndNormalizePath(root, homeDir, homeDir)
+	for i, existing := range a.config.PromptProjects {
+		if existing == path {
+			a.config.PromptProjects = append(a.config.PromptProjects[:i], a.config.PromptProjects[i+1:]...)
+			return a.saveConfig()
+		}
+	}
+	return fmt.Errorf("项目未登记: %s", root)
+}
+
+// ScanPromptProject 扫描项目中的指令文件；根目录下尚未创建的常用文件以 Exists=false 列出
+func (a *App) ScanPromptProject(root string) ([]PromptFile, error) {
+	homeDir, _ := os.UserHomeDir()
+	root = expandAndNormalizePath(root, homeDir, homeDir)
+	if !containsString(a.config.PromptProjects, root) {
+		return nil, fmt.Errorf("项目未登记: %s", root)
+	}
+
+	found := map[string]struct{}{}
+	var files []PromptFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 无权限等错误跳过该项，继续扫描
+			if d != nil && d.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			name := d.Name()
+			rel, _ := filepath.Rel(root, path)
+			if _, skip := promptScanSkipDirs[name]; skip {
+				return filepath.SkipDir
+			}
+			// 隐藏目录只进入 .claude / .gemini
+			if strings.HasPrefix(name, ".") && name != ".claude" && name != ".gemini" {
+				return filepath.SkipDir
+			}
+			if strings.Count(rel, string(filepath.Separator))+1 > promptScanMaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if promptProviderForFile(path) == "" {
+			return nil
+		}
+		file, err := a.resolvePromptFile(path)
+		if err != nil {
+			return nil
+		}
+		found[path] = struct{}{}
+		files = append(files, loadPromptFile(file))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("扫描项目失败: %v", err)
+	}
+
+	for _, name := range promptProjectRootFiles {
+		path := filepath.Join(root, name)
+		if _, ok := found[path]; ok {
+			continue
+		}
+		if file, err := a.resolvePromptFile(path); err == nil {
+			files = append(files, file)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// GetPromptImportTree 解析 Claude 指令文件的 @import 引用树
+func (a *App) GetPromptImportTree(id string) ([]PromptImport, error) {
+	file, err := a.resolvePromptFile(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PromptImport{}, nil
+		}
+		return nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+	return resolvePromptImports(file.Path, string(data)), nil
+}
+
+// resolvePromptImports 解析 content 中的 @import 引用，相对路径以 filePath 所在目录为基准
+func resolvePromptImports(filePath, content string) []PromptImport {
+	visited := map[string]struct{}{filepath.Clean(filePath): {}}
+	return resolvePromptImportsAt(filePath, content, 1, visited)
+}
+
+func resolvePromptImportsAt(filePath, content string, depth int, visited map[string]struct{}) []PromptImport {
+	homeDir, _ := os.UserHomeDir()
+	baseDir := filepath.Dir(filePath)
+
+	imports := []PromptImport{}
+	for _, ref := range parsePromptImportRefs(content) {
+		node := PromptImport{Ref: ref, Path: expandAndNormalizePath(ref, homeDir, baseDir)}
+		node.Exists = fileExistsFile(node.Path)
+
+		switch {
+		case !node.Exists:
+		case depth >= promptImportMaxDepth:
+			node.Error = "超过最大导入深度"
+		default:
+			if _, seen := visited[node.Path]; seen {
+				node.Error = "循环引用"
+				break
+			}
+			data, err := os.ReadFile(node.Path)
+			if err != nil {
+				node.Error = err.Error()
+				break
+			}
+			visited[node.Path] = struct{}{}
+			node.Children = resolvePromptImportsAt(node.Path, string(data), depth+1, visited)
+			delete(visited, node.Path)
+		}
+		imports = append(imports, node)
+	}
+	return imports
+}
+
+// parsePromptImportRefs 提取 @path 引用，忽略代码块和行内代码中的内容
+func parsePromptImportRefs(content string) []string {
+	var refs []string
+	seen := map[string]struct{}{}
+	inFence := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		for _, match := range promptImportPattern.FindAllStringSubmatch(stripInlineCode(line), -1) {
+			ref := strings.TrimRight(match[1], ".,;:!?)]\"'")
+			// 仅识别形如路径的引用，排除 @mention 之类
+			if !strings.ContainsAny(ref, "./\\") && !strings.HasPrefix(ref, "~") {
+				continue
+			}
+			if _, ok := seen[ref]; ok {
+				continue
+			}
+			seen[ref] = struct{}{}
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func stripInlineCode(line string) string {
+	parts := strings.Split(line, "`")
+	var kept []string
+	for i, part := range parts {
+		if i%2 == 0 {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, " ")
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	promptHistoryDir   = "prompt_history"
+	promptHistoryIndex = "index.json"
+
+	promptHistoryMaxRevisions = 50                  // 每个文件最多保留的版本数
+	promptHistoryMaxAge       = 90 * 24 * time.Hour // 超过该时间的版本被清理（最新版本始终保留）
+	promptDiffContext         = 3                   // diff 上下文行数
+	// promptDiffMaxCells 去掉首尾相同行后 旧行数×新行数 的上限，超过时只提示文件不同
+	promptDiffMaxCells = 25_000_000
+)
+
+// 版本来源
+const (
+	promptSourceSave     = "save"     // 通过 SavePromptFile 保存
+	promptSourceLibrary  = "library"  // 提示词库渲染
+	promptSourceRestore  = "restore"  // 恢复历史版本
+	promptSourceDelete   = "delete"   // 通过 DeletePromptFile 删除
+	promptSourceExternal = "external" // 读取时发现的外部修改
+)
+
+var promptHistoryMu sync.Mutex
+
+// PromptRevision 提示词文件的一个历史版本
+type PromptRevision struct {
+	ID        string `json:"id"`
+	Time      string `json:"time"`
+	Source    string `json:"source"`
+	Size      int    `json:"size"`
+	Hash      string `json:"hash"`
+	Deleted   bool   `json:"deleted,omitempty"` // 文件在该版本被删除
+	CreatedAt int64  `json:"created_at"`        // Unix 秒，用于清理
+}
+
+type promptHistoryIndexData struct {
+	Path      string           `json:"path"`
+	Revisions []PromptRevision `json:"revisions"` // 按时间升序
+}
+
+// ListPromptRevisions 列出提示词文件的历史版本（最新在前）
+func (a *App) ListPromptRevisions(id string) ([]PromptRevision, error) {
+	file, err := a.resolvePromptFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	promptHistoryMu.Lock()
+	defer promptHistoryMu.Unlock()
+	index, _, err := loadPromptHistory(file.Path)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]PromptRevision, 0, len(index.Revisions))
+	for i := len(index.Revisions) - 1; i >= 0; i-- {
+		revisions = append(revisions, index.Revisions[i])
+	}
+	return revisions, nil
+}
+
+// GetPromptRevision 读取某个历史版本的内容
+func (a *App) GetPromptRevision(id, revisionID string) (string, error) {
+	file, err := a.resolvePromptFile(id)
+	if err != nil {
+		return "", err
+	}
+	promptHistoryMu.Lock()
+	defer promptHistoryMu.Unlock()
+	content, _, err := readPromptRevision(file.Path, revisionID)
+	return content, err
+}
+
+// DiffPromptRevisions 生成两个版本之间的 unified diff；toRevision 为空时与当前文件比较
+func (a *App) DiffPromptRevisions(id, fromRevision, toRevision string) (string, error) {
+	file, err := a.resolvePromptFile(id)
+	if err != nil {
+		return "", err
+	}
+
+	promptHistoryMu.Lock()
+	fromContent, _, err := readPromptRevision(file.Path, fromRevision)
+	if err != nil {
+		promptHistoryMu.Unlock()
+		return "", err
+	}
+	toContent, toLabel := "", "current"
+	if strings.TrimSpace(toRevision) != "" {
+		toContent, _, err = readPromptRevision(file.Path, toRevision)
+		toLabel = toRevision
+	} else if data, readErr := os.ReadFile(file.Path); readErr == nil {
+		toContent = string(data)
+	} else if !os.IsNotExist(readErr) {
+		err = fmt.Errorf("读取文件失败: %v", readErr)
+	}
+	promptHistoryMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	return unifiedDiff(fromContent, toContent, fromRevision, toLabel), nil
+}
+
+// RestorePromptRevision 将提示词文件恢复到指定版本（当前内容会先记录为新版本）
+func (a *App) RestorePromptRevision(id, revisionID string) error {
+	file, err := a.resolvePromptFile(id)
+	if err != nil {
+		return err
+	}
+
+	promptHistoryMu.Lock()
+	content, revision, err := readPromptRevision(file.Path, revisionID)
+	promptHistoryMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if revision.Deleted {
+		return a.removePromptFile(file, promptSourceRestore)
+	}
+	return a.writePromptFile(file, content, promptSourceRestore)
+}
+
+// recordPromptRevision 若内容与最新版本不同则保存一个新版本；
+// 文件不存在且没有历史时不记录
+func recordPromptRevision(path, content string, exists bool, source string) error {
+	promptHistoryMu.Lock()
+	defer promptHistoryMu.Unlock()
+
+	index, dir, err := loadPromptHistory(path)
+	if err != nil {
+		return err
+	}
+	hash := promptContentHash(content)
+	if n := len(index.Revisions); n > 0 {
+		last := index.Revisions[n-1]
+		if last.Deleted == !exists && (!exists || last.Hash == hash) {
+			return nil
+		}
+	} else if !exists {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("创建历史目录失败: %v", err)
+	}
+	now := time.Now()
+	revision := PromptRevision{
+		ID:        now.UTC().Format("20060102T150405.000000000Z"),
+		Time:      now.Format(time.RFC3339),
+		Source:    source,
+		Size:      len(content),
+		Hash:      hash,
+		Deleted:   !exists,
+		CreatedAt: now.Unix(),
+	}
+	for i := 1; fileExistsFile(filepath.Join(dir, revision.ID+".md")); i++ {
+		revision.ID = fmt.Sprintf("%s-%d", now.UTC().Format("20060102T150405.000000000Z"), i)
+	}
+	if exists {
+		if err := os.WriteFile(filepath.Join(dir, revision.ID+".md"), []byte(content), 0o644); err != nil {
+			return fmt.Errorf("保存历史版本失败: %v", err)
+		}
+	}
+
+	index.Path = path
+	index.Revisions = append(index.Revisions, revision)
+	prunePromptHistory(&index, dir, now)
+	return savePromptHistory(dir, index)
+}
+
+// notePromptFileState 将磁盘上的当前内容记录为外部修改（与最新版本一致时不记录）
+func notePromptFileState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return recordPromptRevision(path, "", false, promptSourceExternal)
+		}
+		return nil
+	}
+	return recordPromptRevision(path, string(data), true, promptSourceExternal)
+}
+
+// prunePromptHistory 按数量与时间清理旧版本，最新版本始终保留
+func prunePromptHistory(index *promptHistoryIndexData, dir string, now time.Time) {
+	cutoff := now.Add(-promptHistoryMaxAge).Unix()
+	keepFrom := 0
+	if len(index.Revisions) > promptHistoryMaxRevisions {
+		keepFrom = len(index.Revisions) - promptHistoryMaxRevisions
+	}
+	for keepFrom < len(index.Revisions)-1 && index.Revisions[keepFrom].CreatedAt < cutoff {
+		keepFrom++
+	}
+	for _, revision := range index.Revisions[:keepFrom] {
+		_ = os.Remove(filepath.Join(dir, revision.ID+".md"))
+	}
+	index.Revisions = append([]PromptRevision{}, index.Revisions[keepFrom:]...)
+}
+
+func readPromptRevision(path, revisionID string) (string, PromptRevision, error) {
+	index, dir, err := loadPromptHistory(path)
+	if err != nil {
+		return "", PromptRevision{}, err
+	}
+	revisionID = strings.TrimSpace(revisionID)
+	for _, revision := range index.Revisions {
+		if revision.ID != revisionID {
+			continue
+		}
+		if revision.Deleted {
+			return "", revision, nil
+		}
+		data, err := os.ReadFile(filepath.Join(dir, revision.ID+".md"))
+		if err != nil {
+			return "", revision, fmt.Errorf("读取历史版本失败: %v", err)
+		}
+		return string(data), revision, nil
+	}
+	return "", PromptRevision{}, fmt.Errorf("历史版本不存在: %s", revisionID)
+}
+
+func promptContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// promptHistoryPath 返回文件对应的历史目录（按路径哈希区分）
+func promptHistoryPath(path string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(path)))
+	return filepath.Join(home, mcpStoreDir, promptHistoryDir, hex.EncodeToString(sum[:8])), nil
+}
+
+func loadPromptHistory(path string) (promptHistoryIndexData, string, error) {
+	index := promptHistoryIndexData{Path: path}
+	dir, err := promptHistoryPath(path)
+	if err != nil {
+		return index, "", err
+	}
+	if _, err := readJSONFile(filepath.Join(dir, promptHistoryIndex), &index); err != nil {
+		return index, dir, err
+	}
+	if index.Revisions == nil {
+		index.Revisions = []PromptRevision{}
+	}
+	return index, dir, nil
+}
+
+func savePromptHistory(dir string, index promptHistoryIndexData) error {
+	return writeJSONFile(filepath.Join(dir, promptHistoryIndex), index, 0o644)
+}
+
+// unifiedDiff 生成按行比较的 unified diff；内容相同时返回空字符串
+func unifiedDiff(from, to, fromLabel, toLabel string) string {
+	a := splitDiffLines(from)
+	b := splitDiffLines(to)
+
+	ops, ok := diffLines(a, b)
+	if !ok {
+		return fmt.Sprintf("--- %s\n+++ %s\n文件差异过大，无法逐行比较（%d 行 -> %d 行）\n", fromLabel, toLabel, len(a), len(b))
+	}
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		// 向前后扩展上下文，合并相距较近的改动
+		hunkStart := start - promptDiffContext
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				hunkEnd = k
+			} else if k-hunkEnd > 2*promptDiffContext {
+				break
+			}
+		}
+		hunkEnd += promptDiffContext + 1
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+		}
+		var fromCount, toCount int
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+		}
+		fromStart, toStart := ops[hunkStart].ai+1, ops[hunkStart].bi+1
+		if fromCount == 0 {
+			fromStart--
+		}
+		if toCount == 0 {
+			toStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromStart, fromCount, toStart, toCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// diffOp diff 中的一行
+type diffOp struct {
+	kind byte // ' ', '-', '+'
+	text string
+	ai   int // 对应 a 中的行号（0 起）
+	bi   int
+}
+
+// diffLines 计算 a -> b 的逐行编辑序列。先去掉首尾相同的行，中间部分用 Hirschberg 算法求
+// 最长公共子序列，内存与行数成线性；剩余部分过大时返回 false
+func diffLines(a, b []string) ([]diffOp, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := len(a)-prefix-suffix, len(b)-prefix-suffix
+	if midA > 0 && midB > 0 && midA*midB > promptDiffMaxCells {
+		return nil, false
+	}
+
+	// 行内容换成编号，比较更快
+	ids := map[string]int32{}
+	lineIDs := func(lines []string) []int32 {
+		out := make([]int32, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = int32(len(ids))
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &lineDiff{a: lineIDs(a[prefix : len(a)-suffix]), b: lineIDs(b[prefix : len(b)-suffix])}
+	d.row1 = make([]int32, len(d.b)+1)
+	d.row2 = make([]int32, len(d.b)+1)
+
+	ops := make([]diffOp, 0, len(a)+len(b)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{' ', a[i], i, i})
+	}
+	d.emit = func(kind byte, i, j int) {
+		ai, bi := prefix+i, prefix+j
+		text := ""
+		if kind == '+' {
+			text = b[bi]
+		} else {
+			text = a[ai]
+		}
+		ops = append(ops, diffOp{kind, text, ai, bi})
+	}
+	d.diff(0, len(d.a), 0, len(d.b))
+	for k := suffix; k > 0; k-- {
+		ops = append(ops, diffOp{' ', a[len(a)-k], len(a) - k, len(b) - k})
+	}
+	return ops, true
+}
+
+// lineDiff Hirschberg 算法的状态；row1/row2 为复用的两行 LCS 长度
+type lineDiff struct {
+	a, b       []int32
+	row1, row2 []int32
+	emit       func(kind byte, i, j int)
+}
+
+// diff 按顺序输出 a[a0:a1] -> b[b0:b1] 的编辑序列（删除先于新增）
+func (d *lineDiff) diff(a0, a1, b0, b1 int) {
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			d.emit('+', a0, j)
+		}
+		return
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			d.emit('-', i, b0)
+		}
+		return
+	case a1-a0 == 1:
+		for j := b0; j < b1; j++ {
+			if d.a[a0] == d.b[j] {
+				for k := b0; k < j; k++ {
+					d.emit('+', a0, k)
+				}
+				d.emit(' ', a0, j)
+				for k := j + 1; k < b1; k++ {
+					d.emit('+', a0+1, k)
+				}
+				return
+			}
+		}
+		d.emit('-', a0, b0)
+		for j := b0; j < b1; j++ {
+			d.emit('+', a1, j)
+		}
+		return
+	}
+
+	// 上半部分的前向 LCS 长度与下半部分的反向 LCS 长度之和最大处即为 b 的切分点
+	mid := (a0 + a1) / 2
+	forward := d.lcsForward(a0, mid, b0, b1, d.row1)
+	backward := d.lcsBackward(mid, a1, b0, b1, d.row2)
+	split, best := b0, int32(-1)
+	for k := 0; k <= b1-b0; k++ {
+		if score := forward[k] + backward[k]; score > best {
+			split, best = b0+k, score
+		}
+	}
+	d.diff(a0, mid, b0, split)
+	d.diff(mid, a1, split, b1)
+}
+
+// lcsForward row[k] = LCS(a[a0:a1], b[b0:b0+k])
+func (d *lineDiff) lcsForward(a0, a1, b0, b1 int, row []int32) []int32 {
+	row = row[:b1-b0+1]
+	clear(row)
+	for i := a0; i < a1; i++ {
+		var diag int32
+		for k := 1; k <= b1-b0; k++ {
+			up := row[k]
+			if d.a[i] == d.b[b0+k-1] {
+				row[k] = diag + 1
+			} else if row[k-1] > up {
+				row[k] = row[k-1]
+			}
+			diag = up
+		}
+	}
+	return row
+}
+
+// lcsBackward row[k] = LCS(a[a0:a1], b[b0+k:b1])
+func (d *lineDiff) lcsBackward(a0, a1, b0, b1 int, row []int32) []int32 {
+	n := b1 - b0
+	row = row[:n+1]
+	clear(row)
+	for i := a1 - 1; i >= a0; i-- {
+		var diag int32
+		for k := n - 1; k >= 0; k-- {
+			down := row[k]
+			if d.a[i] == d.b[b0+k] {
+				row[k] = diag + 1
+			} else if row[k+1] > down {
+				row[k] = row[k+1]
+			}
+			diag = down
+		}
+	}
+	return row
+}
+
+func splitDiffLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	numbered := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "identical",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "insert into empty file",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "delete everything",
+			from: "a\nb\n",
+			to:   "",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "insert only",
+			from: "a\nb\nc\n",
+			to:   "a\nb\nx\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,4 @@\n a\n b\n+x\n c\n",
+		},
+		{
+			name: "delete only",
+			from: "a\nb\nx\nc\n",
+			to:   "a\nb\nc\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,3 @@\n a\n b\n-x\n c\n",
+		},
+		{
+			name: "replacement lists deletions before additions",
+			from: "a\nold1\nold2\nb\n",
+			to:   "a\nnew1\nb\n",
+			want: "--- old\n+++ new\n@@ -1,4 +1,3 @@\n a\n-old1\n-old2\n+new1\n b\n",
+		},
+		{
+			name: "context is trimmed to three lines",
+			from: numbered(10),
+			to:   strings.Replace(numbered(10), "5\n", "five\n", 1),
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "nearby changes share one hunk",
+			from: numbered(12),
+			to:   strings.Replace(strings.Replace(numbered(12), "3\n", "three\n", 1), "9\n", "nine\n", 1),
+			want: "--- old\n+++ new\n@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			from: numbered(20),
+			to:   strings.Replace(strings.Replace(numbered(20), "2\n", "two\n", 1), "19\n", "nineteen\n", 1),
+			want: "--- old\n+++ new\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n@@ -16,5 +16,5 @@\n 16\n 17\n 18\n-19\n+nineteen\n 20\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff(tt.from, tt.to, "old", "new"); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffOversizedInput(t *testing.T) {
+	var from, to strings.Builder
+	for i := 0; i < 5001; i++ {
+		fmt.Fprintf(&from, "a%d\n", i)
+		fmt.Fprintf(&to, "b%d\n", i)
+	}
+	got := unifiedDiff(from.String(), to.String(), "old", "new")
+	want := "--- old\n+++ new\n文件差异过大，无法逐行比较（5001 行 -> 5001 行）\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// 首尾相同的行不计入上限
+	same := strings.Repeat("same\n", 10000)
+	if got := unifiedDiff(same+"x\n", same+"y\n", "old", "new"); !strings.Contains(got, "-x\n+y\n") {
+		t.Errorf("shared prefix should not trip the cap:\n%s", got)
+	}
+}
+
+// TestDiffLinesIsMinimal 随机输入下编辑序列能还原两侧内容，且保留的行数等于最长公共子序列
+func TestDiffLinesIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+	for round := 0; round < 500; round++ {
+		a, b := randomLines(), randomLines()
+		ops, ok := diffLines(a, b)
+		if !ok {
+			t.Fatal("small input should not hit the cap")
+		}
+		var gotA, gotB []string
+		kept := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.text)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.text)
+			}
+			if op.kind == ' ' {
+				kept++
+			}
+		}
+		if strings.Join(gotA, ",") != strings.Join(a, ",") || strings.Join(gotB, ",") != strings.Join(b, ",") {
+			t.Fatalf("%v -> %v: ops do not reproduce input: %v", a, b, ops)
+		}
+		if want := lcsLength(a, b); kept != want {
+			t.Fatalf("%v -> %v: kept %d lines, LCS is %d", a, b, kept, want)
+		}
+	}
+}
+
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				dp[i][j] = dp[i+1][j+1] + 1
+			case dp[i+1][j] > dp[i][j+1]:
+				dp[i][j] = dp[i+1][j]
+			default:
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	promptLibraryFile = "prompt_library.json"
+
+	promptBlockBegin = "<!-- BEGIN claude-env-switcher:%s -->"
+	promptBlockEnd   = "<!-- END claude-env-switcher:%s -->"
+)
+
+var promptLibraryMu sync.Mutex
+
+// PromptSnippet 提示词片段（单一来源，可被多个组合引用）
+type PromptSnippet struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Content     string `json:"content"`
+	UpdatedAt   string `json:"updated_at,omitempty"`
+}
+
+// PromptRecipe 提示词组合：按顺序拼接片段，渲染到目标指令文件的托管区块中
+type PromptRecipe struct {
+	Name     string   `json:"name"`
+	Snippets []string `json:"snippets"`
+	// Targets 目标提示词文件 ID（claude/codex/gemini/openclaw 或项目文件路径）
+	Targets []string `json:"targets"`
+}
+
+type promptLibrary struct {
+	Snippets []PromptSnippet `json:"snippets"`
+	Recipes  []PromptRecipe  `json:"recipes"`
+	Variants []PromptVariant `json:"variants,omitempty"`
+}
+
+// ListPromptSnippets 列出提示词片段
+func (a *App) ListPromptSnippets() ([]PromptSnippet, error) {
+	promptLibraryMu.Lock()
+	defer promptLibraryMu.Unlock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return lib.Snippets, nil
+}
+
+// SavePromptSnippet 新增或更新片段，并重新渲染所有引用它的目标文件
+func (a *App) SavePromptSnippet(snippet PromptSnippet) error {
+	snippet.Name = strings.TrimSpace(snippet.Name)
+	if snippet.Name == "" {
+		return fmt.Errorf("片段名称不能为空")
+	}
+	snippet.UpdatedAt = time.Now().Format(time.RFC3339)
+
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		promptLibraryMu.Unlock()
+		return err
+	}
+	replaced := false
+	for i := range lib.Snippets {
+		if lib.Snippets[i].Name == snippet.Name {
+			lib.Snippets[i] = snippet
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		lib.Snippets = append(lib.Snippets, snippet)
+		sort.SliceStable(lib.Snippets, func(i, j int) bool {
+			return strings.ToLower(lib.Snippets[i].Name) < strings.ToLower(lib.Snippets[j].Name)
+		})
+	}
+	err = savePromptLibrary(lib)
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词库失败: %v", err)
+	}
+
+	var targets []string
+	for _, recipe := range lib.Recipes {
+		if containsString(recipe.Snippets, snippet.Name) {
+			targets = append(targets, recipe.Targets...)
+		}
+	}
+	return a.renderPromptTargets(targets)
+}
+
+// DeletePromptSnippet 删除片段；仍被组合引用时拒绝删除
+func (a *App) DeletePromptSnippet(name string) error {
+	name = strings.TrimSpace(name)
+
+	promptLibraryMu.Lock()
+	defer promptLibraryMu.Unlock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		return err
+	}
+	for _, recipe := range lib.Recipes {
+		if containsString(recipe.Snippets, name) {
+			return fmt.Errorf("片段 %s 仍被组合 %s 使用", name, recipe.Name)
+		}
+	}
+	for i := range lib.Snippets {
+		if lib.Snippets[i].Name == name {
+			lib.Snippets = append(lib.Snippets[:i], lib.Snippets[i+1:]...)
+			return savePromptLibrary(lib)
+		}
+	}
+	return fmt.Errorf("片段不存在: %s", name)
+}
+
+// ListPromptRecipes 列出提示词组合
+func (a *App) ListPromptRecipes() ([]PromptRecipe, error) {
+	promptLibraryMu.Lock()
+	defer promptLibraryMu.Unlock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return lib.Recipes, nil
+}
+
+// SavePromptRecipe 新增或更新组合，并渲染到其目标文件；从目标中移除的文件会删除对应托管区块
+func (a *App) SavePromptRecipe(recipe PromptRecipe) error {
+	recipe.Name = strings.TrimSpace(recipe.Name)
+	recipe.Snippets = cleanArgs(recipe.Snippets)
+	recipe.Targets = cleanArgs(recipe.Targets)
+	if recipe.Name == "" {
+		return fmt.Errorf("组合名称不能为空")
+	}
+	for _, target := range recipe.Targets {
+		if _, err := a.resolvePromptFile(target); err != nil {
+			return err
+		}
+	}
+
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		promptLibraryMu.Unlock()
+		return err
+	}
+	for _, name := range recipe.Snippets {
+		if findPromptSnippet(lib, name) == nil {
+			promptLibraryMu.Unlock()
+			return fmt.Errorf("片段不存在: %s", name)
+		}
+	}
+	var previousTargets []string
+	replaced := false
+	for i := range lib.Recipes {
+		if lib.Recipes[i].Name == recipe.Name {
+			previousTargets = lib.Recipes[i].Targets
+			lib.Recipes[i] = recipe
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		lib.Recipes = append(lib.Recipes, recipe)
+		sort.SliceStable(lib.Recipes, func(i, j int) bool {
+			return strings.ToLower(lib.Recipes[i].Name) < strings.ToLower(lib.Recipes[j].Name)
+		})
+	}
+	err = savePromptLibrary(lib)
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词库失败: %v", err)
+	}
+
+	return a.renderPromptTargets(append(previousTargets, recipe.Targets...))
+}
+
+// DeletePromptRecipe 删除组合，并移除其在目标文件中的托管区块（区块外的内容保持不变）
+func (a *App) DeletePromptRecipe(name string) error {
+	name = strings.TrimSpace(name)
+
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		promptLibraryMu.Unlock()
+		return err
+	}
+	var targets []string
+	found := false
+	for i := range lib.Recipes {
+		if lib.Recipes[i].Name == name {
+			targets = lib.Recipes[i].Targets
+			lib.Recipes = append(lib.Recipes[:i], lib.Recipes[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		promptLibraryMu.Unlock()
+		return fmt.Errorf("组合不存在: %s", name)
+	}
+	err = savePromptLibrary(lib)
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词库失败: %v", err)
+	}
+
+	for _, target := range targets {
+		file, err := a.resolvePromptFile(target)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(file.Path)
+		if err != nil {
+			continue
+		}
+		content, removed, err := removePromptBlock(string(data), name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Path, err)
+		}
+		if removed {
+			if err := a.writePromptFile(file, content, promptSourceLibrary); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PreviewPromptRecipe 返回组合渲染后的内容（不写入文件）
+func (a *App) PreviewPromptRecipe(name string) (string, error) {
+	promptLibraryMu.Lock()
+	defer promptLibraryMu.Unlock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		return "", err
+	}
+	for _, recipe := range lib.Recipes {
+		if recipe.Name == strings.TrimSpace(name) {
+			return renderPromptRecipe(lib, recipe), nil
+		}
+	}
+	return "", fmt.Errorf("组合不存在: %s", name)
+}
+
+// SyncPromptLibrary 将所有组合重新渲染到各自的目标文件
+func (a *App) SyncPromptLibrary() error {
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return err
+	}
+	var targets []string
+	for _, recipe := range lib.Recipes {
+		targets = append(targets, recipe.Targets...)
+	}
+	return a.renderPromptTargets(targets)
+}
+
+// renderPromptTargets 重新渲染指定目标文件中的所有托管区块
+func (a *App) renderPromptTargets(targets []string) error {
+	seen := map[string]struct{}{}
+	for _, target := range targets {
+		file, err := a.resolvePromptFile(target)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[file.Path]; ok {
+			continue
+		}
+		seen[file.Path] = struct{}{}
+
+		var existing string
+		if data, err := os.ReadFile(file.Path); err == nil {
+			existing = string(data)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("读取文件失败: %v", err)
+		}
+		content, err := a.applyPromptRecipes(file, existing)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Path, err)
+		}
+		if content == existing {
+			continue
+		}
+		if err := a.writePromptFile(file, content, promptSourceLibrary); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPromptRecipes 将所有以 file 为目标的组合渲染进 content 的托管区块；
+// 不再以该文件为目标的组合区块会被移除
+func (a *App) applyPromptRecipes(file PromptFile, content string) (string, error) {
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	for _, recipe := range lib.Recipes {
+		targeted := false
+		for _, target := range recipe.Targets {
+			if resolved, err := a.resolvePromptFile(target); err == nil && resolved.Path == file.Path {
+				targeted = true
+				break
+			}
+		}
+		if targeted {
+			content, err = upsertPromptBlock(content, recipe.Name, renderPromptRecipe(lib, recipe))
+		} else {
+			content, _, err = removePromptBlock(content, recipe.Name)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+func renderPromptRecipe(lib promptLibrary, recipe PromptRecipe) string {
+	var parts []string
+	for _, name := range recipe.Snippets {
+		if snippet := findPromptSnippet(lib, name); snippet != nil {
+			if text := strings.TrimSpace(snippet.Content); text != "" {
+				parts = append(parts, text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func findPromptSnippet(lib promptLibrary, name string) *PromptSnippet {
+	for i := range lib.Snippets {
+		if lib.Snippets[i].Name == name {
+			return &lib.Snippets[i]
+		}
+	}
+	return nil
+}
+
+// findPromptBlock 返回托管区块（含标记行）在 content 中的起止位置；不存在时 start 为 -1
+func findPromptBlock(content, name string) (int, int, error) {
+	begin := fmt.Sprintf(promptBlockBegin, name)
+	end := fmt.Sprintf(promptBlockEnd, name)
+	start := strings.Index(content, begin)
+	if start < 0 {
+		return -1, -1, nil
+	}
+	offset := strings.Index(content[start:], end)
+	if offset < 0 {
+		return -1, -1, fmt.Errorf("托管区块 %s 缺少结束标记", name)
+	}
+	stop := start + offset + len(end)
+	if stop < len(content) && content[stop] == '\n' {
+		stop++
+	}
+	return start, stop, nil
+}
+
+// upsertPromptBlock 替换托管区块内容，不存在时追加到文件末尾
+func upsertPromptBlock(content, name, body string) (string, error) {
+	block := fmt.Sprintf(promptBlockBegin, name) + "\n"
+	if body != "" {
+		block += body + "\n"
+	}
+	block += fmt.Sprintf(promptBlockEnd, name) + "\n"
+
+	start, stop, err := findPromptBlock(content, name)
+	if err != nil {
+		return "", err
+	}
+	if start >= 0 {
+		return content[:start] + block + content[stop:], nil
+	}
+	if strings.TrimSpace(content) == "" {
+		return block, nil
+	}
+	return strings.TrimRight(content, "\n") + "\n\n" + block, nil
+}
+
+// removePromptBlock 删除托管区块及其前面的空行
+func removePromptBlock(content, name string) (string, bool, error) {
+	start, stop, err := findPromptBlock(content, name)
+	if err != nil || start < 0 {
+		return content, false, err
+	}
+	before := content[:start]
+	if stop >= len(content) {
+		before = strings.TrimRight(before, "\n")
+		if before != "" {
+			before += "\n"
+		}
+	}
+	return before + content[stop:], true, nil
+}
+
+func loadPromptLibrary() (promptLibrary, error) {
+	lib := promptLibrary{}
+	if _, err := loadJSONStore(promptLibraryFile, &lib); err != nil {
+		return lib, err
+	}
+	if lib.Snippets == nil {
+		lib.Snippets = []PromptSnippet{}
+	}
+	if lib.Recipes == nil {
+		lib.Recipes = []PromptRecipe{}
+	}
+	return lib, nil
+}
+
+func savePromptLibrary(lib promptLibrary) error {
+	return saveJSONStore(promptLibraryFile, lib, 0o644)
+}
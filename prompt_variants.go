@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	promptVariantStateFile = "prompt_variants_state.json"
+	promptSourceVariant    = "variant" // 环境切换时安装的提示词变体
+)
+
+var promptVariantMu sync.Mutex
+
+// PromptVariant 环境专用的全局指令文件版本（CLAUDE.md / AGENTS.md / GEMINI.md）
+type PromptVariant struct {
+	Name        string `json:"name"`
+	Provider    string `json:"provider"` // claude, codex, gemini, openclaw
+	Description string `json:"description,omitempty"`
+	Content     string `json:"content"`
+}
+
+// promptBaseSnapshot 安装变体前用户原有的指令文件
+type promptBaseSnapshot struct {
+	Content string `json:"content"`
+	Exists  bool   `json:"exists"`
+}
+
+type promptVariantState struct {
+	Base      map[string]promptBaseSnapshot `json:"base"`                // provider -> 原始文件
+	Active    map[string]string             `json:"active"`              // provider -> 已安装的变体名称
+	Installed map[string]string             `json:"installed,omitempty"` // provider -> 安装时写入的变体内容（不含提示词库区块）
+}
+
+// ListPromptVariants 列出提示词变体
+func (a *App) ListPromptVariants() ([]PromptVariant, error) {
+	promptLibraryMu.Lock()
+	defer promptLibraryMu.Unlock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		return nil, err
+	}
+	return lib.Variants, nil
+}
+
+// SavePromptVariant 新增或更新提示词变体；变体正在使用时立即重新安装
+func (a *App) SavePromptVariant(variant PromptVariant) error {
+	variant.Name = strings.TrimSpace(variant.Name)
+	variant.Provider = strings.ToLower(strings.TrimSpace(variant.Provider))
+	if variant.Name == "" {
+		return fmt.Errorf("变体名称不能为空")
+	}
+	if _, err := a.globalPromptPath(variant.Provider); err != nil {
+		return err
+	}
+
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		promptLibraryMu.Unlock()
+		return err
+	}
+	replaced := false
+	for i := range lib.Variants {
+		if lib.Variants[i].Name == variant.Name {
+			if lib.Variants[i].Provider != variant.Provider {
+				promptLibraryMu.Unlock()
+				return fmt.Errorf("变体 %s 已用于 %s", variant.Name, lib.Variants[i].Provider)
+			}
+			lib.Variants[i] = variant
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		lib.Variants = append(lib.Variants, variant)
+		sort.SliceStable(lib.Variants, func(i, j int) bool {
+			return strings.ToLower(lib.Variants[i].Name) < strings.ToLower(lib.Variants[j].Name)
+		})
+	}
+	err = savePromptLibrary(lib)
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词库失败: %v", err)
+	}
+
+	promptVariantMu.Lock()
+	state, err := loadPromptVariantState()
+	promptVariantMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if state.Active[variant.Provider] == variant.Name {
+		return a.installPromptVariant(variant)
+	}
+	return nil
+}
+
+// DeletePromptVariant 删除提示词变体，并解除所有环境对它的引用；正在使用时恢复原始文件
+func (a *App) DeletePromptVariant(name string) error {
+	name = strings.TrimSpace(name)
+
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	if err != nil {
+		promptLibraryMu.Unlock()
+		return err
+	}
+	var provider string
+	for i := range lib.Variants {
+		if lib.Variants[i].Name == name {
+			provider = lib.Variants[i].Provider
+			lib.Variants = append(lib.Variants[:i], lib.Variants[i+1:]...)
+			break
+		}
+	}
+	if provider == "" {
+		promptLibraryMu.Unlock()
+		return fmt.Errorf("提示词变体 '%s' 不存在", name)
+	}
+	err = savePromptLibrary(lib)
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词库失败: %v", err)
+	}
+
+	promptVariantMu.Lock()
+	state, err := loadPromptVariantState()
+	promptVariantMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if state.Active[provider] == name {
+		if err := a.restorePromptBase(provider); err != nil {
+			return err
+		}
+	}
+
+	changed := false
+	for i := range a.config.Environments {
+		if a.config.Environments[i].PromptVariant == name {
+			a.config.Environments[i].PromptVariant = ""
+			changed = true
+		}
+	}
+	if changed {
+		return a.saveConfig()
+	}
+	return nil
+}
+
+// applyEnvPromptVariant 安装环境引用的提示词变体；环境未引用变体时恢复原始文件。
+// 返回附加到应用结果中的说明
+func (a *App) applyEnvPromptVariant(env *EnvConfig) (string, error) {
+	provider := strings.ToLower(strings.TrimSpace(env.Provider))
+	if provider == "" {
+		provider = "claude"
+	}
+
+	name := strings.TrimSpace(env.PromptVariant)
+	if name == "" {
+		promptVariantMu.Lock()
+		state, err := loadPromptVariantState()
+		promptVariantMu.Unlock()
+		if err != nil {
+			return "", err
+		}
+		if state.Active[provider] == "" {
+			return "", nil
+		}
+		if err := a.restorePromptBase(provider); err != nil {
+			return "", err
+		}
+		return "已恢复原始提示词文件", nil
+	}
+
+	promptLibraryMu.Lock()
+	lib, err := loadPromptLibrary()
+	promptLibraryMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	for _, variant := range lib.Variants {
+		if variant.Name != name {
+			continue
+		}
+		if variant.Provider != provider {
+			return "", fmt.Errorf("提示词变体 '%s' 属于 %s，不能用于 %s", name, variant.Provider, provider)
+		}
+		if err := a.installPromptVariant(variant); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("已安装提示词变体 %s", name), nil
+	}
+	return "", fmt.Errorf("提示词变体 '%s' 不存在", name)
+}
+
+// withPromptVariant 在环境配置应用成功后处理提示词变体，并将结果附加到 msg
+func (a *App) withPromptVariant(env *EnvConfig, msg string) string {
+	note, err := a.applyEnvPromptVariant(env)
+	if err != nil {
+		return msg + "（提示词变体应用失败: " + err.Error() + "）"
+	}
+	if note != "" {
+		return msg + "（" + note + "）"
+	}
+	return msg
+}
+
+// installPromptVariant 写入变体内容；首次安装时保存用户原有的文件
+func (a *App) installPromptVariant(variant PromptVariant) error {
+	file, err := a.resolvePromptFile(variant.Provider)
+	if err != nil {
+		return err
+	}
+
+	promptVariantMu.Lock()
+	state, err := loadPromptVariantState()
+	if err != nil {
+		promptVariantMu.Unlock()
+		return err
+	}
+	if err := a.checkPromptVariantUnmodified(file, state); err != nil {
+		promptVariantMu.Unlock()
+		return err
+	}
+	if state.Active[file.Provider] == "" {
+		base := promptBaseSnapshot{}
+		if data, err := os.ReadFile(file.Path); err == nil {
+			base = promptBaseSnapshot{Content: string(data), Exists: true}
+		} else if !os.IsNotExist(err) {
+			promptVariantMu.Unlock()
+			return fmt.Errorf("读取文件失败: %v", err)
+		}
+		state.Base[file.Provider] = base
+	}
+	state.Active[file.Provider] = variant.Name
+	state.Installed[file.Provider] = variant.Content
+	err = savePromptVariantState(state)
+	promptVariantMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词变体状态失败: %v", err)
+	}
+
+	// 提示词库托管区块同样渲染进变体
+	content, err := a.applyPromptRecipes(file, variant.Content)
+	if err != nil {
+		return err
+	}
+	return a.writePromptFile(file, content, promptSourceVariant)
+}
+
+// restorePromptBase 恢复安装变体前的原始文件；没有已安装的变体时不做任何操作。
+// 变体安装后文件被修改过时拒绝恢复，避免覆盖这些修改
+func (a *App) restorePromptBase(provider string) error {
+	file, err := a.resolvePromptFile(provider)
+	if err != nil {
+		return err
+	}
+
+	promptVariantMu.Lock()
+	state, err := loadPromptVariantState()
+	if err != nil {
+		promptVariantMu.Unlock()
+		return err
+	}
+	if state.Active[provider] == "" {
+		promptVariantMu.Unlock()
+		return nil
+	}
+	if err := a.checkPromptVariantUnmodified(file, state); err != nil {
+		promptVariantMu.Unlock()
+		return err
+	}
+	base := state.Base[provider]
+	delete(state.Active, provider)
+	delete(state.Base, provider)
+	delete(state.Installed, provider)
+	err = savePromptVariantState(state)
+	promptVariantMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("保存提示词变体状态失败: %v", err)
+	}
+
+	if !base.Exists {
+		return a.removePromptFile(file, promptSourceVariant)
+	}
+	content, err := a.applyPromptRecipes(file, base.Content)
+	if err != nil {
+		return err
+	}
+	return a.writePromptFile(file, content, promptSourceVariant)
+}
+
+// checkPromptVariantUnmodified 已安装变体的文件在安装后被修改（如通过 SavePromptFile）时返回错误；
+// 提示词库托管区块的变化不算修改。文件已被删除或旧版本未记录安装内容时不检查
+func (a *App) checkPromptVariantUnmodified(file PromptFile, state promptVariantState) error {
+	name := state.Active[file.Provider]
+	installed, ok := state.Installed[file.Provider]
+	if name == "" || !ok {
+		return nil
+	}
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("读取文件失败: %v", err)
+	}
+	current, err := a.applyPromptRecipes(file, string(data))
+	if err != nil {
+		return err
+	}
+	expected, err := a.applyPromptRecipes(file, installed)
+	if err != nil {
+		return err
+	}
+	if current != expected {
+		return fmt.Errorf("%s 在安装提示词变体 %s 后被修改，为避免覆盖这些修改已停止切换；请把修改保存到变体中，或在历史版本中恢复变体内容后重试", file.Path, name)
+	}
+	return nil
+}
+
+func loadPromptVariantState() (promptVariantState, error) {
+	state := promptVariantState{}
+	if _, err := loadJSONStore(promptVariantStateFile, &state); err != nil {
+		return state, err
+	}
+	if state.Base == nil {
+		state.Base = map[string]promptBaseSnapshot{}
+	}
+	if state.Active == nil {
+		state.Active = map[string]string{}
+	}
+	if state.Installed == nil {
+		state.Installed = map[string]string{}
+	}
+	return state, nil
+}
+
+func savePromptVariantState(state promptVariantState) error {
+	return saveJSONStore(promptVariantStateFile, state, 0o644)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestorePromptBaseKeepsEditsMadeWhileVariantActive(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	path := filepath.Join(home, ".claude", "CLAUDE.md")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("原始内容\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &App{}
+	if err := a.installPromptVariant(PromptVariant{Name: "review", Provider: "claude", Content: "变体内容\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SavePromptFile("claude", "变体内容\n用户追加的内容\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.restorePromptBase("claude"); err == nil {
+		t.Fatal("restore should refuse to overwrite edits made while the variant was active")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "变体内容\n用户追加的内容\n" {
+		t.Fatalf("edited file was overwritten: %q", data)
+	}
+
+	// 改回安装时的内容后可以恢复原始文件
+	if err := a.SavePromptFile("claude", "变体内容\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.restorePromptBase("claude"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "原始内容\n" {
+		t.Fatalf("base not restored: %q", data)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	proxyModeSystem = ""       // 沿用系统环境变量（HTTPS_PROXY / HTTP_PROXY / NO_PROXY）
+	proxyModeNone   = "none"   // 直连，忽略系统代理
+	proxyModeCustom = "custom" // 使用 URL 指定的代理
+)
+
+// ProxyConfig HTTP 代理设置；环境未设置时使用全局设置
+type ProxyConfig struct {
+	Mode string `json:"mode,omitempty"` // ""（系统）/ none / custom
+	// URL 代理地址，支持 http / https / socks5 / socks5h
+	URL string `json:"url,omitempty"`
+	// NoProxy 不走代理的主机：example.com（含子域名）、.example.com（仅子域名）、
+	// IP、CIDR、host:port，"*" 表示全部直连
+	NoProxy []string `json:"no_proxy,omitempty"`
+	// WriteToCLI 应用环境时写入 HTTPS_PROXY / HTTP_PROXY / NO_PROXY（Claude / Gemini）
+	WriteToCLI bool `json:"write_to_cli,omitempty"`
+}
+
+// GetProxySettings 获取全局代理设置
+func (a *App) GetProxySettings() ProxyConfig {
+	if proxy := a.configSnapshot().Proxy; proxy != nil {
+		return *proxy
+	}
+	return ProxyConfig{}
+}
+
+// SaveProxySettings 保存全局代理设置
+func (a *App) SaveProxySettings(cfg ProxyConfig) error {
+	normalized, err := normalizeProxyConfig(&cfg)
+	if err != nil {
+		return err
+	}
+	a.config.Proxy = normalized
+	return a.saveConfig()
+}
+
+// normalizeProxyConfig 校验并整理代理设置；系统模式且无其他设置时返回 nil
+func normalizeProxyConfig(cfg *ProxyConfig) (*ProxyConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	out := &ProxyConfig{
+		Mode:       strings.ToLower(strings.TrimSpace(cfg.Mode)),
+		URL:        strings.TrimSpace(cfg.URL),
+		NoProxy:    normalizeStringList(cfg.NoProxy),
+		WriteToCLI: cfg.WriteToCLI,
+	}
+	switch out.Mode {
+	case proxyModeSystem, proxyModeNone:
+		out.URL = ""
+	case proxyModeCustom:
+		if _, err := parseProxyURL(out.URL); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的代理模式: %s", cfg.Mode)
+	}
+	for _, entry := range out.NoProxy {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("NO_PROXY 条目无效: %s", entry)
+			}
+		}
+	}
+	if out.Mode == proxyModeSystem && !out.WriteToCLI && len(out.NoProxy) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("代理地址为空")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("代理地址无效: %s", raw)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("不支持的代理协议: %s（支持 http / https / socks5）", parsed.Scheme)
+	}
+	return parsed, nil
+}
+
+// effectiveProxy 返回环境生效的代理设置：环境有设置时使用环境的，否则使用全局的
+func (a *App) effectiveProxy(env *EnvConfig) *ProxyConfig {
+	if env != nil && env.Proxy != nil {
+		return env.Proxy
+	}
+	return a.configSnapshot().Proxy
+}
+
+// proxyForURL 返回访问 URL 时使用的代理设置：URL 属于某个环境时使用该环境的设置
+func (a *App) proxyForURL(urlStr string) *ProxyConfig {
+	urlStr = strings.TrimRight(strings.TrimSpace(urlStr), "/")
+	cfg := a.configSnapshot()
+	for i := range cfg.Environments {
+		env := &cfg.Environments[i]
+		if env.Proxy == nil {
+			continue
+		}
+		if strings.TrimRight(deriveEnvURL(*env), "/") == urlStr {
+			return env.Proxy
+		}
+	}
+	return cfg.Proxy
+}
+
+// newProxyTransport 按代理设置创建 Transport；cfg 为 nil 时沿用系统环境变量
+func newProxyTransport(cfg *ProxyConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(cfg)
+	return transport
+}
+
+func newProxyClient(cfg *ProxyConfig, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: newProxyTransport(cfg), Timeout: timeout}
+}
+
+func proxyFunc(cfg *ProxyConfig) func(*http.Request) (*url.URL, error) {
+	if cfg == nil {
+		return http.ProxyFromEnvironment
+	}
+	switch cfg.Mode {
+	case proxyModeNone:
+		return nil
+	case proxyModeCustom:
+		proxyURL, err := parseProxyURL(cfg.URL)
+		noProxy := cfg.NoProxy
+		return func(req *http.Request) (*url.URL, error) {
+			if err != nil {
+				return nil, err
+			}
+			if matchNoProxy(noProxy, req.URL) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	default:
+		if len(cfg.NoProxy) == 0 {
+			return http.ProxyFromEnvironment
+		}
+		noProxy := cfg.NoProxy
+		return func(req *http.Request) (*url.URL, error) {
+			if matchNoProxy(noProxy, req.URL) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+	}
+}
+
+// matchNoProxy 判断目标是否命中 NO_PROXY 列表（规则与 curl / Go 的 NO_PROXY 一致）
+func matchNoProxy(entries []string, target *url.URL) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		if target.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		entryHost = strings.Trim(entryHost, "[]")
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(entryHost, "*.") {
+			entryHost = entryHost[1:]
+		}
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyCLIEnv 返回写入 CLI 配置的代理变量；未开启 WriteToCLI 时返回 nil
+func proxyCLIEnv(cfg *ProxyConfig) map[string]string {
+	if cfg == nil || !cfg.WriteToCLI {
+		return nil
+	}
+	vars := map[string]string{}
+	switch cfg.Mode {
+	case proxyModeCustom:
+		proxyURL, err := parseProxyURL(cfg.URL)
+		if err != nil {
+			return nil
+		}
+		vars["HTTPS_PROXY"] = proxyURL.String()
+		vars["HTTP_PROXY"] = proxyURL.String()
+		if len(cfg.NoProxy) > 0 {
+			vars["NO_PROXY"] = strings.Join(cfg.NoProxy, ",")
+		}
+	case proxyModeNone:
+		vars["NO_PROXY"] = "*"
+	default:
+		if len(cfg.NoProxy) > 0 {
+			vars["NO_PROXY"] = strings.Join(cfg.NoProxy, ",")
+		}
+	}
+	return vars
+}
+
+// appendProxyEnvLines 将代理变量追加到 .env 内容；模板中已有的变量不覆盖
+func appendProxyEnvLines(content string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return content
+	}
+	existing := map[string]struct{}{}
+	for _, line := range strings.Split(content, "\n") {
+		if idx := strings.Index(line, "="); idx > 0 {
+			existing[strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[:idx]), "export "))] = struct{}{}
+		}
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	for _, key := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"} {
+		value, ok := vars[key]
+		if !ok {
+			continue
+		}
+		if _, exists := existing[key]; exists {
+			continue
+		}
+		content += key + "=" + value + "\n"
+	}
+	return content
+}
+
+// loadGlobalProxyConfig 从主配置文件读取全局代理设置（供不持有 App 的服务使用）
+func loadGlobalProxyConfig() *ProxyConfig {
+	data, err := os.ReadFile(resolveMainConfigPath())
+	if err != nil {
+		return nil
+	}
+	var cfg struct {
+		Proxy *ProxyConfig `json:"proxy"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil
+	}
+	return cfg.Proxy
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// storePath 返回 ~/.claude-env-switcher 下的文件路径，目录不存在时创建
+func storePath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, mcpStoreDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// loadJSONStore 读取存储目录下的 JSON 文件，见 readJSONFile
+func loadJSONStore(name string, v any) (bool, error) {
+	path, err := storePath(name)
+	if err != nil {
+		return false, err
+	}
+	return readJSONFile(path, v)
+}
+
+// saveJSONStore 写入存储目录下的 JSON 文件，见 writeJSONFile
+func saveJSONStore(name string, v any, perm os.FileMode) error {
+	path, err := storePath(name)
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(path, v, perm)
+}
+
+// readJSONFile 读取 JSON 文件到 v；文件不存在或为空时返回 false 且不修改 v。
+// 格式错误时返回错误而不是当作空文件，避免之后保存时覆盖原有数据
+func readJSONFile(path string, v any) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("解析 %s 失败（请修复或删除该文件）: %v", path, err)
+	}
+	return true, nil
+}
+
+// writeJSONFile 先写同目录下的临时文件再替换，读取方不会看到写了一半的内容
+func writeJSONFile(path string, v any, perm os.FileMode) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadJSONFileRejectsCorruptData(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	var state codexState
+	if found, err := loadJSONStore(codexStateFile, &state); err != nil || found {
+		t.Fatalf("missing file: found=%v err=%v", found, err)
+	}
+	state.ManagedProfiles = []string{"a"}
+	if err := saveJSONStore(codexStateFile, state, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := storePath(codexStateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCodexState(); err == nil {
+		t.Fatal("corrupt state should return an error")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	timingDefaultSamples = 5
+	timingMaxSamples     = 50
+	timingTimeout        = 10 * time.Second
+	timingBodyLimit      = 64 * 1024
+)
+
+// TimingSample 单次请求的耗时分解（毫秒）；连接复用时 DNS / TCP / TLS 为 0
+type TimingSample struct {
+	DNSMs      float64 `json:"dns_ms"`
+	ConnectMs  float64 `json:"connect_ms"`
+	TLSMs      float64 `json:"tls_ms"`
+	TTFBMs     float64 `json:"ttfb_ms"`   // 请求开始到首字节
+	ServerMs   float64 `json:"server_ms"` // 请求发送完毕到首字节（服务端处理 + 一次往返）
+	TotalMs    float64 `json:"total_ms"`
+	RemoteAddr string  `json:"remote_addr,omitempty"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// TimingStats 某一阶段在多次采样中的统计
+type TimingStats struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	Max float64 `json:"max"`
+}
+
+// TimingSummary 一组采样的统计结果
+type TimingSummary struct {
+	Network   string                 `json:"network"` // tcp / tcp4 / tcp6
+	Samples   []TimingSample         `json:"samples"`
+	Successes int                    `json:"successes"`
+	Failures  int                    `json:"failures"`
+	Stats     map[string]TimingStats `json:"stats"` // dns / connect / tls / ttfb / server / total
+}
+
+// TimingReport 端点耗时分析报告
+type TimingReport struct {
+	URL       string         `json:"url"`
+	Host      string         `json:"host"`
+	Addresses []string       `json:"addresses"`
+	Proxy     string         `json:"proxy,omitempty"` // 经代理访问时的代理地址（此时连接耗时为到代理的耗时）
+	Default   TimingSummary  `json:"default"`
+	IPv4      *TimingSummary `json:"ipv4,omitempty"` // 同时有 A 与 AAAA 记录时分别测量
+	IPv6      *TimingSummary `json:"ipv6,omitempty"`
+	Diagnosis []string       `json:"diagnosis"`
+}
+
+// TestLatencyTiming 对 URL 采样 samples 次，给出 DNS / TCP / TLS / 首字节 / 总耗时的分解；
+// 域名同时解析出 IPv4 与 IPv6 地址时分别测量并比较
+func (a *App) TestLatencyTiming(urlStr string, samples int) (TimingReport, error) {
+	return runTimingReport(urlStr, samples, newProxyTransport(a.proxyForURL(urlStr)))
+}
+
+// TestEnvLatencyTiming 对环境的端点做耗时分析
+func (a *App) TestEnvLatencyTiming(envName string, samples int) (TimingReport, error) {
+	env := a.findEnv(envName)
+	if env == nil {
+		return TimingReport{}, fmt.Errorf("环境 '%s' 不存在", envName)
+	}
+	urlStr := deriveEnvURL(*env)
+	if urlStr == "" {
+		return TimingReport{}, fmt.Errorf("环境 '%s' 没有可测试的 URL", envName)
+	}
+	return runTimingReport(urlStr, samples, newProxyTransport(a.effectiveProxy(env)))
+}
+
+// runTimingReport 执行采样；transport 为 nil 时使用默认设置（便于测试替换）
+func runTimingReport(urlStr string, samples int, transport *http.Transport) (TimingReport, error) {
+	parsed, err := url.Parse(urlStr)
+	if err != nil || parsed.Host == "" {
+		return TimingReport{}, fmt.Errorf("URL 无效: %s", urlStr)
+	}
+	if samples <= 0 {
+		samples = timingDefaultSamples
+	}
+	if samples > timingMaxSamples {
+		samples = timingMaxSamples
+	}
+
+	report := TimingReport{URL: urlStr, Host: parsed.Hostname(), Addresses: []string{}}
+	hasV4, hasV6 := false, false
+	if ip := net.ParseIP(report.Host); ip != nil {
+		report.Addresses = append(report.Addresses, ip.String())
+	} else if addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), report.Host); err == nil {
+		for _, addr := range addrs {
+			report.Addresses = append(report.Addresses, addr.IP.String())
+			if addr.IP.To4() != nil {
+				hasV4 = true
+			} else {
+				hasV6 = true
+			}
+		}
+	}
+
+	if transport != nil && transport.Proxy != nil {
+		if req, err := http.NewRequest(http.MethodGet, urlStr, nil); err == nil {
+			if proxyURL, err := transport.Proxy(req); err == nil && proxyURL != nil {
+				report.Proxy = proxyURL.Redacted()
+			}
+		}
+	}
+
+	report.Default = sampleTiming(urlStr, samples, "tcp", transport)
+	// 经代理时本地只连接代理，按地址族比较没有意义
+	if hasV4 && hasV6 && report.Proxy == "" {
+		v4 := sampleTiming(urlStr, samples, "tcp4", transport)
+		v6 := sampleTiming(urlStr, samples, "tcp6", transport)
+		report.IPv4 = &v4
+		report.IPv6 = &v6
+	}
+	report.Diagnosis = diagnoseTiming(report)
+	return report, nil
+}
+
+// sampleTiming 每次采样使用新连接，以测量完整的 DNS / TCP / TLS 耗时
+func sampleTiming(urlStr string, samples int, network string, base *http.Transport) TimingSummary {
+	summary := TimingSummary{Network: network, Samples: []TimingSample{}}
+	for i := 0; i < samples; i++ {
+		var transport *http.Transport
+		if base != nil {
+			transport = base.Clone()
+		} else {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+		transport.DisableKeepAlives = true
+		dialer := &net.Dialer{Timeout: timingTimeout}
+		transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		client := &http.Client{Transport: transport, Timeout: timingTimeout}
+
+		sample := traceRequest(client, urlStr)
+		if sample.Error == "" {
+			summary.Successes++
+		} else {
+			summary.Failures++
+		}
+		summary.Samples = append(summary.Samples, sample)
+		transport.CloseIdleConnections()
+	}
+	summary.Stats = summarizeTiming(summary.Samples)
+	return summary
+}
+
+// timingTracer 通过 httptrace 记录一次请求的各阶段耗时
+type timingTracer struct {
+	sample                                             *TimingSample
+	dnsStart, connectStart, tlsStart, wrote, firstByte time.Time
+}
+
+func (t *timingTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !t.dnsStart.IsZero() {
+				t.sample.DNSMs = msSince(t.dnsStart)
+			}
+		},
+		ConnectStart: func(_, _ string) { t.connectStart = time.Now() },
+		ConnectDone: func(_, addr string, err error) {
+			if err == nil && !t.connectStart.IsZero() {
+				t.sample.ConnectMs = msSince(t.connectStart)
+				t.sample.RemoteAddr = addr
+			}
+		},
+		TLSHandshakeStart: func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			if !t.tlsStart.IsZero() {
+				t.sample.TLSMs = msSince(t.tlsStart)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if t.sample.RemoteAddr == "" && info.Conn != nil {
+				t.sample.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.wrote = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+}
+
+// trace 为请求挂载 httptrace
+func (t *timingTracer) trace(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), t.clientTrace()))
+}
+
+// finish 根据请求开始时间计算首字节与服务端耗时
+func (t *timingTracer) finish(start time.Time) {
+	if t.firstByte.IsZero() {
+		return
+	}
+	t.sample.TTFBMs = roundMs(t.firstByte.Sub(start))
+	if !t.wrote.IsZero() {
+		t.sample.ServerMs = roundMs(t.firstByte.Sub(t.wrote))
+	}
+}
+
+// traceRequest 发送一次 GET 请求并记录各阶段耗时
+func traceRequest(client *http.Client, urlStr string) TimingSample {
+	var sample TimingSample
+	tracer := &timingTracer{sample: &sample}
+
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+
+	start := time.Now()
+	resp, err := client.Do(tracer.trace(req))
+	if err != nil {
+		sample.TotalMs = msSince(start)
+		sample.Error = err.Error()
+		return sample
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, timingBodyLimit))
+	resp.Body.Close()
+
+	sample.TotalMs = msSince(start)
+	sample.StatusCode = resp.StatusCode
+	tracer.finish(start)
+	return sample
+}
+
+// summarizeTiming 统计成功采样的各阶段耗时
+func summarizeTiming(samples []TimingSample) map[string]TimingStats {
+	phases := map[string]func(TimingSample) float64{
+		"dns":     func(s TimingSample) float64 { return s.DNSMs },
+		"connect": func(s TimingSample) float64 { return s.ConnectMs },
+		"tls":     func(s TimingSample) float64 { return s.TLSMs },
+		"ttfb":    func(s TimingSample) float64 { return s.TTFBMs },
+		"server":  func(s TimingSample) float64 { return s.ServerMs },
+		"total":   func(s TimingSample) float64 { return s.TotalMs },
+	}
+	stats := map[string]TimingStats{}
+	for phase, value := range phases {
+		var values []float64
+		for _, sample := range samples {
+			if sample.Error == "" {
+				values = append(values, value(sample))
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		sort.Float64s(values)
+		stats[phase] = TimingStats{
+			Min: values[0],
+			P50: percentile(values, 50),
+			P95: percentile(values, 95),
+			Max: values[len(values)-1],
+		}
+	}
+	return stats
+}
+
+// percentile 最近秩法求百分位（values 需已排序）
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// diagnoseTiming 根据各阶段耗时给出定位建议：本地网络（DNS / 连接）还是中转服务端
+func diagnoseTiming(report TimingReport) []string {
+	diagnosis := []string{}
+	stats := report.Default.Stats
+	if report.Default.Successes == 0 {
+		if report.Default.Failures > 0 {
+			diagnosis = append(diagnosis, "所有请求均失败: "+report.Default.Samples[0].Error)
+		}
+	} else {
+		if dns := stats["dns"].P50; dns > 150 {
+			diagnosis = append(diagnosis, fmt.Sprintf("DNS 解析较慢（p50 %.0fms），可能是本地 DNS 问题", dns))
+		}
+		if connect := stats["connect"].P50; connect > 300 {
+			diagnosis = append(diagnosis, fmt.Sprintf("TCP 连接较慢（p50 %.0fms），到服务器的网络往返时间长", connect))
+		}
+		if tlsMs, connect := stats["tls"].P50, stats["connect"].P50; tlsMs > 500 && tlsMs > 3*connect {
+			diagnosis = append(diagnosis, fmt.Sprintf("TLS 握手较慢（p50 %.0fms），可能存在代理或中间设备", tlsMs))
+		}
+		if server := stats["server"].P50; server > 1000 {
+			diagnosis = append(diagnosis, fmt.Sprintf("服务端响应较慢（p50 %.0fms），瓶颈在中转服务而非本地网络", server))
+		}
+		if p50, p95 := stats["total"].P50, stats["total"].P95; p50 > 0 && p95 > 3*p50 && p95-p50 > 500 {
+			diagnosis = append(diagnosis, fmt.Sprintf("耗时波动大（p50 %.0fms / p95 %.0fms）", p50, p95))
+		}
+		if report.Default.Failures > 0 {
+			diagnosis = append(diagnosis, fmt.Sprintf("%d/%d 次请求失败", report.Default.Failures, len(report.Default.Samples)))
+		}
+	}
+
+	if report.Proxy != "" {
+		diagnosis = append(diagnosis, "经代理 "+report.Proxy+" 访问，TCP 连接耗时为到代理的耗时")
+	}
+
+	if report.IPv4 != nil && report.IPv6 != nil {
+		v4, v6 := report.IPv4, report.IPv6
+		switch {
+		case v6.Successes == 0 && v4.Successes > 0:
+			diagnosis = append(diagnosis, "IPv6 不可用而 IPv4 正常，建议优先使用 IPv4")
+		case v4.Successes == 0 && v6.Successes > 0:
+			diagnosis = append(diagnosis, "IPv4 不可用而 IPv6 正常")
+		case v4.Successes > 0 && v6.Successes > 0:
+			t4, t6 := v4.Stats["total"].P50, v6.Stats["total"].P50
+			if diff := t6 - t4; diff > 50 && diff > 0.3*t4 {
+				diagnosis = append(diagnosis, fmt.Sprintf("IPv6 明显慢于 IPv4（p50 %.0fms vs %.0fms）", t6, t4))
+			} else if diff := t4 - t6; diff > 50 && diff > 0.3*t6 {
+				diagnosis = append(diagnosis, fmt.Sprintf("IPv4 明显慢于 IPv6（p50 %.0fms vs %.0fms）", t4, t6))
+			}
+		}
+	}
+
+	if len(diagnosis) == 0 && report.Default.Successes > 0 {
+		diagnosis = append(diagnosis, "各阶段耗时正常")
+	}
+	return diagnosis
+}
+
+func msSince(start time.Time) float64 {
+	return roundMs(time.Since(start))
+}
+
+func roundMs(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())/10) / 100
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+var tomlBareKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlDocument 按行保存的 TOML 文档：只改写涉及的键与表，保留用户的注释、空行与键的顺序
+type tomlDocument struct {
+	lines   []string
+	entries []tomlEntry
+}
+
+// tomlEntry 文档中的一个表头或键值（键值可能跨多行）
+type tomlEntry struct {
+	header bool     // [a.b] 或 [[a.b]]
+	array  bool     // [[a.b]]
+	path   []string // 表头为表名；键值为所在表加上键的完整路径
+	key    string   // 键值行中 = 之前的原文（含缩进）
+	start  int      // 起始行
+	end    int      // 结束行（不含）
+}
+
+// tomlEdit 将 [start, end) 行替换为 lines
+type tomlEdit struct {
+	start, end int
+	lines      []string
+}
+
+// parseTOMLDocument 解析 TOML 文本；内容不是有效 TOML 时返回错误
+func parseTOMLDocument(data []byte) (*tomlDocument, error) {
+	var check map[string]any
+	if err := toml.Unmarshal(data, &check); err != nil {
+		return nil, err
+	}
+	doc := &tomlDocument{}
+	text := strings.TrimSuffix(string(data), "\n")
+	if strings.TrimSpace(text) != "" {
+		doc.lines = strings.Split(text, "\n")
+	}
+	if err := doc.index(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Bytes 返回文档内容
+func (d *tomlDocument) Bytes() []byte {
+	if len(d.lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(d.lines, "\n") + "\n")
+}
+
+// Map 将文档解析为通用结构，用于读取
+func (d *tomlDocument) Map() (map[string]any, error) {
+	payload := map[string]any{}
+	if err := toml.Unmarshal(d.Bytes(), &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Get 读取点分路径对应的值
+func (d *tomlDocument) Get(path []string) (any, bool) {
+	payload, err := d.Map()
+	if err != nil {
+		return nil, false
+	}
+	return getMapPath(payload, strings.Join(path, "."))
+}
+
+// Set 写入一个键：已有时只替换该键的值（保留键的写法与行尾注释），
+// 否则追加到所在表的末尾；表不存在时在文档末尾新建
+func (d *tomlDocument) Set(path []string, value any) error {
+	if len(path) == 0 {
+		return fmt.Errorf("TOML 键不能为空")
+	}
+	text, err := tomlValueText(value)
+	if err != nil {
+		return err
+	}
+	if i := d.findKey(path); i >= 0 {
+		entry := d.entries[i]
+		line := strings.TrimRight(entry.key, " \t") + " = " + text
+		if entry.end == entry.start+1 {
+			if comment := tomlLineComment(d.lines[entry.start]); comment != "" {
+				line += " " + comment
+			}
+		}
+		return d.apply([]tomlEdit{{start: entry.start, end: entry.end, lines: []string{line}}})
+	}
+
+	table, key := path[:len(path)-1], path[len(path)-1]
+	line := formatTOMLKey(key) + " = " + text
+	if len(table) == 0 {
+		return d.apply([]tomlEdit{d.rootInsertion(line)})
+	}
+	if h := d.findHeader(table); h >= 0 {
+		at := d.sectionEnd(h)
+		return d.apply([]tomlEdit{{start: at, end: at, lines: []string{line}}})
+	}
+	return d.apply([]tomlEdit{d.appendSection("["+formatTOMLPath(table)+"]", line)})
+}
+
+// Delete 删除一个键或整张表（含子表与以点分键写在别处的键），返回是否有改动
+func (d *tomlDocument) Delete(path []string) bool {
+	edits := d.deletions(path, -1)
+	if len(edits) == 0 {
+		return false
+	}
+	_ = d.apply(edits)
+	return true
+}
+
+// SetTable 用 values 替换整张表：表已存在时在原位置改写，否则追加到文档末尾
+func (d *tomlDocument) SetTable(path []string, values map[string]any) error {
+	lines := []string{"[" + formatTOMLPath(path) + "]"}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text, err := tomlValueText(values[key])
+		if err != nil {
+			return err
+		}
+		lines = append(lines, formatTOMLKey(key)+" = "+text)
+	}
+
+	h := d.findHeader(path)
+	edits := d.deletions(path, h)
+	if h >= 0 {
+		edits = append(edits, tomlEdit{start: d.entries[h].start, end: d.sectionEnd(h), lines: lines})
+	} else {
+		edits = append(edits, d.appendSection(lines[0], lines[1:]...))
+	}
+	return d.apply(edits)
+}
+
+// Merge 将 values 逐键写入文档：表递归合并，其余值整体替换，values 未涉及的内容保持不变
+func (d *tomlDocument) Merge(values map[string]any) error {
+	return d.merge(nil, values)
+}
+
+func (d *tomlDocument) merge(table []string, values map[string]any) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path := append(append([]string{}, table...), key)
+		sub, isTable := values[key].(map[string]any)
+		if !isTable {
+			if d.findKey(path) < 0 {
+				d.Delete(path) // 原来是表
+			}
+			if err := d.Set(path, values[key]); err != nil {
+				return err
+			}
+			continue
+		}
+		if d.findKey(path) >= 0 {
+			if inline, ok := d.Get(path); ok {
+				if inline, ok := inline.(map[string]any); ok {
+					// 内联表整体改写，保留其中未涉及的键
+					deepMergeMap(inline, sub)
+					if err := d.Set(path, inline); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			d.Delete(path) // 原来是普通值
+		}
+		if len(sub) == 0 {
+			if _, ok := d.Get(path); !ok {
+				if err := d.SetTable(path, sub); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := d.merge(path, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletions 生成删除 path 下所有表头与键值的编辑；keep 为保留（由调用方改写）的表头
+func (d *tomlDocument) deletions(path []string, keep int) []tomlEdit {
+	var edits []tomlEdit
+	for i, entry := range d.entries {
+		if !tomlPathHasPrefix(entry.path, path) {
+			continue
+		}
+		if entry.header {
+			if i == keep {
+				continue
+			}
+			end := d.sectionEnd(i)
+			// 删除整节时顺带去掉其后的一个空行，避免留下成片空行
+			if end < len(d.lines) && strings.TrimSpace(d.lines[end]) == "" {
+				end++
+			}
+			edits = append(edits, tomlEdit{start: entry.start, end: end})
+			continue
+		}
+		if keep >= 0 && d.ownerHeader(i) == keep {
+			continue
+		}
+		if owner := d.ownerHeader(i); owner >= 0 && tomlPathHasPrefix(d.entries[owner].path, path) {
+			continue // 随表头一起删除
+		}
+		edits = append(edits, tomlEdit{start: entry.start, end: entry.end})
+	}
+	return edits
+}
+
+// apply 自下而上执行编辑并重建索引
+func (d *tomlDocument) apply(edits []tomlEdit) error {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, edit := range edits {
+		next := make([]string, 0, len(d.lines)-(edit.end-edit.start)+len(edit.lines))
+		next = append(next, d.lines[:edit.start]...)
+		next = append(next, edit.lines...)
+		next = append(next, d.lines[edit.end:]...)
+		d.lines = next
+	}
+	// 删除末尾的表后不留下空行
+	for n := len(d.lines); n > 0 && strings.TrimSpace(d.lines[n-1]) == ""; n-- {
+		d.lines = d.lines[:n-1]
+	}
+	return d.index()
+}
+
+// rootInsertion 顶层键插入在最后一个顶层键之后；没有顶层键时插在第一个表（及其上方的注释）之前
+func (d *tomlDocument) rootInsertion(line string) tomlEdit {
+	last, header := -1, -1
+	for _, entry := range d.entries {
+		if entry.header {
+			header = entry.start
+			break
+		}
+		last = entry.end
+	}
+	switch {
+	case last >= 0:
+		return tomlEdit{start: last, end: last, lines: []string{line}}
+	case header >= 0:
+		at := header
+		for at > 0 && strings.HasPrefix(strings.TrimSpace(d.lines[at-1]), "#") {
+			at--
+		}
+		return tomlEdit{start: at, end: at, lines: []string{line, ""}}
+	}
+	return tomlEdit{start: len(d.lines), end: len(d.lines), lines: []string{line}}
+}
+
+// appendSection 在文档末尾追加一节
+func (d *tomlDocument) appendSection(header string, lines ...string) tomlEdit {
+	section := []string{}
+	if n := len(d.lines); n > 0 && strings.TrimSpace(d.lines[n-1]) != "" {
+		section = append(section, "")
+	}
+	section = append(section, header)
+	section = append(section, lines...)
+	return tomlEdit{start: len(d.lines), end: len(d.lines), lines: section}
+}
+
+func (d *tomlDocument) findKey(path []string) int {
+	for i, entry := range d.entries {
+		if !entry.header && tomlPathEqual(entry.path, path) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *tomlDocument) findHeader(path []string) int {
+	for i, entry := range d.entries {
+		if entry.header && !entry.array && tomlPathEqual(entry.path, path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sectionEnd 表头 h 下最后一个键值之后的行；表尾的注释与空行留给下一张表
+func (d *tomlDocument) sectionEnd(h int) int {
+	end := d.entries[h].end
+	for i := h + 1; i < len(d.entries) && !d.entries[i].header; i++ {
+		end = d.entries[i].end
+	}
+	return end
+}
+
+// ownerHeader 键值所在的表头下标，顶层键返回 -1
+func (d *tomlDocument) ownerHeader(i int) int {
+	for j := i - 1; j >= 0; j-- {
+		if d.entries[j].header {
+			return j
+		}
+	}
+	return -1
+}
+
+// index 扫描每一行，记录表头与键值的位置；多行字符串与跨行数组按一个键值处理
+func (d *tomlDocument) index() error {
+	d.entries = d.entries[:0]
+	var table []string
+	for i := 0; i < len(d.lines); {
+		line := strings.TrimSpace(d.lines[i])
+		if line == "" || line[0] == '#' {
+			i++
+			continue
+		}
+		if line[0] == '[' {
+			array := strings.HasPrefix(line, "[[")
+			path, rest, ok := parseTOMLKey(strings.TrimLeft(line, "["))
+			if !ok || !strings.HasPrefix(rest, "]") {
+				return fmt.Errorf("第 %d 行: 无法识别的表头", i+1)
+			}
+			table = path
+			d.entries = append(d.entries, tomlEntry{header: true, array: array, path: path, start: i, end: i + 1})
+			i++
+			continue
+		}
+		raw := d.lines[i]
+		key, rest, ok := parseTOMLKey(strings.TrimLeft(raw, " \t"))
+		if !ok || !strings.HasPrefix(rest, "=") {
+			return fmt.Errorf("第 %d 行: 无法识别的键值", i+1)
+		}
+		var scanner tomlValueScanner
+		scanner.feed(rest[1:])
+		end := i + 1
+		for !scanner.complete() && end < len(d.lines) {
+			scanner.feed(d.lines[end])
+			end++
+		}
+		full := append(append([]string{}, table...), key...)
+		d.entries = append(d.entries, tomlEntry{path: full, key: raw[:len(raw)-len(rest)], start: i, end: end})
+		i = end
+	}
+	return nil
+}
+
+// parseTOMLKey 解析行首的（点分）键，返回各段与剩余内容
+func parseTOMLKey(s string) ([]string, string, bool) {
+	var parts []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return nil, "", false
+		}
+		switch s[0] {
+		case '"':
+			i := 1
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return nil, "", false
+			}
+			part, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return nil, "", false
+			}
+			parts = append(parts, part)
+			s = s[i+1:]
+		case '\'':
+			i := strings.IndexByte(s[1:], '\'')
+			if i < 0 {
+				return nil, "", false
+			}
+			parts = append(parts, s[1:i+1])
+			s = s[i+2:]
+		default:
+			i := 0
+			for i < len(s) && (s[i] == '_' || s[i] == '-' || s[i] >= '0' && s[i] <= '9' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z') {
+				i++
+			}
+			if i == 0 {
+				return nil, "", false
+			}
+			parts = append(parts, s[:i])
+			s = s[i:]
+		}
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return parts, s, true
+		}
+		s = s[1:]
+	}
+}
+
+// tomlValueScanner 跟踪值是否跨行：未闭合的多行字符串或括号
+type tomlValueScanner struct {
+	depth     int
+	multiline string // 未闭合的 """ 或 '''
+	comment   int    // 最近一行中注释的起始位置，-1 表示没有
+}
+
+func (sc *tomlValueScanner) complete() bool {
+	return sc.depth <= 0 && sc.multiline == ""
+}
+
+func (sc *tomlValueScanner) feed(s string) {
+	sc.comment = -1
+	for i := 0; i < len(s); {
+		if sc.multiline != "" {
+			switch {
+			case sc.multiline == `"""` && s[i] == '\\':
+				i += 2
+			case strings.HasPrefix(s[i:], sc.multiline):
+				i += 3
+				// 闭合符之前最多还可以有两个引号
+				for n := 0; n < 2 && i < len(s) && s[i] == sc.multiline[0]; n++ {
+					i++
+				}
+				sc.multiline = ""
+			default:
+				i++
+			}
+			continue
+		}
+		switch c := s[i]; {
+		case c == '#':
+			sc.comment = i
+			return
+		case strings.HasPrefix(s[i:], `"""`), strings.HasPrefix(s[i:], "'''"):
+			sc.multiline = s[i : i+3]
+			i += 3
+		case c == '"':
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+		case c == '\'':
+			i++
+			for i < len(s) && s[i] != '\'' {
+				i++
+			}
+			i++
+		case c == '[' || c == '{':
+			sc.depth++
+			i++
+		case c == ']' || c == '}':
+			sc.depth--
+			i++
+		default:
+			i++
+		}
+	}
+}
+
+// tomlLineComment 返回单行键值的行尾注释（含 #）
+func tomlLineComment(line string) string {
+	_, rest, ok := parseTOMLKey(strings.TrimLeft(line, " \t"))
+	if !ok || !strings.HasPrefix(rest, "=") {
+		return ""
+	}
+	var scanner tomlValueScanner
+	scanner.feed(rest)
+	if scanner.comment < 0 {
+		return ""
+	}
+	return strings.TrimSpace(rest[scanner.comment:])
+}
+
+// tomlValueText 将值编码为 TOML 字面量；嵌套的表写为内联表
+func tomlValueText(value any) (string, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.SetTablesInline(true)
+	if err := enc.Encode(map[string]any{"v": value}); err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(buf.String())
+	if !strings.HasPrefix(text, "v = ") {
+		return "", fmt.Errorf("无法编码为 TOML 值: %v", value)
+	}
+	return strings.TrimPrefix(text, "v = "), nil
+}
+
+func formatTOMLKey(key string) string {
+	if tomlBareKeyPattern.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+func formatTOMLPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, key := range path {
+		parts[i] = formatTOMLKey(key)
+	}
+	return strings.Join(parts, ".")
+}
+
+func tomlPathEqual(a, b []string) bool {
+	return len(a) == len(b) && tomlPathHasPrefix(a, b)
+}
+
+func tomlPathHasPrefix(path, prefix []string) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const tomlDocumentSample = `# 用户的注释
+model = "o3" # 常用模型
+approval_policy = "never"
+
+[model_providers.mine]
+name = "Mine"
+notes = """
+[not.a.table]
+"""
+
+[profiles]
+old.model = "x"
+
+[profiles.work]
+model = "gpt-5"
+
+[mcp_servers.fs]
+command = "npx"
+args = [
+  "a", # 注释
+  "b",
+]
+`
+
+func TestTOMLDocumentEdits(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(doc *tomlDocument) error
+		want string
+	}{
+		{
+			name: "replace existing key keeps comment",
+			edit: func(doc *tomlDocument) error { return doc.Set([]string{"model"}, "gpt-5") },
+			want: strings.Replace(tomlDocumentSample, `model = "o3" # 常用模型`, `model = 'gpt-5' # 常用模型`, 1),
+		},
+		{
+			name: "new root key goes after the last root key",
+			edit: func(doc *tomlDocument) error { return doc.Set([]string{"profile"}, "work") },
+			want: strings.Replace(tomlDocumentSample, "approval_policy = \"never\"\n", "approval_policy = \"never\"\nprofile = 'work'\n", 1),
+		},
+		{
+			name: "new key in existing table",
+			edit: func(doc *tomlDocument) error { return doc.Set([]string{"profiles", "work", "model_provider"}, "work") },
+			want: strings.Replace(tomlDocumentSample, "model = \"gpt-5\"\n", "model = \"gpt-5\"\nmodel_provider = 'work'\n", 1),
+		},
+		{
+			name: "multi-line value replaced as a whole",
+			edit: func(doc *tomlDocument) error { return doc.Set([]string{"mcp_servers", "fs", "args"}, []string{"c"}) },
+			want: strings.Replace(tomlDocumentSample, "args = [\n  \"a\", # 注释\n  \"b\",\n]\n", "args = ['c']\n", 1),
+		},
+		{
+			name: "replace table in place",
+			edit: func(doc *tomlDocument) error {
+				return doc.SetTable([]string{"profiles", "work"}, map[string]any{"model": "gpt-5.1", "model_provider": "work"})
+			},
+			want: strings.Replace(tomlDocumentSample, "[profiles.work]\nmodel = \"gpt-5\"\n", "[profiles.work]\nmodel = 'gpt-5.1'\nmodel_provider = 'work'\n", 1),
+		},
+		{
+			name: "new table appended",
+			edit: func(doc *tomlDocument) error {
+				return doc.SetTable([]string{"profiles", "new env"}, map[string]any{"model": "m"})
+			},
+			want: tomlDocumentSample + "\n[profiles.\"new env\"]\nmodel = 'm'\n",
+		},
+		{
+			name: "merge replaces only the given keys",
+			edit: func(doc *tomlDocument) error {
+				return doc.Merge(map[string]any{"mcp_servers": map[string]any{"fs": map[string]any{"command": "uvx"}}})
+			},
+			want: strings.Replace(tomlDocumentSample, `command = "npx"`, `command = 'uvx'`, 1),
+		},
+		{
+			name: "delete table with multi-line string",
+			edit: func(doc *tomlDocument) error {
+				doc.Delete([]string{"model_providers", "mine"})
+				return nil
+			},
+			want: strings.Replace(tomlDocumentSample, "[model_providers.mine]\nname = \"Mine\"\nnotes = \"\"\"\n[not.a.table]\n\"\"\"\n\n", "", 1),
+		},
+		{
+			name: "delete dotted key written under parent table",
+			edit: func(doc *tomlDocument) error {
+				doc.Delete([]string{"profiles", "old"})
+				return nil
+			},
+			want: strings.Replace(tomlDocumentSample, "old.model = \"x\"\n", "", 1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseTOMLDocument([]byte(tomlDocumentSample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.edit(doc); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(doc.Bytes()); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if _, err := doc.Map(); err != nil {
+				t.Errorf("result is not valid TOML: %v", err)
+			}
+		})
+	}
+}
+
+func TestTOMLDocumentEmpty(t *testing.T) {
+	doc, err := parseTOMLDocument(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Set([]string{"profile"}, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Set([]string{"sandbox_workspace_write", "writable_roots"}, []string{"/a"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "profile = 'x'\n\n[sandbox_workspace_write]\nwritable_roots = ['/a']\n"
+	if got := string(doc.Bytes()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,1813 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 上游接口协议（EnvConfig.UpstreamProtocol）
+const (
+	protocolAnthropic       = "anthropic"        // Anthropic Messages
+	protocolOpenAIChat      = "openai-chat"      // OpenAI Chat Completions
+	protocolOpenAIResponses = "openai-responses" // OpenAI Responses
+)
+
+const translateDefaultMaxTokens = 8192
+
+// normalizeUpstreamProtocol 校验环境声明的上游协议；空字符串表示与 Provider 一致（不转换）
+func normalizeUpstreamProtocol(env EnvConfig) (string, error) {
+	protocol := strings.ToLower(strings.TrimSpace(env.UpstreamProtocol))
+	switch protocol {
+	case "":
+		return "", nil
+	case protocolAnthropic, protocolOpenAIChat, protocolOpenAIResponses:
+	default:
+		return "", fmt.Errorf("不支持的上游协议: %s", env.UpstreamProtocol)
+	}
+	if strings.ToLower(strings.TrimSpace(env.Provider)) == "gemini" {
+		return "", fmt.Errorf("Gemini 环境不支持声明上游协议")
+	}
+	return protocol, nil
+}
+
+// isCountTokensPath Anthropic 的 token 计数接口；OpenAI 上游没有对应接口
+func isCountTokensPath(path string) bool {
+	return strings.HasSuffix(path, "/messages/count_tokens")
+}
+
+// estimateInputTokens 估算 Messages 请求的输入 token 数：ASCII 约 4 字符 1 个 token，其余字符各算 1 个
+func estimateInputTokens(body []byte) (int, error) {
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, fmt.Errorf("解析请求失败: %v", err)
+	}
+	ascii, other := 0, 0
+	var walk func(value any)
+	walk = func(value any) {
+		switch v := value.(type) {
+		case string:
+			for _, r := range v {
+				if r < 0x80 {
+					ascii++
+				} else {
+					other++
+				}
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]any:
+			for key, item := range v {
+				// 图片等二进制内容按固定值计
+				if key == "data" {
+					if _, ok := item.(string); ok {
+						other += 1600
+						continue
+					}
+				}
+				walk(item)
+			}
+		}
+	}
+	walk(req["system"])
+	walk(req["messages"])
+	walk(req["tools"])
+	return max(1, (ascii+3)/4+other), nil
+}
+
+// clientProtocolForPath 按请求路径判断 CLI 使用的协议；无法识别时返回空（原样转发）
+func clientProtocolForPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/messages"):
+		return protocolAnthropic
+	case strings.HasSuffix(path, "/chat/completions"):
+		return protocolOpenAIChat
+	case strings.HasSuffix(path, "/responses"):
+		return protocolOpenAIResponses
+	}
+	return ""
+}
+
+// gatewayTranslation 一次请求的协议转换；以 Anthropic Messages 为中间格式
+type gatewayTranslation struct {
+	client       string
+	upstream     string
+	stream       bool
+	includeUsage bool              // Chat Completions 客户端要求在流末尾返回 usage
+	toolKinds    map[string]string // Responses 客户端的非 function 工具：名称 -> custom / local_shell
+}
+
+// newGatewayTranslation 两端协议一致或无法识别时返回 nil（原样转发）
+func newGatewayTranslation(client, upstream string) (*gatewayTranslation, error) {
+	if client == "" || upstream == "" || client == upstream {
+		return nil, nil
+	}
+	for _, protocol := range []string{client, upstream} {
+		switch protocol {
+		case protocolAnthropic, protocolOpenAIChat, protocolOpenAIResponses:
+		default:
+			return nil, fmt.Errorf("不支持从 %s 转换到 %s", client, upstream)
+		}
+	}
+	return &gatewayTranslation{client: client, upstream: upstream}, nil
+}
+
+// request 转换请求体
+func (t *gatewayTranslation) request(body []byte) ([]byte, error) {
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("解析请求失败: %v", err)
+	}
+	t.stream, _ = req["stream"].(bool)
+	if options, ok := req["stream_options"].(map[string]any); ok {
+		t.includeUsage, _ = options["include_usage"].(bool)
+	}
+
+	msg := req
+	switch t.client {
+	case protocolOpenAIChat:
+		msg = chatRequestToAnthropic(req)
+	case protocolOpenAIResponses:
+		var err error
+		if msg, t.toolKinds, err = responsesRequestToAnthropic(req); err != nil {
+			return nil, err
+		}
+	}
+	out := msg
+	switch t.upstream {
+	case protocolOpenAIChat:
+		out = anthropicRequestToChat(msg)
+	case protocolOpenAIResponses:
+		out = anthropicRequestToResponses(msg)
+	}
+	return json.Marshal(out)
+}
+
+// upstreamPath 上游接口路径；Base URL 已以 /v1 结尾时不再重复
+func (t *gatewayTranslation) upstreamPath(base *url.URL) string {
+	prefix := "/v1"
+	if strings.HasSuffix(strings.TrimRight(base.Path, "/"), "/v1") {
+		prefix = ""
+	}
+	switch t.upstream {
+	case protocolAnthropic:
+		return prefix + "/messages"
+	case protocolOpenAIResponses:
+		return prefix + "/responses"
+	}
+	return prefix + "/chat/completions"
+}
+
+// translateResponse 转换上游响应：流式响应逐事件转换，其余整体转换
+func (t *gatewayTranslation) translateResponse(resp *http.Response) error {
+	isStream := strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream")
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 && isStream {
+		resp.Body = t.streamBody(resp.Body)
+		resp.Header.Set("Content-Type", "text/event-stream")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var out any
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var payload map[string]any
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return fmt.Errorf("解析上游响应失败: %v", err)
+		}
+		msg := payload
+		switch t.upstream {
+		case protocolOpenAIChat:
+			msg = chatResponseToAnthropic(payload)
+		case protocolOpenAIResponses:
+			msg = responsesResponseToAnthropic(payload)
+		}
+		switch t.client {
+		case protocolOpenAIChat:
+			out = anthropicResponseToChat(msg)
+		case protocolOpenAIResponses:
+			out = anthropicResponseToResponses(msg, t.toolKinds)
+		default:
+			out = msg
+		}
+	} else {
+		out = translatedError(t.client, resp.StatusCode, probeErrorMessage(data))
+	}
+
+	converted, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(converted))
+	resp.ContentLength = int64(len(converted))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(converted)))
+	resp.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// streamBody 在后台读取上游事件流并写出客户端协议的事件流
+func (t *gatewayTranslation) streamBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		var sink anthropicEventSink
+		switch t.client {
+		case protocolOpenAIChat:
+			sink = &chatStreamSink{w: pw, includeUsage: t.includeUsage, created: time.Now().Unix()}
+		case protocolOpenAIResponses:
+			sink = &responsesStreamSink{w: pw, created: time.Now().Unix(), toolKinds: t.toolKinds}
+		default:
+			sink = &anthropicStreamSink{w: pw}
+		}
+
+		var err error
+		switch t.upstream {
+		case protocolOpenAIChat:
+			err = chatStreamToAnthropic(body, sink.emit)
+		case protocolOpenAIResponses:
+			err = responsesStreamToAnthropic(body, sink.emit)
+		default:
+			err = readSSE(body, func(_ string, data []byte) error {
+				var event map[string]any
+				if json.Unmarshal(data, &event) != nil {
+					return nil
+				}
+				return sink.emit(event)
+			})
+		}
+		if err == nil {
+			err = sink.finish()
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return &pipeBody{PipeReader: pr, upstream: body}
+}
+
+// pipeBody 客户端断开时同时关闭上游连接
+type pipeBody struct {
+	*io.PipeReader
+	upstream io.Closer
+}
+
+func (b *pipeBody) Close() error {
+	b.upstream.Close()
+	return b.PipeReader.Close()
+}
+
+// translatedError 以客户端协议的格式返回上游错误
+func translatedError(client string, status int, message string) map[string]any {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	if client == protocolAnthropic {
+		errType := "api_error"
+		switch {
+		case status == http.StatusUnauthorized:
+			errType = "authentication_error"
+		case status == http.StatusForbidden:
+			errType = "permission_error"
+		case status == http.StatusNotFound:
+			errType = "not_found_error"
+		case status == http.StatusTooManyRequests:
+			errType = "rate_limit_error"
+		case status >= 400 && status < 500:
+			errType = "invalid_request_error"
+		}
+		return map[string]any{"type": "error", "error": map[string]any{"type": errType, "message": message}}
+	}
+	return map[string]any{"error": map[string]any{"type": "upstream_error", "code": strconv.Itoa(status), "message": message}}
+}
+
+// ---- 请求转换 ----
+
+// anthropicRequestToChat Anthropic Messages -> Chat Completions
+func anthropicRequestToChat(req map[string]any) map[string]any {
+	out := map[string]any{"model": req["model"]}
+	copyFields(out, req, "temperature", "top_p", "stream")
+	if maxTokens, ok := req["max_tokens"]; ok {
+		out["max_tokens"] = maxTokens
+	}
+	if stop, ok := req["stop_sequences"].([]any); ok && len(stop) > 0 {
+		out["stop"] = stop
+	}
+	if stream, _ := req["stream"].(bool); stream {
+		out["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	var messages []any
+	if system := anthropicText(req["system"]); system != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": system})
+	}
+	for _, raw := range asSlice(req["messages"]) {
+		msg, _ := raw.(map[string]any)
+		role, _ := msg["role"].(string)
+		blocks := anthropicBlocks(msg["content"])
+		if role == "assistant" {
+			var text strings.Builder
+			var toolCalls []any
+			for _, block := range blocks {
+				switch block["type"] {
+				case "text":
+					text.WriteString(stringField(block, "text"))
+				case "tool_use":
+					args, _ := json.Marshal(block["input"])
+					toolCalls = append(toolCalls, map[string]any{
+						"id":       block["id"],
+						"type":     "function",
+						"function": map[string]any{"name": block["name"], "arguments": string(args)},
+					})
+				}
+			}
+			item := map[string]any{"role": "assistant", "content": text.String()}
+			if len(toolCalls) > 0 {
+				item["tool_calls"] = toolCalls
+				if text.Len() == 0 {
+					item["content"] = nil
+				}
+			}
+			messages = append(messages, item)
+			continue
+		}
+
+		// user：tool_result 拆为 tool 消息，其余内容保留为一条 user 消息
+		var parts []any
+		for _, block := range blocks {
+			switch block["type"] {
+			case "tool_result":
+				messages = append(messages, map[string]any{
+					"role":         "tool",
+					"tool_call_id": block["tool_use_id"],
+					"content":      anthropicText(block["content"]),
+				})
+			case "text":
+				parts = append(parts, map[string]any{"type": "text", "text": block["text"]})
+			case "image":
+				if imageURL := anthropicImageURL(block); imageURL != "" {
+					parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]any{"url": imageURL}})
+				}
+			}
+		}
+		if len(parts) > 0 {
+			messages = append(messages, map[string]any{"role": "user", "content": parts})
+		}
+	}
+	out["messages"] = messages
+
+	var tools []any
+	for _, raw := range asSlice(req["tools"]) {
+		tool, _ := raw.(map[string]any)
+		if tool == nil || tool["input_schema"] == nil {
+			continue // 服务端工具（web_search 等）无法转换
+		}
+		function := map[string]any{"name": tool["name"], "parameters": tool["input_schema"]}
+		copyFields(function, tool, "description")
+		tools = append(tools, map[string]any{"type": "function", "function": function})
+	}
+	if len(tools) > 0 {
+		out["tools"] = tools
+		if choice, ok := req["tool_choice"].(map[string]any); ok {
+			switch choice["type"] {
+			case "any":
+				out["tool_choice"] = "required"
+			case "none":
+				out["tool_choice"] = "none"
+			case "tool":
+				out["tool_choice"] = map[string]any{"type": "function", "function": map[string]any{"name": choice["name"]}}
+			default:
+				out["tool_choice"] = "auto"
+			}
+		}
+	}
+	return out
+}
+
+// chatRequestToAnthropic Chat Completions -> Anthropic Messages
+func chatRequestToAnthropic(req map[string]any) map[string]any {
+	out := map[string]any{"model": req["model"], "max_tokens": translateDefaultMaxTokens}
+	copyFields(out, req, "temperature", "top_p", "stream")
+	for _, key := range []string{"max_completion_tokens", "max_tokens"} {
+		if value, ok := req[key].(float64); ok && value > 0 {
+			out["max_tokens"] = int(value)
+			break
+		}
+	}
+	switch stop := req["stop"].(type) {
+	case string:
+		out["stop_sequences"] = []any{stop}
+	case []any:
+		out["stop_sequences"] = stop
+	}
+
+	var system []string
+	builder := &anthropicMessageBuilder{}
+	for _, raw := range asSlice(req["messages"]) {
+		msg, _ := raw.(map[string]any)
+		role, _ := msg["role"].(string)
+		switch role {
+		case "system", "developer":
+			if text := openAIText(msg["content"]); text != "" {
+				system = append(system, text)
+			}
+		case "tool":
+			builder.add("user", map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": msg["tool_call_id"],
+				"content":     openAIText(msg["content"]),
+			})
+		case "assistant":
+			if text := openAIText(msg["content"]); text != "" {
+				builder.add("assistant", map[string]any{"type": "text", "text": text})
+			}
+			for _, rawCall := range asSlice(msg["tool_calls"]) {
+				call, _ := rawCall.(map[string]any)
+				function, _ := call["function"].(map[string]any)
+				builder.add("assistant", map[string]any{
+					"type":  "tool_use",
+					"id":    call["id"],
+					"name":  function["name"],
+					"input": parseToolArguments(function["arguments"]),
+				})
+			}
+		default:
+			for _, block := range openAIContentBlocks(msg["content"]) {
+				builder.add("user", block)
+			}
+		}
+	}
+	if len(system) > 0 {
+		out["system"] = strings.Join(system, "\n\n")
+	}
+	out["messages"] = builder.messages
+
+	var tools []any
+	for _, raw := range asSlice(req["tools"]) {
+		tool, _ := raw.(map[string]any)
+		function, _ := tool["function"].(map[string]any)
+		if function == nil {
+			continue
+		}
+		tools = append(tools, anthropicTool(function["name"], function["description"], function["parameters"]))
+	}
+	if len(tools) > 0 {
+		out["tools"] = tools
+		if choice := anthropicToolChoice(req["tool_choice"]); choice != nil {
+			out["tool_choice"] = choice
+		}
+	}
+	return out
+}
+
+// responsesRequestToAnthropic Responses -> Anthropic Messages。function / custom / local_shell 工具转换为
+// Anthropic 工具，并返回非 function 工具的类型以便还原调用项；其他内置工具（web_search 等）无法转换，返回错误
+func responsesRequestToAnthropic(req map[string]any) (map[string]any, map[string]string, error) {
+	out := map[string]any{"model": req["model"], "max_tokens": translateDefaultMaxTokens}
+	copyFields(out, req, "temperature", "top_p", "stream")
+	if value, ok := req["max_output_tokens"].(float64); ok && value > 0 {
+		out["max_tokens"] = int(value)
+	}
+
+	var system []string
+	if instructions, _ := req["instructions"].(string); strings.TrimSpace(instructions) != "" {
+		system = append(system, instructions)
+	}
+	builder := &anthropicMessageBuilder{}
+	toolUse := func(callID, name, input any) {
+		builder.add("assistant", map[string]any{"type": "tool_use", "id": callID, "name": name, "input": input})
+	}
+	toolResult := func(callID, output any) {
+		builder.add("user", map[string]any{"type": "tool_result", "tool_use_id": callID, "content": openAIText(output)})
+	}
+	switch input := req["input"].(type) {
+	case string:
+		builder.add("user", map[string]any{"type": "text", "text": input})
+	case []any:
+		for _, raw := range input {
+			item, _ := raw.(map[string]any)
+			itemType, _ := item["type"].(string)
+			role, _ := item["role"].(string)
+			switch {
+			case itemType == "function_call":
+				toolUse(item["call_id"], item["name"], parseToolArguments(item["arguments"]))
+			case itemType == "custom_tool_call":
+				toolUse(item["call_id"], item["name"], map[string]any{"input": stringField(item, "input")})
+			case itemType == "local_shell_call":
+				action, _ := item["action"].(map[string]any)
+				toolUse(firstNonNil(item["call_id"], item["id"]), "local_shell", localShellInput(action))
+			case itemType == "function_call_output", itemType == "custom_tool_call_output":
+				toolResult(item["call_id"], item["output"])
+			case itemType == "local_shell_call_output":
+				toolResult(firstNonNil(item["call_id"], item["id"]), item["output"])
+			case itemType == "message" || (itemType == "" && role != ""):
+				switch role {
+				case "system", "developer":
+					if text := openAIText(item["content"]); text != "" {
+						system = append(system, text)
+					}
+				case "assistant":
+					if text := openAIText(item["content"]); text != "" {
+						builder.add("assistant", map[string]any{"type": "text", "text": text})
+					}
+				default:
+					for _, block := range openAIContentBlocks(item["content"]) {
+						builder.add("user", block)
+					}
+				}
+			}
+		}
+	}
+	if len(system) > 0 {
+		out["system"] = strings.Join(system, "\n\n")
+	}
+	out["messages"] = builder.messages
+
+	var tools []any
+	kinds := map[string]string{}
+	for _, raw := range asSlice(req["tools"]) {
+		tool, _ := raw.(map[string]any)
+		switch toolType := stringField(tool, "type"); toolType {
+		case "function":
+			tools = append(tools, anthropicTool(tool["name"], tool["description"], tool["parameters"]))
+		case "custom":
+			// 自由格式工具（如 apply_patch）：输入为一段文本，按语法说明放入 input 字段
+			name := stringField(tool, "name")
+			kinds[name] = toolType
+			tools = append(tools, anthropicTool(name, tool["description"], customToolSchema(tool["format"])))
+		case "local_shell":
+			kinds["local_shell"] = toolType
+			tools = append(tools, anthropicTool("local_shell", "Runs a shell command and returns its output.", localShellSchema))
+		default:
+			return nil, nil, fmt.Errorf("Responses 工具类型 %s 无法转换到上游协议，请在客户端关闭该工具", toolType)
+		}
+	}
+	if len(tools) > 0 {
+		out["tools"] = tools
+		if choice := anthropicToolChoice(req["tool_choice"]); choice != nil {
+			out["tool_choice"] = choice
+		}
+	}
+	return out, kinds, nil
+}
+
+// anthropicRequestToResponses Anthropic Messages -> Responses（不保存会话，服务端工具无法转换）
+func anthropicRequestToResponses(req map[string]any) map[string]any {
+	out := map[string]any{"model": req["model"], "store": false}
+	copyFields(out, req, "temperature", "top_p", "stream")
+	if maxTokens, ok := req["max_tokens"]; ok {
+		out["max_output_tokens"] = maxTokens
+	}
+	if system := anthropicText(req["system"]); system != "" {
+		out["instructions"] = system
+	}
+
+	input := []any{}
+	for _, raw := range asSlice(req["messages"]) {
+		msg, _ := raw.(map[string]any)
+		role, _ := msg["role"].(string)
+		textType := "input_text"
+		if role == "assistant" {
+			textType = "output_text"
+		} else {
+			role = "user"
+		}
+		// 文本与图片合并为一条消息，遇到工具调用 / 结果时先写出，保持原有顺序
+		var parts []any
+		flush := func() {
+			if len(parts) > 0 {
+				input = append(input, map[string]any{"type": "message", "role": role, "content": parts})
+				parts = nil
+			}
+		}
+		for _, block := range anthropicBlocks(msg["content"]) {
+			switch block["type"] {
+			case "text":
+				parts = append(parts, map[string]any{"type": textType, "text": stringField(block, "text")})
+			case "image":
+				if imageURL := anthropicImageURL(block); imageURL != "" && role == "user" {
+					parts = append(parts, map[string]any{"type": "input_image", "image_url": imageURL})
+				}
+			case "tool_use":
+				flush()
+				args, _ := json.Marshal(block["input"])
+				input = append(input, map[string]any{"type": "function_call", "call_id": block["id"], "name": block["name"], "arguments": string(args)})
+			case "tool_result":
+				flush()
+				input = append(input, map[string]any{"type": "function_call_output", "call_id": block["tool_use_id"], "output": anthropicText(block["content"])})
+			}
+		}
+		flush()
+	}
+	out["input"] = input
+
+	var tools []any
+	for _, raw := range asSlice(req["tools"]) {
+		tool, _ := raw.(map[string]any)
+		if tool == nil || tool["input_schema"] == nil {
+			continue // 服务端工具（web_search 等）无法转换
+		}
+		function := map[string]any{"type": "function", "name": tool["name"], "parameters": tool["input_schema"]}
+		copyFields(function, tool, "description")
+		tools = append(tools, function)
+	}
+	if len(tools) > 0 {
+		out["tools"] = tools
+		if choice, ok := req["tool_choice"].(map[string]any); ok {
+			switch choice["type"] {
+			case "any":
+				out["tool_choice"] = "required"
+			case "none":
+				out["tool_choice"] = "none"
+			case "tool":
+				out["tool_choice"] = map[string]any{"type": "function", "name": choice["name"]}
+			default:
+				out["tool_choice"] = "auto"
+			}
+		}
+	}
+	return out
+}
+
+// localShellSchema Responses local_shell 工具对应的参数
+var localShellSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"command":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"workdir":    map[string]any{"type": "string"},
+		"timeout_ms": map[string]any{"type": "integer"},
+	},
+	"required": []any{"command"},
+}
+
+// customToolSchema 自由格式工具的参数：一个文本字段 input；有语法约束时写入字段说明
+func customToolSchema(format any) map[string]any {
+	description := "Raw tool input."
+	if format, ok := format.(map[string]any); ok && stringField(format, "type") == "grammar" {
+		description = fmt.Sprintf("Raw tool input. It must match this %s grammar:\n%s", stringField(format, "syntax"), stringField(format, "definition"))
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"input": map[string]any{"type": "string", "description": description}},
+		"required":   []any{"input"},
+	}
+}
+
+// localShellInput local_shell_call 的 action -> 工具参数
+func localShellInput(action map[string]any) map[string]any {
+	input := map[string]any{"command": firstNonNil(action["command"], []any{})}
+	if workdir := stringField(action, "working_directory"); workdir != "" {
+		input["workdir"] = workdir
+	}
+	if timeout, ok := action["timeout_ms"]; ok && timeout != nil {
+		input["timeout_ms"] = timeout
+	}
+	return input
+}
+
+// anthropicMessageBuilder 合并相邻的同角色消息（Anthropic 要求 user / assistant 交替）
+type anthropicMessageBuilder struct {
+	messages []any
+}
+
+func (b *anthropicMessageBuilder) add(role string, block map[string]any) {
+	if n := len(b.messages); n > 0 {
+		last := b.messages[n-1].(map[string]any)
+		if last["role"] == role {
+			last["content"] = append(last["content"].([]any), block)
+			return
+		}
+	}
+	b.messages = append(b.messages, map[string]any{"role": role, "content": []any{block}})
+}
+
+func anthropicTool(name, description, parameters any) map[string]any {
+	if parameters == nil {
+		parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	tool := map[string]any{"name": name, "input_schema": parameters}
+	if description != nil {
+		tool["description"] = description
+	}
+	return tool
+}
+
+func anthropicToolChoice(choice any) map[string]any {
+	switch value := choice.(type) {
+	case string:
+		switch value {
+		case "required":
+			return map[string]any{"type": "any"}
+		case "none":
+			return map[string]any{"type": "none"}
+		case "auto":
+			return map[string]any{"type": "auto"}
+		}
+	case map[string]any:
+		name := value["name"]
+		if function, ok := value["function"].(map[string]any); ok {
+			name = function["name"]
+		}
+		if name != nil {
+			return map[string]any{"type": "tool", "name": name}
+		}
+	}
+	return nil
+}
+
+// ---- 非流式响应转换 ----
+
+// chatResponseToAnthropic Chat Completions 响应 -> Anthropic Message
+func chatResponseToAnthropic(resp map[string]any) map[string]any {
+	var content []any
+	stopReason := "end_turn"
+	if choices := asSlice(resp["choices"]); len(choices) > 0 {
+		choice, _ := choices[0].(map[string]any)
+		message, _ := choice["message"].(map[string]any)
+		if text := openAIText(message["content"]); text != "" {
+			content = append(content, map[string]any{"type": "text", "text": text})
+		}
+		for _, raw := range asSlice(message["tool_calls"]) {
+			call, _ := raw.(map[string]any)
+			function, _ := call["function"].(map[string]any)
+			content = append(content, map[string]any{
+				"type":  "tool_use",
+				"id":    call["id"],
+				"name":  function["name"],
+				"input": parseToolArguments(function["arguments"]),
+			})
+		}
+		stopReason = anthropicStopReason(stringField(choice, "finish_reason"))
+	}
+	if content == nil {
+		content = []any{}
+	}
+	usage, _ := resp["usage"].(map[string]any)
+	return map[string]any{
+		"id":            "msg_" + strings.TrimPrefix(stringField(resp, "id"), "chatcmpl-"),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         resp["model"],
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         anthropicUsageFromOpenAI(usage),
+	}
+}
+
+// anthropicResponseToChat Anthropic Message -> Chat Completions 响应
+func anthropicResponseToChat(msg map[string]any) map[string]any {
+	var text strings.Builder
+	var toolCalls []any
+	for _, block := range anthropicBlocks(msg["content"]) {
+		switch block["type"] {
+		case "text":
+			text.WriteString(stringField(block, "text"))
+		case "tool_use":
+			args, _ := json.Marshal(block["input"])
+			toolCalls = append(toolCalls, map[string]any{
+				"id":       block["id"],
+				"type":     "function",
+				"function": map[string]any{"name": block["name"], "arguments": string(args)},
+			})
+		}
+	}
+	message := map[string]any{"role": "assistant", "content": text.String()}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+	usage, _ := msg["usage"].(map[string]any)
+	return map[string]any{
+		"id":      "chatcmpl-" + strings.TrimPrefix(stringField(msg, "id"), "msg_"),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   msg["model"],
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       message,
+			"finish_reason": chatFinishReason(stringField(msg, "stop_reason")),
+		}},
+		"usage": chatUsageFromAnthropic(usage),
+	}
+}
+
+// anthropicResponseToResponses Anthropic Message -> Responses 响应；kinds 为客户端声明的非 function 工具
+func anthropicResponseToResponses(msg map[string]any, kinds map[string]string) map[string]any {
+	var output []any
+	for _, block := range anthropicBlocks(msg["content"]) {
+		switch block["type"] {
+		case "text":
+			output = append(output, responsesMessageItem(translateID("msg_"), stringField(block, "text"), "completed"))
+		case "tool_use":
+			args, _ := json.Marshal(block["input"])
+			output = append(output, responsesToolCallItem(block, string(args), "completed", kinds))
+		}
+	}
+	usage, _ := msg["usage"].(map[string]any)
+	return responsesObject("resp_"+strings.TrimPrefix(stringField(msg, "id"), "msg_"), msg["model"], time.Now().Unix(),
+		responsesStatus(stringField(msg, "stop_reason")), output, responsesUsageFromAnthropic(usage))
+}
+
+// responsesResponseToAnthropic Responses 响应 -> Anthropic Message（reasoning 等输出项不转换）
+func responsesResponseToAnthropic(resp map[string]any) map[string]any {
+	content := []any{}
+	stopReason := "end_turn"
+	for _, raw := range asSlice(resp["output"]) {
+		item, _ := raw.(map[string]any)
+		switch item["type"] {
+		case "message":
+			for _, rawPart := range asSlice(item["content"]) {
+				part, _ := rawPart.(map[string]any)
+				switch part["type"] {
+				case "output_text":
+					content = append(content, map[string]any{"type": "text", "text": stringField(part, "text")})
+				case "refusal":
+					content = append(content, map[string]any{"type": "text", "text": stringField(part, "refusal")})
+				}
+			}
+		case "function_call":
+			content = append(content, map[string]any{
+				"type":  "tool_use",
+				"id":    item["call_id"],
+				"name":  item["name"],
+				"input": parseToolArguments(item["arguments"]),
+			})
+			stopReason = "tool_use"
+		}
+	}
+	if stringField(resp, "status") == "incomplete" {
+		stopReason = "max_tokens"
+	}
+	usage, _ := resp["usage"].(map[string]any)
+	return map[string]any{
+		"id":            "msg_" + strings.TrimPrefix(stringField(resp, "id"), "resp_"),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         resp["model"],
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         anthropicUsageFromOpenAI(usage),
+	}
+}
+
+// ---- 流式响应转换 ----
+
+// anthropicEventSink 接收 Anthropic 流式事件并写出客户端协议的事件
+type anthropicEventSink interface {
+	emit(event map[string]any) error
+	finish() error
+}
+
+// anthropicStreamSink 原样写出 Anthropic 事件
+type anthropicStreamSink struct {
+	w io.Writer
+}
+
+func (s *anthropicStreamSink) emit(event map[string]any) error {
+	return writeSSE(s.w, stringField(event, "type"), event)
+}
+
+func (s *anthropicStreamSink) finish() error { return nil }
+
+// chatStreamToAnthropic 读取 Chat Completions 流并产生 Anthropic 事件。文本逐段输出；
+// tool_calls 的各个序号可能交错到达，先按序号缓存，流结束时按顺序输出完整的 tool_use 块，
+// 保证每个内容块连续且只开始、结束一次
+func chatStreamToAnthropic(r io.Reader, emit func(map[string]any) error) error {
+	started := false
+	blockIndex, textOpen := -1, false
+	type pendingToolCall struct {
+		id   string
+		name any
+		args strings.Builder
+	}
+	toolCalls := map[int]*pendingToolCall{}
+	var toolOrder []int
+	stopReason := "end_turn"
+	var usage map[string]any
+
+	start := func(chunk map[string]any) error {
+		if started {
+			return nil
+		}
+		started = true
+		return emit(map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            "msg_" + strings.TrimPrefix(stringField(chunk, "id"), "chatcmpl-"),
+				"type":          "message",
+				"role":          "assistant",
+				"model":         chunk["model"],
+				"content":       []any{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]any{"input_tokens": 0, "output_tokens": 0},
+			},
+		})
+	}
+	closeText := func() error {
+		if !textOpen {
+			return nil
+		}
+		textOpen = false
+		return emit(map[string]any{"type": "content_block_stop", "index": blockIndex})
+	}
+
+	err := readSSE(r, func(_ string, data []byte) error {
+		if string(bytes.TrimSpace(data)) == "[DONE]" {
+			return io.EOF
+		}
+		var chunk map[string]any
+		if json.Unmarshal(data, &chunk) != nil {
+			return nil
+		}
+		if errObj, ok := chunk["error"].(map[string]any); ok {
+			return emit(map[string]any{"type": "error", "error": map[string]any{"type": "api_error", "message": stringField(errObj, "message")}})
+		}
+		if err := start(chunk); err != nil {
+			return err
+		}
+		if u, ok := chunk["usage"].(map[string]any); ok {
+			usage = u
+		}
+		choices := asSlice(chunk["choices"])
+		if len(choices) == 0 {
+			return nil
+		}
+		choice, _ := choices[0].(map[string]any)
+		delta, _ := choice["delta"].(map[string]any)
+
+		if text, _ := delta["content"].(string); text != "" {
+			if !textOpen {
+				blockIndex++
+				textOpen = true
+				if err := emit(map[string]any{"type": "content_block_start", "index": blockIndex, "content_block": map[string]any{"type": "text", "text": ""}}); err != nil {
+					return err
+				}
+			}
+			if err := emit(map[string]any{"type": "content_block_delta", "index": blockIndex, "delta": map[string]any{"type": "text_delta", "text": text}}); err != nil {
+				return err
+			}
+		}
+		for _, raw := range asSlice(delta["tool_calls"]) {
+			call, _ := raw.(map[string]any)
+			index := intField(call, "index")
+			function, _ := call["function"].(map[string]any)
+			pending := toolCalls[index]
+			if pending == nil {
+				pending = &pendingToolCall{}
+				toolCalls[index] = pending
+				toolOrder = append(toolOrder, index)
+			}
+			if id := stringField(call, "id"); id != "" && pending.id == "" {
+				pending.id = id
+			}
+			if name, ok := function["name"]; ok && name != nil && pending.name == nil {
+				pending.name = name
+			}
+			pending.args.WriteString(stringField(function, "arguments"))
+		}
+		if reason := stringField(choice, "finish_reason"); reason != "" {
+			stopReason = anthropicStopReason(reason)
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if err := start(map[string]any{"id": translateID("")}); err != nil {
+		return err
+	}
+	if err := closeText(); err != nil {
+		return err
+	}
+	for _, index := range toolOrder {
+		pending := toolCalls[index]
+		if pending.id == "" {
+			pending.id = translateID("toolu_")
+		}
+		blockIndex++
+		if err := emit(map[string]any{
+			"type":          "content_block_start",
+			"index":         blockIndex,
+			"content_block": map[string]any{"type": "tool_use", "id": pending.id, "name": pending.name, "input": map[string]any{}},
+		}); err != nil {
+			return err
+		}
+		if args := pending.args.String(); args != "" {
+			if err := emit(map[string]any{"type": "content_block_delta", "index": blockIndex, "delta": map[string]any{"type": "input_json_delta", "partial_json": args}}); err != nil {
+				return err
+			}
+		}
+		if err := emit(map[string]any{"type": "content_block_stop", "index": blockIndex}); err != nil {
+			return err
+		}
+	}
+	if err := emit(map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": anthropicUsageFromOpenAI(usage),
+	}); err != nil {
+		return err
+	}
+	return emit(map[string]any{"type": "message_stop"})
+}
+
+// responsesStreamToAnthropic 读取 Responses 流并产生 Anthropic 事件。输出项按顺序到达，
+// 每个 message / function_call 项对应一个内容块；reasoning 等其他输出项不转换
+func responsesStreamToAnthropic(r io.Reader, emit func(map[string]any) error) error {
+	started, finished := false, false
+	blockIndex, openItem := -1, -1 // openItem 当前内容块对应的 output_index
+	blockKind := ""
+	argsSeen := false
+	hasToolUse := false
+
+	start := func(resp map[string]any) error {
+		if started {
+			return nil
+		}
+		started = true
+		return emit(map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            "msg_" + strings.TrimPrefix(stringField(resp, "id"), "resp_"),
+				"type":          "message",
+				"role":          "assistant",
+				"model":         resp["model"],
+				"content":       []any{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]any{"input_tokens": 0, "output_tokens": 0},
+			},
+		})
+	}
+	closeBlock := func() error {
+		if openItem < 0 {
+			return nil
+		}
+		openItem = -1
+		return emit(map[string]any{"type": "content_block_stop", "index": blockIndex})
+	}
+	openBlock := func(outputIndex int, kind string, block map[string]any) error {
+		if err := closeBlock(); err != nil {
+			return err
+		}
+		blockIndex++
+		openItem, blockKind, argsSeen = outputIndex, kind, false
+		return emit(map[string]any{"type": "content_block_start", "index": blockIndex, "content_block": block})
+	}
+	finish := func(resp map[string]any) error {
+		if err := start(resp); err != nil {
+			return err
+		}
+		if err := closeBlock(); err != nil {
+			return err
+		}
+		finished = true
+		stopReason := "end_turn"
+		switch {
+		case stringField(resp, "status") == "incomplete":
+			stopReason = "max_tokens"
+		case hasToolUse:
+			stopReason = "tool_use"
+		}
+		usage, _ := resp["usage"].(map[string]any)
+		if err := emit(map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": anthropicUsageFromOpenAI(usage),
+		}); err != nil {
+			return err
+		}
+		return emit(map[string]any{"type": "message_stop"})
+	}
+
+	err := readSSE(r, func(_ string, data []byte) error {
+		var event map[string]any
+		if json.Unmarshal(data, &event) != nil {
+			return nil
+		}
+		resp, _ := event["response"].(map[string]any)
+		outputIndex := intField(event, "output_index")
+		switch event["type"] {
+		case "response.created", "response.in_progress":
+			return start(resp)
+
+		case "response.output_item.added":
+			if err := start(map[string]any{"id": translateID("")}); err != nil {
+				return err
+			}
+			item, _ := event["item"].(map[string]any)
+			switch item["type"] {
+			case "message":
+				return openBlock(outputIndex, "text", map[string]any{"type": "text", "text": ""})
+			case "function_call":
+				hasToolUse = true
+				return openBlock(outputIndex, "tool", map[string]any{"type": "tool_use", "id": item["call_id"], "name": item["name"], "input": map[string]any{}})
+			}
+
+		case "response.output_text.delta", "response.refusal.delta":
+			if openItem != outputIndex || blockKind != "text" {
+				return nil
+			}
+			return emit(map[string]any{"type": "content_block_delta", "index": blockIndex, "delta": map[string]any{"type": "text_delta", "text": stringField(event, "delta")}})
+
+		case "response.function_call_arguments.delta":
+			if openItem != outputIndex || blockKind != "tool" {
+				return nil
+			}
+			argsSeen = true
+			return emit(map[string]any{"type": "content_block_delta", "index": blockIndex, "delta": map[string]any{"type": "input_json_delta", "partial_json": stringField(event, "delta")}})
+
+		case "response.output_item.done":
+			if openItem != outputIndex {
+				return nil
+			}
+			// 部分上游只在完成时给出完整参数
+			item, _ := event["item"].(map[string]any)
+			if args := stringField(item, "arguments"); blockKind == "tool" && !argsSeen && args != "" {
+				if err := emit(map[string]any{"type": "content_block_delta", "index": blockIndex, "delta": map[string]any{"type": "input_json_delta", "partial_json": args}}); err != nil {
+					return err
+				}
+			}
+			return closeBlock()
+
+		case "response.completed", "response.incomplete":
+			if err := finish(resp); err != nil {
+				return err
+			}
+			return io.EOF
+
+		case "response.failed", "error":
+			errObj, _ := event["error"].(map[string]any)
+			if failed, ok := resp["error"].(map[string]any); ok {
+				errObj = failed
+			}
+			finished = true
+			return emit(map[string]any{"type": "error", "error": map[string]any{"type": "api_error", "message": firstNonEmpty(stringField(errObj, "message"), stringField(event, "message"), "上游返回错误")}})
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !finished {
+		// 上游没有发送完成事件就结束了流
+		return finish(map[string]any{"id": translateID("")})
+	}
+	return nil
+}
+
+// chatStreamSink Anthropic 事件 -> Chat Completions 流
+type chatStreamSink struct {
+	w            io.Writer
+	includeUsage bool
+	created      int64
+	id           string
+	model        any
+	toolIndex    map[int]int // Anthropic 内容块序号 -> tool_calls 序号
+	usage        map[string]any
+	finishReason string
+	done         bool
+}
+
+func (s *chatStreamSink) chunk(delta map[string]any, finishReason any) error {
+	return writeSSEData(s.w, map[string]any{
+		"id":      s.id,
+		"object":  "chat.completion.chunk",
+		"created": s.created,
+		"model":   s.model,
+		"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finishReason}},
+	})
+}
+
+func (s *chatStreamSink) emit(event map[string]any) error {
+	switch event["type"] {
+	case "message_start":
+		message, _ := event["message"].(map[string]any)
+		s.id = "chatcmpl-" + strings.TrimPrefix(stringField(message, "id"), "msg_")
+		s.model = message["model"]
+		s.toolIndex = map[int]int{}
+		s.usage, _ = message["usage"].(map[string]any)
+		return s.chunk(map[string]any{"role": "assistant", "content": ""}, nil)
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]any)
+		if block["type"] != "tool_use" {
+			return nil
+		}
+		index := len(s.toolIndex)
+		s.toolIndex[intField(event, "index")] = index
+		return s.chunk(map[string]any{"tool_calls": []any{map[string]any{
+			"index":    index,
+			"id":       block["id"],
+			"type":     "function",
+			"function": map[string]any{"name": block["name"], "arguments": ""},
+		}}}, nil)
+	case "content_block_delta":
+		delta, _ := event["delta"].(map[string]any)
+		switch delta["type"] {
+		case "text_delta":
+			return s.chunk(map[string]any{"content": delta["text"]}, nil)
+		case "input_json_delta":
+			index, ok := s.toolIndex[intField(event, "index")]
+			if !ok {
+				return nil
+			}
+			return s.chunk(map[string]any{"tool_calls": []any{map[string]any{
+				"index":    index,
+				"function": map[string]any{"arguments": delta["partial_json"]},
+			}}}, nil)
+		}
+	case "message_delta":
+		delta, _ := event["delta"].(map[string]any)
+		s.finishReason = chatFinishReason(stringField(delta, "stop_reason"))
+		s.usage = mergeUsage(s.usage, event["usage"])
+		return s.chunk(map[string]any{}, s.finishReason)
+	case "message_stop":
+		return s.finish()
+	case "error":
+		errObj, _ := event["error"].(map[string]any)
+		return writeSSEData(s.w, map[string]any{"error": map[string]any{"type": "upstream_error", "message": stringField(errObj, "message")}})
+	}
+	return nil
+}
+
+func (s *chatStreamSink) finish() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	if s.includeUsage && s.id != "" {
+		if err := writeSSEData(s.w, map[string]any{
+			"id":      s.id,
+			"object":  "chat.completion.chunk",
+			"created": s.created,
+			"model":   s.model,
+			"choices": []any{},
+			"usage":   chatUsageFromAnthropic(s.usage),
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(s.w, "data: [DONE]\n\n")
+	return err
+}
+
+// responsesStreamSink Anthropic 事件 -> Responses 流
+type responsesStreamSink struct {
+	w          io.Writer
+	created    int64
+	seq        int
+	id         string
+	model      any
+	output     []any
+	blocks     map[int]*responsesStreamItem // Anthropic 内容块序号 -> 输出项
+	toolKinds  map[string]string            // 客户端声明的非 function 工具
+	usage      map[string]any
+	stopReason string
+	done       bool
+}
+
+type responsesStreamItem struct {
+	outputIndex int
+	kind        string // text / tool（function_call）/ call（custom_tool_call、local_shell_call，完成时整体输出）
+	id          string
+	block       map[string]any
+	text        strings.Builder
+}
+
+func (s *responsesStreamSink) write(eventType string, payload map[string]any) error {
+	payload["type"] = eventType
+	payload["sequence_number"] = s.seq
+	s.seq++
+	return writeSSE(s.w, eventType, payload)
+}
+
+func (s *responsesStreamSink) response(status string) map[string]any {
+	output := s.output
+	if output == nil {
+		output = []any{}
+	}
+	var usage map[string]any
+	if status != "in_progress" {
+		usage = responsesUsageFromAnthropic(s.usage)
+	}
+	return responsesObject(s.id, s.model, s.created, status, output, usage)
+}
+
+func (s *responsesStreamSink) emit(event map[string]any) error {
+	switch event["type"] {
+	case "message_start":
+		message, _ := event["message"].(map[string]any)
+		s.id = "resp_" + strings.TrimPrefix(stringField(message, "id"), "msg_")
+		s.model = message["model"]
+		s.blocks = map[int]*responsesStreamItem{}
+		s.usage, _ = message["usage"].(map[string]any)
+		if err := s.write("response.created", map[string]any{"response": s.response("in_progress")}); err != nil {
+			return err
+		}
+		return s.write("response.in_progress", map[string]any{"response": s.response("in_progress")})
+
+	case "content_block_start":
+		block, _ := event["content_block"].(map[string]any)
+		item := &responsesStreamItem{outputIndex: len(s.output), block: block}
+		var added map[string]any
+		switch block["type"] {
+		case "text":
+			item.kind = "text"
+			item.id = translateID("msg_")
+			added = map[string]any{"type": "message", "id": item.id, "status": "in_progress", "role": "assistant", "content": []any{}}
+		case "tool_use":
+			item.kind = "tool"
+			if s.toolKinds[stringField(block, "name")] != "" {
+				item.kind = "call"
+			}
+			added = responsesToolCallItem(block, "", "in_progress", s.toolKinds)
+			item.id = stringField(added, "id")
+		default:
+			return nil // thinking 等内容不输出
+		}
+		s.blocks[intField(event, "index")] = item
+		s.output = append(s.output, added)
+		if err := s.write("response.output_item.added", map[string]any{"output_index": item.outputIndex, "item": added}); err != nil {
+			return err
+		}
+		if item.kind == "text" {
+			return s.write("response.content_part.added", map[string]any{
+				"item_id": item.id, "output_index": item.outputIndex, "content_index": 0,
+				"part": map[string]any{"type": "output_text", "text": "", "annotations": []any{}},
+			})
+		}
+
+	case "content_block_delta":
+		item := s.blocks[intField(event, "index")]
+		if item == nil {
+			return nil
+		}
+		delta, _ := event["delta"].(map[string]any)
+		switch delta["type"] {
+		case "text_delta":
+			text := stringField(delta, "text")
+			item.text.WriteString(text)
+			return s.write("response.output_text.delta", map[string]any{
+				"item_id": item.id, "output_index": item.outputIndex, "content_index": 0, "delta": text,
+			})
+		case "input_json_delta":
+			partial := stringField(delta, "partial_json")
+			item.text.WriteString(partial)
+			if item.kind != "tool" {
+				return nil // 参数是 JSON，无法逐段转为自由格式输入
+			}
+			return s.write("response.function_call_arguments.delta", map[string]any{
+				"item_id": item.id, "output_index": item.outputIndex, "delta": partial,
+			})
+		}
+
+	case "content_block_stop":
+		index := intField(event, "index")
+		item := s.blocks[index]
+		if item == nil {
+			return nil
+		}
+		delete(s.blocks, index)
+		if item.kind == "text" {
+			text := item.text.String()
+			part := map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+			done := responsesMessageItem(item.id, text, "completed")
+			s.output[item.outputIndex] = done
+			if err := s.write("response.output_text.done", map[string]any{
+				"item_id": item.id, "output_index": item.outputIndex, "content_index": 0, "text": text,
+			}); err != nil {
+				return err
+			}
+			if err := s.write("response.content_part.done", map[string]any{
+				"item_id": item.id, "output_index": item.outputIndex, "content_index": 0, "part": part,
+			}); err != nil {
+				return err
+			}
+			return s.write("response.output_item.done", map[string]any{"output_index": item.outputIndex, "item": done})
+		}
+		args := item.text.String()
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		done := responsesToolCallItem(item.block, args, "completed", s.toolKinds)
+		s.output[item.outputIndex] = done
+		if item.kind == "call" {
+			return s.write("response.output_item.done", map[string]any{"output_index": item.outputIndex, "item": done})
+		}
+		if err := s.write("response.function_call_arguments.done", map[string]any{
+			"item_id": item.id, "output_index": item.outputIndex, "arguments": args,
+		}); err != nil {
+			return err
+		}
+		return s.write("response.output_item.done", map[string]any{"output_index": item.outputIndex, "item": done})
+
+	case "message_delta":
+		delta, _ := event["delta"].(map[string]any)
+		s.stopReason = stringField(delta, "stop_reason")
+		s.usage = mergeUsage(s.usage, event["usage"])
+
+	case "message_stop":
+		return s.finish()
+
+	case "error":
+		errObj, _ := event["error"].(map[string]any)
+		s.done = true
+		failed := s.response("failed")
+		failed["error"] = map[string]any{"code": stringField(errObj, "type"), "message": stringField(errObj, "message")}
+		return s.write("response.failed", map[string]any{"response": failed})
+	}
+	return nil
+}
+
+func (s *responsesStreamSink) finish() error {
+	if s.done || s.id == "" {
+		return nil
+	}
+	s.done = true
+	return s.write("response.completed", map[string]any{"response": s.response(responsesStatus(s.stopReason))})
+}
+
+// ---- 格式辅助 ----
+
+func responsesObject(id string, model any, created int64, status string, output []any, usage map[string]any) map[string]any {
+	resp := map[string]any{
+		"id":         id,
+		"object":     "response",
+		"created_at": created,
+		"status":     status,
+		"model":      model,
+		"output":     output,
+	}
+	if status == "incomplete" {
+		resp["incomplete_details"] = map[string]any{"reason": "max_output_tokens"}
+	}
+	if usage != nil {
+		resp["usage"] = usage
+	}
+	return resp
+}
+
+func responsesMessageItem(id, text, status string) map[string]any {
+	return map[string]any{
+		"type":    "message",
+		"id":      id,
+		"status":  status,
+		"role":    "assistant",
+		"content": []any{map[string]any{"type": "output_text", "text": text, "annotations": []any{}}},
+	}
+}
+
+func responsesFunctionCallItem(block map[string]any, arguments, status string) map[string]any {
+	return map[string]any{
+		"type":      "function_call",
+		"id":        "fc_" + stringField(block, "id"),
+		"call_id":   block["id"],
+		"name":      block["name"],
+		"arguments": arguments,
+		"status":    status,
+	}
+}
+
+// responsesToolCallItem 按客户端声明的工具类型生成调用项：function_call、custom_tool_call 或 local_shell_call
+func responsesToolCallItem(block map[string]any, arguments, status string, kinds map[string]string) map[string]any {
+	args, _ := parseToolArguments(arguments).(map[string]any)
+	switch kinds[stringField(block, "name")] {
+	case "custom":
+		return map[string]any{
+			"type":    "custom_tool_call",
+			"id":      "ctc_" + stringField(block, "id"),
+			"call_id": block["id"],
+			"name":    block["name"],
+			"input":   stringField(args, "input"),
+		}
+	case "local_shell":
+		action := map[string]any{"type": "exec", "command": firstNonNil(args["command"], []any{}), "env": map[string]any{}}
+		if workdir := stringField(args, "workdir"); workdir != "" {
+			action["working_directory"] = workdir
+		}
+		if timeout, ok := args["timeout_ms"]; ok && timeout != nil {
+			action["timeout_ms"] = timeout
+		}
+		return map[string]any{
+			"type":    "local_shell_call",
+			"id":      "lsh_" + stringField(block, "id"),
+			"call_id": block["id"],
+			"status":  status,
+			"action":  action,
+		}
+	}
+	return responsesFunctionCallItem(block, arguments, status)
+}
+
+func responsesStatus(stopReason string) string {
+	if stopReason == "max_tokens" {
+		return "incomplete"
+	}
+	return "completed"
+}
+
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+func chatFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+// anthropicUsageFromOpenAI OpenAI usage（输入含缓存命中）-> Anthropic usage（输入不含缓存）
+func anthropicUsageFromOpenAI(usage map[string]any) map[string]any {
+	input := max(usageInt(usage, "prompt_tokens"), usageInt(usage, "input_tokens"))
+	cached := usageNestedInt(usage, "prompt_tokens_details", "cached_tokens") + usageNestedInt(usage, "input_tokens_details", "cached_tokens")
+	return map[string]any{
+		"input_tokens":            max(input-cached, 0),
+		"output_tokens":           max(usageInt(usage, "completion_tokens"), usageInt(usage, "output_tokens")),
+		"cache_read_input_tokens": cached,
+	}
+}
+
+func chatUsageFromAnthropic(usage map[string]any) map[string]any {
+	cached := usageInt(usage, "cache_read_input_tokens")
+	input := usageInt(usage, "input_tokens") + cached + usageInt(usage, "cache_creation_input_tokens")
+	output := usageInt(usage, "output_tokens")
+	return map[string]any{
+		"prompt_tokens":         input,
+		"completion_tokens":     output,
+		"total_tokens":          input + output,
+		"prompt_tokens_details": map[string]any{"cached_tokens": cached},
+	}
+}
+
+func responsesUsageFromAnthropic(usage map[string]any) map[string]any {
+	cached := usageInt(usage, "cache_read_input_tokens")
+	input := usageInt(usage, "input_tokens") + cached + usageInt(usage, "cache_creation_input_tokens")
+	output := usageInt(usage, "output_tokens")
+	return map[string]any{
+		"input_tokens":          input,
+		"input_tokens_details":  map[string]any{"cached_tokens": cached},
+		"output_tokens":         output,
+		"output_tokens_details": map[string]any{"reasoning_tokens": 0},
+		"total_tokens":          input + output,
+	}
+}
+
+// mergeUsage 合并流式事件中的 usage（后出现的值覆盖先前的值）
+func mergeUsage(base map[string]any, update any) map[string]any {
+	merged := map[string]any{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	if values, ok := update.(map[string]any); ok {
+		for key, value := range values {
+			if value != nil {
+				merged[key] = value
+			}
+		}
+	}
+	return merged
+}
+
+// anthropicBlocks 将 content（字符串或内容块数组）统一为内容块
+func anthropicBlocks(content any) []map[string]any {
+	switch value := content.(type) {
+	case string:
+		return []map[string]any{{"type": "text", "text": value}}
+	case []any:
+		blocks := make([]map[string]any, 0, len(value))
+		for _, raw := range value {
+			if block, ok := raw.(map[string]any); ok {
+				blocks = append(blocks, block)
+			}
+		}
+		return blocks
+	}
+	return nil
+}
+
+// anthropicText 提取 system / tool_result 等字段中的文本
+func anthropicText(content any) string {
+	var parts []string
+	for _, block := range anthropicBlocks(content) {
+		if block["type"] == "text" {
+			parts = append(parts, stringField(block, "text"))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func anthropicImageURL(block map[string]any) string {
+	source, _ := block["source"].(map[string]any)
+	switch source["type"] {
+	case "base64":
+		return "data:" + stringField(source, "media_type") + ";base64," + stringField(source, "data")
+	case "url":
+		return stringField(source, "url")
+	}
+	return ""
+}
+
+// openAIText 提取 OpenAI content（字符串或 text / input_text / output_text 片段）中的文本
+func openAIText(content any) string {
+	switch value := content.(type) {
+	case string:
+		return value
+	case []any:
+		var parts []string
+		for _, raw := range value {
+			part, _ := raw.(map[string]any)
+			switch part["type"] {
+			case "text", "input_text", "output_text":
+				parts = append(parts, stringField(part, "text"))
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// openAIContentBlocks OpenAI 用户消息内容 -> Anthropic 内容块（文本与图片）
+func openAIContentBlocks(content any) []map[string]any {
+	if text, ok := content.(string); ok {
+		return []map[string]any{{"type": "text", "text": text}}
+	}
+	var blocks []map[string]any
+	for _, raw := range asSlice(content) {
+		part, _ := raw.(map[string]any)
+		switch part["type"] {
+		case "text", "input_text", "output_text":
+			blocks = append(blocks, map[string]any{"type": "text", "text": stringField(part, "text")})
+		case "image_url", "input_image":
+			imageURL := stringField(part, "image_url")
+			if nested, ok := part["image_url"].(map[string]any); ok {
+				imageURL = stringField(nested, "url")
+			}
+			if block := anthropicImageBlock(imageURL); block != nil {
+				blocks = append(blocks, block)
+			}
+		}
+	}
+	return blocks
+}
+
+func anthropicImageBlock(imageURL string) map[string]any {
+	if rest, ok := strings.CutPrefix(imageURL, "data:"); ok {
+		meta, data, found := strings.Cut(rest, ",")
+		mediaType, isBase64 := strings.CutSuffix(meta, ";base64")
+		if !found || !isBase64 {
+			return nil
+		}
+		return map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": mediaType, "data": data}}
+	}
+	if imageURL == "" {
+		return nil
+	}
+	return map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": imageURL}}
+}
+
+// parseToolArguments 工具参数 JSON 字符串 -> 对象；无法解析时保留原文
+func parseToolArguments(arguments any) any {
+	text, ok := arguments.(string)
+	if !ok {
+		if arguments == nil {
+			return map[string]any{}
+		}
+		return arguments
+	}
+	if strings.TrimSpace(text) == "" {
+		return map[string]any{}
+	}
+	var parsed any
+	if err := json.Unmarshal([]byte(text), &parsed); err != nil {
+		return map[string]any{"arguments": text}
+	}
+	return parsed
+}
+
+func copyFields(dst, src map[string]any, keys ...string) {
+	for _, key := range keys {
+		if value, ok := src[key]; ok && value != nil {
+			dst[key] = value
+		}
+	}
+}
+
+func firstNonNil(values ...any) any {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+func asSlice(value any) []any {
+	slice, _ := value.([]any)
+	return slice
+}
+
+func stringField(m map[string]any, key string) string {
+	value, _ := m[key].(string)
+	return value
+}
+
+// intField 读取整数字段；事件可能来自 JSON（float64）或进程内的转换（int / int64）
+func intField(m map[string]any, key string) int {
+	return int(usageInt(m, key))
+}
+
+func translateID(prefix string) string {
+	buf := make([]byte, 12)
+	_, _ = rand.Read(buf)
+	return prefix + hex.EncodeToString(buf)
+}
+
+// readSSE 逐个事件读取 SSE 流；fn 返回 io.EOF 时提前结束
+func readSSE(r io.Reader, fn func(event string, data []byte) error) error {
+	reader := bufio.NewReader(r)
+	var event string
+	var data bytes.Buffer
+	dispatch := func() error {
+		if data.Len() == 0 {
+			event = ""
+			return nil
+		}
+		err := fn(event, bytes.TrimSuffix(data.Bytes(), []byte("\n")))
+		event = ""
+		data.Reset()
+		return err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case trimmed == "" && line != "":
+			if dispatchErr := dispatch(); dispatchErr != nil {
+				if dispatchErr == io.EOF {
+					return nil
+				}
+				return dispatchErr
+			}
+		case strings.HasPrefix(trimmed, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(trimmed, "event:"))
+		case strings.HasPrefix(trimmed, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(trimmed, "data:"), " "))
+			data.WriteByte('\n')
+		}
+		if err != nil {
+			if err == io.EOF {
+				if dispatchErr := dispatch(); dispatchErr != nil && dispatchErr != io.EOF {
+					return dispatchErr
+				}
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func writeSSE(w io.Writer, event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+func writeSSEData(w io.Writer, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
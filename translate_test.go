@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "重新生成 testdata 中的 golden 文件")
+
+// randomItemIDs translateID 生成的随机输出项 ID，比较前替换为固定值
+var randomItemIDs = regexp.MustCompile(`msg_[0-9a-f]{24}`)
+
+func decodeJSON(t *testing.T, text string) map[string]any {
+	t.Helper()
+	var v map[string]any
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", text, err)
+	}
+	return v
+}
+
+// assertJSONEqual 比较转换结果与期望的 JSON（经过一次序列化，忽略数值类型差异）
+func assertJSONEqual(t *testing.T, got any, want string) {
+	t.Helper()
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		t.Fatal(err)
+	}
+	var expected any
+	if err := json.Unmarshal([]byte(want), &expected); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+	if !reflect.DeepEqual(normalized, expected) {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestTranslateRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		convert func(map[string]any) map[string]any
+		in      string
+		want    string
+	}{
+		{
+			name:    "anthropic to chat",
+			convert: anthropicRequestToChat,
+			in: `{"model":"m","max_tokens":100,"stream":true,"system":[{"type":"text","text":"be brief"}],"stop_sequences":["END"],
+				"messages":[
+					{"role":"user","content":[{"type":"text","text":"look"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAA"}}]},
+					{"role":"assistant","content":[{"type":"text","text":"ok"},{"type":"tool_use","id":"t1","name":"read","input":{"path":"a"}}]},
+					{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"data"}]}],
+				"tools":[{"name":"read","description":"Read","input_schema":{"type":"object"}},{"type":"web_search_20250305","name":"web_search"}],
+				"tool_choice":{"type":"any"}}`,
+			want: `{"model":"m","max_tokens":100,"stream":true,"stream_options":{"include_usage":true},"stop":["END"],
+				"messages":[
+					{"role":"system","content":"be brief"},
+					{"role":"user","content":[{"type":"text","text":"look"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAA"}}]},
+					{"role":"assistant","content":"ok","tool_calls":[{"id":"t1","type":"function","function":{"name":"read","arguments":"{\"path\":\"a\"}"}}]},
+					{"role":"tool","tool_call_id":"t1","content":"data"}],
+				"tools":[{"type":"function","function":{"name":"read","description":"Read","parameters":{"type":"object"}}}],
+				"tool_choice":"required"}`,
+		},
+		{
+			name:    "chat to anthropic",
+			convert: chatRequestToAnthropic,
+			in: `{"model":"m","max_completion_tokens":50,"stop":"END",
+				"messages":[
+					{"role":"system","content":"be brief"},
+					{"role":"user","content":"hi"},
+					{"role":"assistant","content":null,"tool_calls":[{"id":"c1","type":"function","function":{"name":"read","arguments":"{\"path\":\"a\"}"}}]},
+					{"role":"tool","tool_call_id":"c1","content":"data"},
+					{"role":"user","content":"thanks"}],
+				"tools":[{"type":"function","function":{"name":"read","parameters":{"type":"object"}}}],
+				"tool_choice":{"type":"function","function":{"name":"read"}}}`,
+			want: `{"model":"m","max_tokens":50,"stop_sequences":["END"],"system":"be brief",
+				"messages":[
+					{"role":"user","content":[{"type":"text","text":"hi"}]},
+					{"role":"assistant","content":[{"type":"tool_use","id":"c1","name":"read","input":{"path":"a"}}]},
+					{"role":"user","content":[{"type":"tool_result","tool_use_id":"c1","content":"data"},{"type":"text","text":"thanks"}]}],
+				"tools":[{"name":"read","input_schema":{"type":"object"}}],
+				"tool_choice":{"type":"tool","name":"read"}}`,
+		},
+		{
+			name:    "anthropic to responses",
+			convert: anthropicRequestToResponses,
+			in: `{"model":"m","max_tokens":100,"stream":true,"system":"be brief",
+				"messages":[
+					{"role":"user","content":"hi"},
+					{"role":"assistant","content":[{"type":"text","text":"reading"},{"type":"tool_use","id":"t1","name":"read","input":{"path":"a"}}]},
+					{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"data"}]},{"type":"text","text":"go on"}]}],
+				"tools":[{"name":"read","description":"Read","input_schema":{"type":"object"}}],
+				"tool_choice":{"type":"tool","name":"read"}}`,
+			want: `{"model":"m","store":false,"stream":true,"max_output_tokens":100,"instructions":"be brief",
+				"input":[
+					{"type":"message","role":"user","content":[{"type":"input_text","text":"hi"}]},
+					{"type":"message","role":"assistant","content":[{"type":"output_text","text":"reading"}]},
+					{"type":"function_call","call_id":"t1","name":"read","arguments":"{\"path\":\"a\"}"},
+					{"type":"function_call_output","call_id":"t1","output":"data"},
+					{"type":"message","role":"user","content":[{"type":"input_text","text":"go on"}]}],
+				"tools":[{"type":"function","name":"read","description":"Read","parameters":{"type":"object"}}],
+				"tool_choice":{"type":"function","name":"read"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertJSONEqual(t, tt.convert(decodeJSON(t, tt.in)), tt.want)
+		})
+	}
+}
+
+func TestResponsesRequestToAnthropic(t *testing.T) {
+	req := decodeJSON(t, `{"model":"m","instructions":"sys","max_output_tokens":64,
+		"input":[
+			{"type":"message","role":"developer","content":[{"type":"input_text","text":"dev"}]},
+			{"type":"message","role":"user","content":[{"type":"input_text","text":"fix it"}]},
+			{"type":"function_call","call_id":"c1","name":"read","arguments":"{\"path\":\"a\"}"},
+			{"type":"function_call_output","call_id":"c1","output":"data"},
+			{"type":"custom_tool_call","call_id":"c2","name":"apply_patch","input":"*** Begin Patch"},
+			{"type":"custom_tool_call_output","call_id":"c2","output":"Done"},
+			{"type":"local_shell_call","call_id":"c3","action":{"type":"exec","command":["ls"],"working_directory":"/w"}},
+			{"type":"local_shell_call_output","id":"c3","output":"a.go"}],
+		"tools":[
+			{"type":"function","name":"read","parameters":{"type":"object"}},
+			{"type":"custom","name":"apply_patch","description":"Patch","format":{"type":"grammar","syntax":"lark","definition":"start: x"}},
+			{"type":"local_shell"}]}`)
+	got, kinds, err := responsesRequestToAnthropic(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSONEqual(t, got, `{"model":"m","max_tokens":64,"system":"sys\n\ndev",
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"fix it"}]},
+			{"role":"assistant","content":[{"type":"tool_use","id":"c1","name":"read","input":{"path":"a"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"c1","content":"data"}]},
+			{"role":"assistant","content":[{"type":"tool_use","id":"c2","name":"apply_patch","input":{"input":"*** Begin Patch"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"c2","content":"Done"}]},
+			{"role":"assistant","content":[{"type":"tool_use","id":"c3","name":"local_shell","input":{"command":["ls"],"workdir":"/w"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"c3","content":"a.go"}]}],
+		"tools":[
+			{"name":"read","input_schema":{"type":"object"}},
+			{"name":"apply_patch","description":"Patch","input_schema":{"type":"object","required":["input"],
+				"properties":{"input":{"type":"string","description":"Raw tool input. It must match this lark grammar:\nstart: x"}}}},
+			{"name":"local_shell","description":"Runs a shell command and returns its output.","input_schema":{"type":"object","required":["command"],
+				"properties":{"command":{"type":"array","items":{"type":"string"}},"workdir":{"type":"string"},"timeout_ms":{"type":"integer"}}}}]}`)
+	if want := map[string]string{"apply_patch": "custom", "local_shell": "local_shell"}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("kinds = %v, want %v", kinds, want)
+	}
+
+	for _, toolType := range []string{"web_search", "file_search", "mcp"} {
+		req := decodeJSON(t, `{"model":"m","input":"hi","tools":[{"type":"`+toolType+`"}]}`)
+		if _, _, err := responsesRequestToAnthropic(req); err == nil || !strings.Contains(err.Error(), toolType) {
+			t.Errorf("%s: expected an error naming the tool type, got %v", toolType, err)
+		}
+	}
+}
+
+func TestTranslateResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		convert func(map[string]any) map[string]any
+		in      string
+		want    string
+	}{
+		{
+			name:    "chat to anthropic",
+			convert: chatResponseToAnthropic,
+			in: `{"id":"chatcmpl-1","model":"gpt","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"ok",
+				"tool_calls":[{"id":"c1","type":"function","function":{"name":"read","arguments":"{\"path\":\"a\"}"}}]}}],
+				"usage":{"prompt_tokens":30,"completion_tokens":5,"prompt_tokens_details":{"cached_tokens":10}}}`,
+			want: `{"id":"msg_1","type":"message","role":"assistant","model":"gpt","stop_reason":"tool_use","stop_sequence":null,
+				"content":[{"type":"text","text":"ok"},{"type":"tool_use","id":"c1","name":"read","input":{"path":"a"}}],
+				"usage":{"input_tokens":20,"output_tokens":5,"cache_read_input_tokens":10}}`,
+		},
+		{
+			name:    "responses to anthropic",
+			convert: responsesResponseToAnthropic,
+			in: `{"id":"resp_1","model":"gpt-5","status":"completed","output":[
+				{"type":"reasoning","id":"rs_1","summary":[]},
+				{"type":"message","role":"assistant","content":[{"type":"output_text","text":"ok"}]},
+				{"type":"function_call","call_id":"c1","name":"read","arguments":"{\"path\":\"a\"}"}],
+				"usage":{"input_tokens":30,"output_tokens":5,"input_tokens_details":{"cached_tokens":10}}}`,
+			want: `{"id":"msg_1","type":"message","role":"assistant","model":"gpt-5","stop_reason":"tool_use","stop_sequence":null,
+				"content":[{"type":"text","text":"ok"},{"type":"tool_use","id":"c1","name":"read","input":{"path":"a"}}],
+				"usage":{"input_tokens":20,"output_tokens":5,"cache_read_input_tokens":10}}`,
+		},
+		{
+			name: "anthropic to chat",
+			convert: func(msg map[string]any) map[string]any {
+				out := anthropicResponseToChat(msg)
+				delete(out, "created")
+				return out
+			},
+			in: `{"id":"msg_1","model":"claude","stop_reason":"max_tokens","content":[{"type":"text","text":"partial"}],
+				"usage":{"input_tokens":10,"output_tokens":3,"cache_read_input_tokens":5}}`,
+			want: `{"id":"chatcmpl-1","object":"chat.completion","model":"claude",
+				"choices":[{"index":0,"finish_reason":"length","message":{"role":"assistant","content":"partial"}}],
+				"usage":{"prompt_tokens":15,"completion_tokens":3,"total_tokens":18,"prompt_tokens_details":{"cached_tokens":5}}}`,
+		},
+		{
+			name: "anthropic to responses with custom and shell tools",
+			convert: func(msg map[string]any) map[string]any {
+				out := anthropicResponseToResponses(msg, map[string]string{"apply_patch": "custom", "local_shell": "local_shell"})
+				delete(out, "created_at")
+				return out
+			},
+			in: `{"id":"msg_1","model":"claude","stop_reason":"tool_use","content":[
+				{"type":"tool_use","id":"t1","name":"read","input":{"path":"a"}},
+				{"type":"tool_use","id":"t2","name":"apply_patch","input":{"input":"*** Begin Patch"}},
+				{"type":"tool_use","id":"t3","name":"local_shell","input":{"command":["ls","-l"],"workdir":"/w","timeout_ms":1000}}],
+				"usage":{"input_tokens":10,"output_tokens":3}}`,
+			want: `{"id":"resp_1","object":"response","status":"completed","model":"claude","output":[
+				{"type":"function_call","id":"fc_t1","call_id":"t1","name":"read","arguments":"{\"path\":\"a\"}","status":"completed"},
+				{"type":"custom_tool_call","id":"ctc_t2","call_id":"t2","name":"apply_patch","input":"*** Begin Patch"},
+				{"type":"local_shell_call","id":"lsh_t3","call_id":"t3","status":"completed",
+					"action":{"type":"exec","command":["ls","-l"],"working_directory":"/w","timeout_ms":1000,"env":{}}}],
+				"usage":{"input_tokens":10,"input_tokens_details":{"cached_tokens":0},"output_tokens":3,"output_tokens_details":{"reasoning_tokens":0},"total_tokens":13}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertJSONEqual(t, tt.convert(decodeJSON(t, tt.in)), tt.want)
+		})
+	}
+}
+
+// TestTranslateStreams 将 testdata/translate 中的事件流逐个转换，与 golden 文件比较（go test -update 重新生成）
+func TestTranslateStreams(t *testing.T) {
+	// replay 把 Anthropic 事件流交给 sink，模拟 streamBody 中 Anthropic 上游的处理
+	replay := func(sink anthropicEventSink) func(io.Reader) error {
+		return func(r io.Reader) error {
+			err := readSSE(r, func(_ string, data []byte) error {
+				var event map[string]any
+				if json.Unmarshal(data, &event) != nil {
+					return nil
+				}
+				return sink.emit(event)
+			})
+			if err != nil {
+				return err
+			}
+			return sink.finish()
+		}
+	}
+	tests := []struct {
+		input, golden string
+		convert       func(r io.Reader, w io.Writer) error
+	}{
+		{
+			input:  "chat_tools.sse",
+			golden: "chat_tools.anthropic.golden",
+			convert: func(r io.Reader, w io.Writer) error {
+				return chatStreamToAnthropic(r, (&anthropicStreamSink{w: w}).emit)
+			},
+		},
+		{
+			input:  "responses_tools.sse",
+			golden: "responses_tools.anthropic.golden",
+			convert: func(r io.Reader, w io.Writer) error {
+				return responsesStreamToAnthropic(r, (&anthropicStreamSink{w: w}).emit)
+			},
+		},
+		{
+			input:  "anthropic_tools.sse",
+			golden: "anthropic_tools.chat.golden",
+			convert: func(r io.Reader, w io.Writer) error {
+				return replay(&chatStreamSink{w: w, includeUsage: true})(r)
+			},
+		},
+		{
+			input:  "anthropic_tools.sse",
+			golden: "anthropic_tools.responses.golden",
+			convert: func(r io.Reader, w io.Writer) error {
+				return replay(&responsesStreamSink{w: w, toolKinds: map[string]string{"apply_patch": "custom"}})(r)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			in, err := os.Open(filepath.Join("testdata", "translate", tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			var out bytes.Buffer
+			if err := tt.convert(in, &out); err != nil {
+				t.Fatal(err)
+			}
+			got := randomItemIDs.ReplaceAll(out.Bytes(), []byte("msg_ID"))
+
+			goldenPath := filepath.Join("testdata", "translate", tt.golden)
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestChatStreamToAnthropicInterleavedTools 交错到达的 tool_calls 序号不会向已结束的块写入增量
+func TestChatStreamToAnthropicInterleavedTools(t *testing.T) {
+	in, err := os.ReadFile(filepath.Join("testdata", "translate", "chat_tools.sse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := map[int]bool{}
+	stopped := map[int]bool{}
+	err = chatStreamToAnthropic(bytes.NewReader(in), func(event map[string]any) error {
+		index := intField(event, "index")
+		switch event["type"] {
+		case "content_block_start":
+			if len(open) > 0 || stopped[index] {
+				t.Errorf("block %d started while another block is open or after it stopped", index)
+			}
+			open[index] = true
+		case "content_block_delta":
+			if !open[index] {
+				t.Errorf("delta for block %d which is not open", index)
+			}
+		case "content_block_stop":
+			delete(open, index)
+			stopped[index] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSSE(t *testing.T) {
+	type event struct{ name, data string }
+	tests := []struct {
+		name string
+		in   string
+		want []event
+	}{
+		{
+			name: "named events and comments",
+			in:   ": keep-alive\nevent: ping\ndata: {}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			want: []event{{"ping", "{}"}, {"message_stop", `{"type":"message_stop"}`}},
+		},
+		{
+			name: "multi-line data joined with newline",
+			in:   "data: line1\ndata: line2\n\n",
+			want: []event{{"", "line1\nline2"}},
+		},
+		{
+			name: "CRLF and no space after colon",
+			in:   "event:a\r\ndata:x\r\n\r\n",
+			want: []event{{"a", "x"}},
+		},
+		{
+			name: "last event without trailing blank line",
+			in:   "data: first\n\ndata: last",
+			want: []event{{"", "first"}, {"", "last"}},
+		},
+		{
+			name: "event line without data is dropped",
+			in:   "event: lonely\n\ndata: next\n\n",
+			want: []event{{"", "next"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []event
+			err := readSSE(strings.NewReader(tt.in), func(name string, data []byte) error {
+				got = append(got, event{name, string(data)})
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// fn 返回 io.EOF 时提前结束且不报错
+	var seen int
+	err := readSSE(strings.NewReader("data: 1\n\ndata: [DONE]\n\ndata: 2\n\n"), func(_ string, data []byte) error {
+		seen++
+		if string(data) == "[DONE]" {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil || seen != 2 {
+		t.Errorf("early stop: seen=%d err=%v", seen, err)
+	}
+}
@@ -19,6 +19,14 @@ const uptimeStoreFile = "uptime.json"
 type UptimeService struct {
 	mu  sync.Mutex
 	app *App
+
+	// groups 轮换组的内存缓存，saveStore 时更新；网关每个请求都要读取，不能反复解析 uptime.json
+	groupsMu     sync.RWMutex
+	groups       []RotationGroup
+	groupsLoaded bool
+
+	// lastBalanceCheck 上次定时查询余额的时间（受 mu 保护），余额查询按自己的间隔执行
+	lastBalanceCheck time.Time
 }
 
 func NewUptimeService(app *App) *UptimeService {
@@ -30,6 +38,8 @@ type UptimeSettings struct {
 	IntervalSeconds int  `json:"interval_seconds"`
 	TimeoutSeconds  int  `json:"timeout_seconds"`
 	KeepLast        int  `json:"keep_last"`
+	// BalanceIntervalSeconds 定时查询中转站余额的间隔，余额变化慢且查询会计入站点请求，比可用性检查稀疏
+	BalanceIntervalSeconds int `json:"balance_interval_seconds"`
 }
 
 type RotationGroup struct {
@@ -38,14 +48,31 @@ type RotationGroup struct {
 	EnvNames         []string `json:"env_names"`
 	Enabled          bool     `json:"enabled"`
 	FailureThreshold int      `json:"failure_threshold"`
+	// Gateway 为 true 时由本地网关按请求分发与故障转移，不再按探测结果改写配置文件
+	Gateway         bool           `json:"gateway"`
+	Strategy        string         `json:"strategy,omitempty"` // failover（默认）/ round_robin / weighted
+	Weights         map[string]int `json:"weights,omitempty"`  // 环境名 -> 权重（weighted），0 表示仅作备用
+	CooldownSeconds int            `json:"cooldown_seconds,omitempty"`
 }
 
+// 网关分发策略
+const (
+	rotationStrategyFailover   = "failover"    // 优先当前环境，失败时按顺序尝试下一个
+	rotationStrategyRoundRobin = "round_robin" // 依次轮流
+	rotationStrategyWeighted   = "weighted"    // 按权重平滑分配
+)
+
 type UptimeCheck struct {
 	At         int64  `json:"at"`
 	Success    bool   `json:"success"`
 	StatusCode int    `json:"status_code"`
 	LatencyMs  int64  `json:"latency_ms"`
 	Error      string `json:"error,omitempty"`
+	// 耗时分解（毫秒），连接复用时 DNS / TCP / TLS 为 0
+	DNSMs     float64 `json:"dns_ms,omitempty"`
+	ConnectMs float64 `json:"connect_ms,omitempty"`
+	TLSMs     float64 `json:"tls_ms,omitempty"`
+	TTFBMs    float64 `json:"ttfb_ms,omitempty"`
 }
 
 type UptimeSnapshot struct {
@@ -83,6 +110,10 @@ func (us *UptimeService) SaveSettings(settings UptimeSettings) error {
 		return err
 	}
 
+	// 未提供余额查询间隔时沿用已保存的值
+	if settings.BalanceIntervalSeconds <= 0 {
+		settings.BalanceIntervalSeconds = store.Settings.BalanceIntervalSeconds
+	}
 	store.Settings = normalizeUptimeSettings(settings)
 	return us.saveStore(store)
 }
@@ -160,18 +191,20 @@ func (us *UptimeService) RunOnce() (UptimeSnapshot, error) {
 		return us.buildSnapshot(store), nil
 	}
 
-	config := us.app.GetConfig()
+	config := us.app.configSnapshot()
 
 	timeout := time.Duration(store.Settings.TimeoutSeconds) * time.Second
-	client := &http.Client{Timeout: timeout}
 
+	// 每个环境按自己的代理设置检查
 	urls := make(map[string]string)
-	for _, env := range config.Environments {
+	clients := make(map[string]*http.Client)
+	for i, env := range config.Environments {
 		url := deriveEnvURL(env)
 		if strings.TrimSpace(url) == "" {
 			continue
 		}
 		urls[env.Name] = url
+		clients[env.Name] = newProxyClient(us.app.effectiveProxy(&config.Environments[i]), timeout)
 	}
 
 	if store.History == nil {
@@ -180,14 +213,25 @@ func (us *UptimeService) RunOnce() (UptimeSnapshot, error) {
 
 	// 逐个检查（避免并发导致 UI 卡顿/过多连接）
 	for name, url := range urls {
-		check := runUptimeCheck(client, url)
+		check := runUptimeCheck(clients[name], url)
 		store.History[name] = appendAndTrim(store.History[name], check, store.Settings.KeepLast)
 	}
 
+	// 按余额间隔顺带查询中转站余额；余额不足的环境在轮换时视为不可用
+	balanceInterval := time.Duration(store.Settings.BalanceIntervalSeconds) * time.Second
+	if time.Since(us.lastBalanceCheck) >= balanceInterval {
+		us.lastBalanceCheck = time.Now()
+		balances := refreshBalances(config, func(env *EnvConfig) *http.Client {
+			return newProxyClient(us.app.effectiveProxy(env), timeout)
+		})
+		_ = recordBalanceChecks(balances)
+	}
+	lowBalance := lowBalanceEnvs(config)
+
 	// 轮换：按组评估当前激活环境的连续失败次数
 	for _, group := range store.Groups {
 		group = normalizeRotationGroup(group)
-		if !group.Enabled {
+		if !group.Enabled || group.Gateway {
 			continue
 		}
 		if len(group.EnvNames) == 0 {
@@ -205,11 +249,11 @@ func (us *UptimeService) RunOnce() (UptimeSnapshot, error) {
 
 		history := store.History[activeName]
 		failCount := consecutiveFailures(history)
-		if failCount < group.FailureThreshold {
+		if failCount < group.FailureThreshold && !lowBalance[activeName] {
 			continue
 		}
 
-		nextName := pickNextHealthy(group.EnvNames, currentIndex, store.History)
+		nextName := pickNextHealthy(group.EnvNames, currentIndex, store.History, lowBalance)
 		if nextName == "" || nextName == activeName {
 			continue
 		}
@@ -219,7 +263,7 @@ func (us *UptimeService) RunOnce() (UptimeSnapshot, error) {
 		_, _ = us.app.ApplyCurrentEnv()
 
 		// 更新本地 config 快照，避免多个组使用旧值
-		config = us.app.GetConfig()
+		config = us.app.configSnapshot()
 	}
 
 	if err := us.saveStore(store); err != nil {
@@ -230,7 +274,7 @@ func (us *UptimeService) RunOnce() (UptimeSnapshot, error) {
 }
 
 func (us *UptimeService) buildSnapshot(store uptimeStore) UptimeSnapshot {
-	config := us.app.GetConfig()
+	config := us.app.configSnapshot()
 	urls := make(map[string]string)
 	for _, env := range config.Environments {
 		url := deriveEnvURL(env)
@@ -326,11 +370,11 @@ func (us *UptimeService) saveStore(store uptimeStore) error {
 	if store.History == nil {
 		store.History = map[string][]UptimeCheck{}
 	}
-	data, err := json.MarshalIndent(store, "", "  ")
-	if err != nil {
+	if err := writeJSONFile(path, store, 0o644); err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0o644)
+	us.cacheRotationGroups(store.Groups)
+	return nil
 }
 
 func normalizeUptimeSettings(settings UptimeSettings) UptimeSettings {
@@ -347,6 +391,13 @@ func normalizeUptimeSettings(settings UptimeSettings) UptimeSettings {
 	if out.KeepLast > 50 {
 		out.KeepLast = 50
 	}
+	if out.BalanceIntervalSeconds <= 0 {
+		out.BalanceIntervalSeconds = 900
+	}
+	// 不超过 balanceLowMaxAge 的一半，两次查询之间的读数不会过期
+	if maxInterval := int(balanceLowMaxAge / time.Second / 2); out.BalanceIntervalSeconds > maxInterval {
+		out.BalanceIntervalSeconds = maxInterval
+	}
 	return out
 }
 
@@ -357,9 +408,52 @@ func normalizeRotationGroup(group RotationGroup) RotationGroup {
 	if group.FailureThreshold <= 0 {
 		group.FailureThreshold = 3
 	}
+	group.Strategy = strings.ToLower(strings.TrimSpace(group.Strategy))
+	if group.Strategy == "" {
+		group.Strategy = rotationStrategyFailover
+	}
+	if group.CooldownSeconds <= 0 {
+		group.CooldownSeconds = 60
+	}
 	return group
 }
 
+// rotationGroups 读取轮换组缓存；不持有 us.mu，避免网关请求等待正在进行的检查。
+// 返回的切片只读，调用方不得修改
+func (us *UptimeService) rotationGroups() []RotationGroup {
+	us.groupsMu.RLock()
+	groups, loaded := us.groups, us.groupsLoaded
+	us.groupsMu.RUnlock()
+	if loaded {
+		return groups
+	}
+	store, err := us.loadStore()
+	if err != nil {
+		return nil
+	}
+	return us.cacheRotationGroups(store.Groups)
+}
+
+// cacheRotationGroups 用已保存的轮换组替换缓存
+func (us *UptimeService) cacheRotationGroups(stored []RotationGroup) []RotationGroup {
+	groups := make([]RotationGroup, 0, len(stored))
+	for _, group := range stored {
+		group = normalizeRotationGroup(group)
+		if group.Weights != nil {
+			weights := make(map[string]int, len(group.Weights))
+			for name, weight := range group.Weights {
+				weights[name] = weight
+			}
+			group.Weights = weights
+		}
+		groups = append(groups, group)
+	}
+	us.groupsMu.Lock()
+	us.groups, us.groupsLoaded = groups, true
+	us.groupsMu.Unlock()
+	return groups
+}
+
 func normalizeStringList(values []string) []string {
 	seen := map[string]struct{}{}
 	result := make([]string, 0, len(values))
@@ -392,6 +486,19 @@ func (us *UptimeService) validateRotationGroup(group RotationGroup) error {
 	if group.FailureThreshold <= 0 {
 		return fmt.Errorf("失败阈值必须 >= 1")
 	}
+	switch group.Strategy {
+	case rotationStrategyFailover, rotationStrategyRoundRobin, rotationStrategyWeighted:
+	default:
+		return fmt.Errorf("不支持的分发策略：%s", group.Strategy)
+	}
+	for name, weight := range group.Weights {
+		if indexOfString(group.EnvNames, name) < 0 {
+			return fmt.Errorf("权重包含不在轮换组中的配置：%s", name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("权重不能为负数：%s", name)
+		}
+	}
 
 	config := us.app.GetConfig()
 	envProvider := map[string]string{}
@@ -423,13 +530,7 @@ func deriveEnvURL(env EnvConfig) string {
 	}
 	switch provider {
 	case "claude":
-		if v := strings.TrimSpace(vars["ANTHROPIC_BASE_URL"]); v != "" {
-			return v
-		}
-		if v := strings.TrimSpace(vars["API_BASE_URL"]); v != "" {
-			return v
-		}
-		return ""
+		return claudeBackendURL(env)
 	case "codex":
 		return strings.TrimSpace(vars["base_url"])
 	case "gemini":
@@ -441,9 +542,15 @@ func deriveEnvURL(env EnvConfig) string {
 	}
 }
 
-func runUptimeCheck(client *http.Client, url string) UptimeCheck {
+func runUptimeCheck(client *http.Client, url string) (check UptimeCheck) {
 	start := time.Now()
-	check := UptimeCheck{At: start.Unix()}
+	check = UptimeCheck{At: start.Unix()}
+	var timing TimingSample
+	tracer := &timingTracer{sample: &timing}
+	defer func() {
+		tracer.finish(start)
+		check.DNSMs, check.ConnectMs, check.TLSMs, check.TTFBMs = timing.DNSMs, timing.ConnectMs, timing.TLSMs, timing.TTFBMs
+	}()
 
 	req, err := http.NewRequest(http.MethodHead, url, nil)
 	if err != nil {
@@ -452,7 +559,7 @@ func runUptimeCheck(client *http.Client, url string) UptimeCheck {
 		return check
 	}
 
-	resp, err := client.Do(req)
+	resp, err := client.Do(tracer.trace(req))
 	if err != nil {
 		// fallback GET
 		req, reqErr := http.NewRequest(http.MethodGet, url, nil)
@@ -461,7 +568,10 @@ func runUptimeCheck(client *http.Client, url string) UptimeCheck {
 			check.Error = err.Error()
 			return check
 		}
-		resp, err = client.Do(req)
+		start = time.Now()
+		timing = TimingSample{}
+		tracer = &timingTracer{sample: &timing}
+		resp, err = client.Do(tracer.trace(req))
 	}
 	if err != nil {
 		check.Success = false
@@ -524,7 +634,7 @@ func indexOfString(values []string, target string) int {
 	return -1
 }
 
-func pickNextHealthy(values []string, currentIndex int, history map[string][]UptimeCheck) string {
+func pickNextHealthy(values []string, currentIndex int, history map[string][]UptimeCheck, lowBalance map[string]bool) string {
 	if len(values) == 0 {
 		return ""
 	}
@@ -532,10 +642,13 @@ func pickNextHealthy(values []string, currentIndex int, history map[string][]Upt
 		return ""
 	}
 
-	// 优先挑选最近一次成功或尚未检测过的
+	// 优先挑选最近一次成功或尚未检测过的（跳过余额不足的）
 	for offset := 1; offset <= len(values); offset++ {
 		idx := (currentIndex + offset) % len(values)
 		name := values[idx]
+		if lowBalance[name] {
+			continue
+		}
 		h := history[name]
 		if len(h) == 0 {
 			return name